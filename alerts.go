@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ================= 提醒记录 =================
+
+// AlertRecord 一次已发送的信号提醒，以及之后由K线数据补充的远期收益
+type AlertRecord struct {
+	ID         uint               `gorm:"primaryKey" json:"id"`
+	Symbol     string             `gorm:"index" json:"symbol"`
+	Rule       string             `gorm:"index" json:"rule"`
+	Interval   string             `json:"interval"`
+	BarTime    int64              `gorm:"index" json:"bar_time"` // 触发K线的开盘时间
+	Price      float64            `json:"price"`                 // 触发K线的收盘价
+	Indicators map[string]float64 `gorm:"serializer:json" json:"indicators"`
+	Return1h   *float64           `json:"return_1h"` // 触发K线收盘后 1h 的收益率，尚无数据时为 null
+	Return4h   *float64           `json:"return_4h"`
+	Return24h  *float64           `json:"return_24h"`
+	Complete   bool               `gorm:"index" json:"complete"` // 远期收益已全部计算或已放弃
+	CreatedAt  time.Time          `json:"created_at"`
+}
+
+// alertHorizon 远期收益的观察时长
+type alertHorizon struct {
+	Name     string
+	Duration time.Duration
+	field    func(a *AlertRecord) **float64
+}
+
+var alertHorizons = []alertHorizon{
+	{"1h", time.Hour, func(a *AlertRecord) **float64 { return &a.Return1h }},
+	{"4h", 4 * time.Hour, func(a *AlertRecord) **float64 { return &a.Return4h }},
+	{"24h", 24 * time.Hour, func(a *AlertRecord) **float64 { return &a.Return24h }},
+}
+
+// alertGiveUp 超过最长观察时长后再等待这么久仍缺数据就不再补充
+const alertGiveUp = 24 * time.Hour
+
+// migrateAlertTables 创建提醒记录表
+func migrateAlertTables(db *gorm.DB) error {
+	return db.AutoMigrate(&AlertRecord{})
+}
+
+// operandLabel 返回取值在提醒记录中的名称，例如 4h:macd(12,26,9).hist 或 close[-1]
+func operandLabel(o Operand, interval string) string {
+	if o.Indicator != "" && o.Output == "" {
+		o.Output = indicatorRegistry[strings.ToLower(o.Indicator)].Outputs[0]
+	}
+	label := o.key()
+	if o.Interval != "" && o.Interval != interval {
+		label = o.Interval + ":" + label
+	}
+	if o.Offset > 0 {
+		label += fmt.Sprintf("[-%d]", o.Offset)
+	}
+	return label
+}
+
+// snapshot 返回规则条件中各取值在最后一根K线上的值，常量和 NaN 不记录
+func (r SignalRule) snapshot(klines []Kline, load klineLoader) map[string]float64 {
+	values := map[string]float64{}
+	if len(klines) == 0 {
+		return values
+	}
+	f := newRuleFrame(r.Interval, klines, r.Limit, load)
+	for _, o := range r.When.operands() {
+		if o.Value != nil {
+			continue
+		}
+		if v := f.value(o, len(klines)-1); !math.IsNaN(v) && !math.IsInf(v, 0) {
+			values[operandLabel(o, r.Interval)] = v
+		}
+	}
+	return values
+}
+
+// recordAlert 保存一次提醒，s 为生成通知时的代币数据
+func recordAlert(db *gorm.DB, rule SignalRule, s AlertSymbol) error {
+	if s.BarTime == 0 {
+		return nil
+	}
+	return db.Create(&AlertRecord{
+		Symbol:     s.Symbol,
+		Rule:       rule.Name,
+		Interval:   rule.Interval,
+		BarTime:    s.BarTime,
+		Price:      s.Price,
+		Indicators: s.Indicators,
+	}).Error
+}
+
+// forwardClose 返回 at 之后第一根已收盘的15m K线的收盘价
+func forwardClose(db *gorm.DB, symbol string, at, now int64) (float64, bool) {
+	var k Kline
+	err := db.Table(Kline{Symbol: symbol}.TableName()).
+		Where("open_time >= ? AND close_time < ? AND is_closed = ?", at-intervalMillis("15m"), now, true).
+		Order("open_time ASC").Limit(1).Find(&k).Error
+	if err != nil || k.OpenTime == 0 {
+		return 0, false
+	}
+	return k.Close, true
+}
+
+// enrichAlert 补充提醒缺少的远期收益，返回记录是否有变化
+func enrichAlert(db *gorm.DB, a *AlertRecord, now time.Time) bool {
+	if a.Price <= 0 {
+		a.Complete = true
+		return true
+	}
+	triggerClose := a.BarTime + intervalMillis(a.Interval)
+	changed, complete := false, true
+	for _, h := range alertHorizons {
+		field := h.field(a)
+		if *field != nil {
+			continue
+		}
+		at := triggerClose + h.Duration.Milliseconds()
+		if at > now.UnixMilli() {
+			complete = false
+			continue
+		}
+		if price, ok := forwardClose(db, a.Symbol, at, now.UnixMilli()); ok {
+			ret := price/a.Price - 1
+			*field = &ret
+			changed = true
+		} else {
+			complete = false
+		}
+	}
+	last := alertHorizons[len(alertHorizons)-1].Duration
+	if !complete && now.UnixMilli() > triggerClose+(last+alertGiveUp).Milliseconds() {
+		complete = true
+	}
+	if complete {
+		a.Complete = true
+		changed = true
+	}
+	return changed
+}
+
+// enrichAlerts 为所有未完成的提醒计算远期收益
+func enrichAlerts(db *gorm.DB) error {
+	var alerts []AlertRecord
+	if err := db.Where("complete = ?", false).Order("bar_time ASC").Find(&alerts).Error; err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range alerts {
+		if enrichAlert(db, &alerts[i], now) {
+			if err := db.Save(&alerts[i]).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// startAlertEnricher 每15分钟补充一次提醒的远期收益
+func startAlertEnricher(db *gorm.DB) {
+	go func() {
+		ticker := time.NewTicker(15 * time.Minute)
+		defer ticker.Stop()
+		for ; ; <-ticker.C {
+			if err := enrichAlerts(db); err != nil {
+				log.Printf("计算提醒远期收益失败: %v", err)
+			}
+		}
+	}()
+}
+
+// alertQuery 按请求参数过滤提醒记录：symbol、rule、interval、from、to
+func alertQuery(db *gorm.DB, r *http.Request) (*gorm.DB, error) {
+	q := r.URL.Query()
+	query := db.Model(&AlertRecord{})
+	if v := q.Get("symbol"); v != "" {
+		query = query.Where("symbol = ?", v)
+	}
+	if v := q.Get("rule"); v != "" {
+		query = query.Where("rule = ?", v)
+	}
+	if v := q.Get("interval"); v != "" {
+		query = query.Where("interval = ?", v)
+	}
+	from, err := parseTimeArg(q.Get("from"))
+	if err != nil {
+		return nil, err
+	}
+	if from > 0 {
+		query = query.Where("bar_time >= ?", from)
+	}
+	to, err := parseTimeArg(q.Get("to"))
+	if err != nil {
+		return nil, err
+	}
+	if to > 0 {
+		query = query.Where("bar_time < ?", to)
+	}
+	return query, nil
+}
+
+// handleAlerts 返回提醒记录，按触发时间倒序
+// 例如 /alerts?symbol=BTCUSDT&rule=bullish_cross&from=2025-01-01&limit=100
+func handleAlerts(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		query, err := alertQuery(db, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if v := r.URL.Query().Get("complete"); v != "" {
+			query = query.Where("complete = ?", v == "1" || v == "true")
+		}
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 100
+		}
+		alerts := []AlertRecord{}
+		if err := query.Order("bar_time DESC").Limit(limit).Find(&alerts).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, alerts)
+	}
+}
+
+// alertHorizonStats 一个观察时长上的收益统计
+type alertHorizonStats struct {
+	Samples int     `json:"samples"`
+	WinRate float64 `json:"win_rate"`
+	Average float64 `json:"average"`
+	Median  float64 `json:"median"`
+}
+
+// alertRuleStats 一条规则的提醒统计
+type alertRuleStats struct {
+	Rule    string                       `json:"rule"`
+	Count   int                          `json:"count"`
+	Returns map[string]alertHorizonStats `json:"returns"`
+}
+
+// summarizeAlerts 按规则统计各观察时长的胜率、平均和中位收益
+func summarizeAlerts(alerts []AlertRecord) []alertRuleStats {
+	samples := map[string]map[string][]float64{}
+	counts := map[string]int{}
+	for i := range alerts {
+		a := &alerts[i]
+		if samples[a.Rule] == nil {
+			samples[a.Rule] = map[string][]float64{}
+		}
+		counts[a.Rule]++
+		for _, h := range alertHorizons {
+			if v := *h.field(a); v != nil {
+				samples[a.Rule][h.Name] = append(samples[a.Rule][h.Name], *v)
+			}
+		}
+	}
+
+	result := make([]alertRuleStats, 0, len(counts))
+	for rule, count := range counts {
+		stats := alertRuleStats{Rule: rule, Count: count, Returns: map[string]alertHorizonStats{}}
+		for _, h := range alertHorizons {
+			values := samples[rule][h.Name]
+			if len(values) == 0 {
+				continue
+			}
+			sort.Float64s(values)
+			s := alertHorizonStats{Samples: len(values), Median: values[len(values)/2]}
+			if len(values)%2 == 0 {
+				s.Median = (values[len(values)/2-1] + values[len(values)/2]) / 2
+			}
+			for _, v := range values {
+				if v > 0 {
+					s.WinRate++
+				}
+				s.Average += v
+			}
+			s.WinRate /= float64(len(values))
+			s.Average /= float64(len(values))
+			stats.Returns[h.Name] = s
+		}
+		result = append(result, stats)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Rule < result[j].Rule })
+	return result
+}
+
+// handleAlertStats 按规则汇总提醒的远期收益，过滤参数与 /alerts 相同
+func handleAlertStats(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		query, err := alertQuery(db, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var alerts []AlertRecord
+		if err := query.Find(&alerts).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, summarizeAlerts(alerts))
+	}
+}
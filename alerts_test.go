@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestAlertForwardReturns(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateAlertTables(db); err != nil {
+		t.Fatal(err)
+	}
+	klines := fixtureKlines(400, 2)
+	table := Kline{Symbol: "TESTUSDT"}.TableName()
+	if err := db.Table(table).AutoMigrate(&Kline{}); err != nil {
+		t.Fatal(err)
+	}
+	// 只写入触发后 8 小时的数据，24h 收益暂时无法计算
+	trigger := 200
+	db.Table(table).Create(klines[:trigger+33])
+
+	rule := defaultSignalRules()[0]
+	if err := rule.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordAlert(db, rule, newAlertSymbol(db, rule, "TESTUSDT", klines[:trigger+1], nil)); err != nil {
+		t.Fatal(err)
+	}
+	var alert AlertRecord
+	db.First(&alert)
+	if alert.Price != klines[trigger].Close || alert.BarTime != klines[trigger].OpenTime {
+		t.Fatalf("unexpected alert: %+v", alert)
+	}
+	if _, ok := alert.Indicators["ema(144).ema[-1]"]; !ok {
+		t.Fatalf("missing indicator snapshot: %v", alert.Indicators)
+	}
+
+	now := time.UnixMilli(klines[trigger+120].CloseTime + 1)
+	if !enrichAlert(db, &alert, now) {
+		t.Fatal("expected alert to change")
+	}
+	want1h := klines[trigger+4].Close/alert.Price - 1
+	want4h := klines[trigger+16].Close/alert.Price - 1
+	if alert.Return1h == nil || math.Abs(*alert.Return1h-want1h) > 1e-12 {
+		t.Fatalf("return_1h = %v, want %v", alert.Return1h, want1h)
+	}
+	if alert.Return4h == nil || math.Abs(*alert.Return4h-want4h) > 1e-12 {
+		t.Fatalf("return_4h = %v, want %v", alert.Return4h, want4h)
+	}
+	if alert.Return24h != nil || alert.Complete {
+		t.Fatalf("24h return should wait for data: %+v", alert)
+	}
+
+	db.Table(table).Create(klines[trigger+33:])
+	enrichAlert(db, &alert, now)
+	want24h := klines[trigger+96].Close/alert.Price - 1
+	if alert.Return24h == nil || math.Abs(*alert.Return24h-want24h) > 1e-12 || !alert.Complete {
+		t.Fatalf("return_24h = %v, want %v", alert.Return24h, want24h)
+	}
+
+	stats := summarizeAlerts([]AlertRecord{alert})
+	if len(stats) != 1 || stats[0].Returns["24h"].Samples != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
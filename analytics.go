@@ -0,0 +1,421 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+)
+
+// ================= 跨代币分析 =================
+
+// defaultBenchmark rs/beta/corr 和相对强弱排名的默认对比代币
+const defaultBenchmark = "BTCUSDT"
+
+// pairIndicatorSpec 需要对比代币K线的指标，对比K线按开盘时间与当前K线对齐
+type pairIndicatorSpec struct {
+	Defaults []float64                                      // 默认参数，第一个为窗口长度
+	Compute  func(closes, bench []float64, n int) []float64 // 返回与当前K线对齐的序列
+}
+
+// pairIndicators 跨代币指标，在规则和选币表达式中与普通指标一样使用
+var pairIndicators = map[string]pairIndicatorSpec{
+	// 最近 N 根K线相对对比代币的超额涨跌幅（%），大于 0 表示跑赢
+	"rs": {Defaults: []float64{96}, Compute: rollingRelativeStrength},
+	// 最近 N 根K线对数收益率相对对比代币的 beta
+	"beta": {Defaults: []float64{96}, Compute: func(closes, bench []float64, n int) []float64 {
+		return rollingReturnStat(closes, bench, n, func(s returnStats) float64 { return s.beta() })
+	}},
+	// 最近 N 根K线对数收益率与对比代币的相关系数
+	"corr": {Defaults: []float64{96}, Compute: func(closes, bench []float64, n int) []float64 {
+		return rollingReturnStat(closes, bench, n, func(s returnStats) float64 { return s.correlation() })
+	}},
+}
+
+func isPairIndicator(name string) bool {
+	_, ok := pairIndicators[strings.ToLower(name)]
+	return ok
+}
+
+// computePairIndicator 计算跨代币指标，bench 中没有对应开盘时间的K线处为 NaN
+func computePairIndicator(name string, klines, bench []Kline, params []float64) ([]float64, error) {
+	spec, ok := pairIndicators[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown indicator: %s", name)
+	}
+	if len(params) > len(spec.Defaults) {
+		return nil, fmt.Errorf("%s accepts at most %d params", name, len(spec.Defaults))
+	}
+	used := append([]float64{}, spec.Defaults...)
+	copy(used, params)
+	if n := used[0]; n != math.Trunc(n) || n < 2 {
+		return nil, fmt.Errorf("%s window must be an integer of at least 2", name)
+	}
+	return spec.Compute(klineCloses(klines), alignCloses(klines, bench), int(used[0])), nil
+}
+
+// alignCloses 返回与 klines 开盘时间相同的 other 收盘价，没有时为 NaN
+func alignCloses(klines, other []Kline) []float64 {
+	byTime := make(map[int64]float64, len(other))
+	for _, k := range other {
+		byTime[k.OpenTime] = k.Close
+	}
+	out := make([]float64, len(klines))
+	for i, k := range klines {
+		if c, ok := byTime[k.OpenTime]; ok {
+			out[i] = c
+		} else {
+			out[i] = math.NaN()
+		}
+	}
+	return out
+}
+
+// logReturns 对数收益率，第一根或价格无效处为 NaN
+func logReturns(closes []float64) []float64 {
+	out := nanSeries(len(closes))
+	for i := 1; i < len(closes); i++ {
+		if closes[i] > 0 && closes[i-1] > 0 {
+			out[i] = math.Log(closes[i] / closes[i-1])
+		}
+	}
+	return out
+}
+
+// returnStats 两组收益率的协方差统计，只计入两者都有效的样本
+type returnStats struct {
+	n                               int
+	sumX, sumY, sumXY, sumXX, sumYY float64
+}
+
+func newReturnStats(x, y []float64) returnStats {
+	var s returnStats
+	for i := range x {
+		if math.IsNaN(x[i]) || math.IsNaN(y[i]) {
+			continue
+		}
+		s.n++
+		s.sumX += x[i]
+		s.sumY += y[i]
+		s.sumXY += x[i] * y[i]
+		s.sumXX += x[i] * x[i]
+		s.sumYY += y[i] * y[i]
+	}
+	return s
+}
+
+func (s returnStats) cov() (xy, xx, yy float64) {
+	n := float64(s.n)
+	return s.sumXY/n - s.sumX*s.sumY/n/n, s.sumXX/n - s.sumX*s.sumX/n/n, s.sumYY/n - s.sumY*s.sumY/n/n
+}
+
+// beta x 相对 y 的 beta，样本少于 3 个或 y 没有波动时为 NaN
+func (s returnStats) beta() float64 {
+	if s.n < 3 {
+		return math.NaN()
+	}
+	xy, _, yy := s.cov()
+	if yy <= 0 {
+		return math.NaN()
+	}
+	return xy / yy
+}
+
+// correlation 相关系数，样本少于 3 个或任一方没有波动时为 NaN
+func (s returnStats) correlation() float64 {
+	if s.n < 3 {
+		return math.NaN()
+	}
+	xy, xx, yy := s.cov()
+	if xx <= 0 || yy <= 0 {
+		return math.NaN()
+	}
+	return math.Max(-1, math.Min(1, xy/math.Sqrt(xx*yy)))
+}
+
+// rollingReturnStat 在每根K线上用最近 n 个收益率计算统计量，有效样本不足一半时为 NaN
+func rollingReturnStat(closes, bench []float64, n int, stat func(returnStats) float64) []float64 {
+	x, y := logReturns(closes), logReturns(bench)
+	out := nanSeries(len(closes))
+	for i := n; i < len(closes); i++ {
+		s := newReturnStats(x[i-n+1:i+1], y[i-n+1:i+1])
+		if s.n*2 >= n {
+			out[i] = stat(s)
+		}
+	}
+	return out
+}
+
+// rollingRelativeStrength 最近 n 根K线的涨跌幅减去对比代币同期的涨跌幅（%）
+func rollingRelativeStrength(closes, bench []float64, n int) []float64 {
+	out := nanSeries(len(closes))
+	for i := n; i < len(closes); i++ {
+		a, b := closes[i]/closes[i-n], bench[i]/bench[i-n]
+		if !math.IsNaN(a) && !math.IsNaN(b) && !math.IsInf(a, 0) && !math.IsInf(b, 0) {
+			out[i] = (a - b) * 100
+		}
+	}
+	return out
+}
+
+// ================= 分析接口 =================
+
+// analyticsQuery 相关性和相对强弱接口的公共参数
+type analyticsQuery struct {
+	Interval  string
+	Lookback  int // 收益率样本数，加载 Lookback+1 根已收盘K线
+	Symbols   []string
+	Benchmark string
+}
+
+func parseAnalyticsQuery(r *http.Request) (analyticsQuery, error) {
+	v := r.URL.Query()
+	q := analyticsQuery{Interval: "1h", Lookback: 168, Benchmark: defaultBenchmark}
+	if s := v.Get("interval"); s != "" {
+		q.Interval = s
+	}
+	if intervalMillis(q.Interval) == 0 {
+		return q, fmt.Errorf("unsupported interval %s", q.Interval)
+	}
+	if s := v.Get("lookback"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 3 || n > 1000 {
+			return q, errors.New("lookback must be between 3 and 1000")
+		}
+		q.Lookback = n
+	}
+	if s := v.Get("benchmark"); s != "" {
+		q.Benchmark = strings.ToUpper(s)
+	}
+	if !isTrackedSymbol(q.Benchmark) {
+		return q, fmt.Errorf("benchmark %s is not tracked", q.Benchmark)
+	}
+	q.Symbols = trackedSymbols()
+	if s := v.Get("symbols"); s != "" {
+		q.Symbols = nil
+		for _, sym := range strings.Split(strings.ToUpper(s), ",") {
+			if sym = strings.TrimSpace(sym); sym == "" || slices.Contains(q.Symbols, sym) {
+				continue
+			}
+			if !isTrackedSymbol(sym) {
+				return q, fmt.Errorf("symbol %s is not tracked", sym)
+			}
+			q.Symbols = append(q.Symbols, sym)
+		}
+	}
+	slices.Sort(q.Symbols)
+	return q, nil
+}
+
+// cacheKey 结果只在有新K线收盘或数据更新时变化，以周期起点和各代币数据版本区分
+func (q analyticsQuery) cacheKey(kind string, now time.Time) string {
+	var version uint64
+	for _, sym := range append([]string{q.Benchmark}, q.Symbols...) {
+		version += dataVersion(sym)
+	}
+	bucket := now.UnixMilli() / intervalMillis(q.Interval)
+	return fmt.Sprintf("analytics:%s:%s:%d:%s:%s:%d:%d", kind, q.Interval, q.Lookback, q.Benchmark, strings.Join(q.Symbols, ","), bucket, version)
+}
+
+// symbolReturns 一个代币在回看窗口内的收益率，以K线开盘时间为键，只计入相邻两根K线之间的收益率
+type symbolReturns struct {
+	returns map[int64]float64
+	first   float64 // 窗口内第一根和最后一根K线的收盘价
+	last    float64
+	end     int64 // 最后一根K线的开盘时间
+}
+
+// loadReturns 并发加载各代币的已收盘K线并计算收益率
+func loadReturns(db *gorm.DB, symbols []string, interval string, lookback int) map[string]symbolReturns {
+	ms := intervalMillis(interval)
+	result := make(map[string]symbolReturns, len(symbols))
+	var mu sync.Mutex
+	var g errgroup.Group
+	g.SetLimit(screenerParallel)
+	for _, sym := range symbols {
+		g.Go(func() error {
+			// 多取一根以抵消未收盘的K线，lookback 个收益率需要 lookback+1 根K线
+			klines := getAggKlineAsc(db, sym, interval, lookback+2, true)
+			if len(klines) > lookback+1 {
+				klines = klines[len(klines)-lookback-1:]
+			}
+			if len(klines) < 2 {
+				return nil
+			}
+			r := symbolReturns{returns: make(map[int64]float64, len(klines)), first: klines[0].Close, last: klines[len(klines)-1].Close, end: klines[len(klines)-1].OpenTime}
+			for i := 1; i < len(klines); i++ {
+				prev, k := klines[i-1], klines[i]
+				if k.OpenTime-prev.OpenTime == ms && prev.Close > 0 && k.Close > 0 {
+					r.returns[k.OpenTime] = math.Log(k.Close / prev.Close)
+				}
+			}
+			mu.Lock()
+			result[sym] = r
+			mu.Unlock()
+			return nil
+		})
+	}
+	g.Wait()
+	return result
+}
+
+// pairStats 两个代币在共同开盘时间上的收益率统计
+func pairStats(a, b symbolReturns) returnStats {
+	var x, y []float64
+	// 按时间顺序累加，保证相同数据的结果一致
+	for _, t := range slices.Sorted(maps.Keys(a.returns)) {
+		if rb, ok := b.returns[t]; ok {
+			x, y = append(x, a.returns[t]), append(y, rb)
+		}
+	}
+	return newReturnStats(x, y)
+}
+
+// jsonFloat 序列化时将 NaN 输出为 null
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.FormatFloat(float64(f), 'f', -1, 64)), nil
+}
+
+// correlationResponse /analytics/correlation 的响应
+type correlationResponse struct {
+	Interval string       `json:"interval"`
+	Lookback int          `json:"lookback"`
+	Symbols  []string     `json:"symbols"`
+	Matrix   []jsonSeries `json:"matrix"`  // 对数收益率相关系数，样本不足时为 null
+	Samples  [][]int      `json:"samples"` // 每对代币共同的收益率样本数
+}
+
+// correlationMatrix 按共同开盘时间对齐计算两两相关系数
+func correlationMatrix(db *gorm.DB, q analyticsQuery) correlationResponse {
+	returns := loadReturns(db, q.Symbols, q.Interval, q.Lookback)
+	n := len(q.Symbols)
+	resp := correlationResponse{Interval: q.Interval, Lookback: q.Lookback, Symbols: q.Symbols, Matrix: make([]jsonSeries, n), Samples: make([][]int, n)}
+	for i := range q.Symbols {
+		resp.Matrix[i] = nanSeries(n)
+		resp.Samples[i] = make([]int, n)
+	}
+	for i, a := range q.Symbols {
+		for j := i; j < n; j++ {
+			s := pairStats(returns[a], returns[q.Symbols[j]])
+			resp.Matrix[i][j], resp.Matrix[j][i] = s.correlation(), s.correlation()
+			resp.Samples[i][j], resp.Samples[j][i] = s.n, s.n
+		}
+	}
+	return resp
+}
+
+// strengthRow 一个代币相对对比代币的表现
+type strengthRow struct {
+	Rank             int       `json:"rank"`
+	Symbol           string    `json:"symbol"`
+	Return           jsonFloat `json:"return"`            // 回看窗口内涨跌幅（%）
+	RelativeStrength jsonFloat `json:"relative_strength"` // 减去对比代币同期涨跌幅（%）
+	Beta             jsonFloat `json:"beta"`
+	Correlation      jsonFloat `json:"correlation"`
+	Samples          int       `json:"samples"` // 与对比代币共同的收益率样本数
+}
+
+// strengthResponse /analytics/strength 的响应
+type strengthResponse struct {
+	Interval        string        `json:"interval"`
+	Lookback        int           `json:"lookback"`
+	Benchmark       string        `json:"benchmark"`
+	BenchmarkReturn jsonFloat     `json:"benchmark_return"`
+	Rows            []strengthRow `json:"rows"`
+}
+
+// strengthRanking 按相对对比代币的超额涨跌幅排序，无法计算的代币排在最后
+func strengthRanking(db *gorm.DB, q analyticsQuery) strengthResponse {
+	symbols := q.Symbols
+	if !slices.Contains(symbols, q.Benchmark) {
+		symbols = append(slices.Clone(symbols), q.Benchmark)
+	}
+	returns := loadReturns(db, symbols, q.Interval, q.Lookback)
+	bench, ok := returns[q.Benchmark]
+	benchReturn := math.NaN()
+	if ok {
+		benchReturn = (bench.last/bench.first - 1) * 100
+	}
+	resp := strengthResponse{Interval: q.Interval, Lookback: q.Lookback, Benchmark: q.Benchmark, BenchmarkReturn: jsonFloat(benchReturn), Rows: []strengthRow{}}
+	for _, sym := range q.Symbols {
+		r, ok := returns[sym]
+		row := strengthRow{Symbol: sym, Return: jsonFloat(math.NaN()), RelativeStrength: jsonFloat(math.NaN())}
+		if ok {
+			ret := (r.last/r.first - 1) * 100
+			row.Return = jsonFloat(ret)
+			// 窗口结束时间不同（某个代币缺少最新K线）时不可比较
+			if bench.end == r.end {
+				row.RelativeStrength = jsonFloat(ret - benchReturn)
+			}
+			s := pairStats(r, bench)
+			row.Beta, row.Correlation, row.Samples = jsonFloat(s.beta()), jsonFloat(s.correlation()), s.n
+		} else {
+			row.Beta, row.Correlation = jsonFloat(math.NaN()), jsonFloat(math.NaN())
+		}
+		resp.Rows = append(resp.Rows, row)
+	}
+	sort.SliceStable(resp.Rows, func(i, j int) bool {
+		a, b := float64(resp.Rows[i].RelativeStrength), float64(resp.Rows[j].RelativeStrength)
+		if math.IsNaN(a) || math.IsNaN(b) {
+			return !math.IsNaN(a) && math.IsNaN(b)
+		}
+		return a > b
+	})
+	for i := range resp.Rows {
+		resp.Rows[i].Rank = i + 1
+	}
+	return resp
+}
+
+// handleAnalytics 返回缓存的分析结果，compute 只在缓存未命中时调用
+func handleAnalytics(kind string, compute func(q analyticsQuery) any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		q, err := parseAnalyticsQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		key := q.cacheKey(kind, time.Now())
+		if resp, ok, _ := responseCache.Get(key); ok {
+			writeCached(w, r, resp, true)
+			return
+		}
+		resp, err := newCachedResponse(compute(q))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		responseCache.Set(key, resp, time.Duration(intervalMillis(q.Interval))*time.Millisecond)
+		writeCached(w, r, resp, false)
+	}
+}
+
+// handleCorrelation 返回回看窗口内对数收益率的相关系数矩阵
+// 例如 /analytics/correlation?interval=1h&lookback=168&symbols=BTCUSDT,ETHUSDT,SOLUSDT
+func handleCorrelation(db *gorm.DB) http.HandlerFunc {
+	return handleAnalytics("correlation", func(q analyticsQuery) any { return correlationMatrix(db, q) })
+}
+
+// handleStrength 返回相对对比代币的强弱排名，以及 beta 和相关系数
+// 例如 /analytics/strength?interval=4h&lookback=42&benchmark=BTCUSDT
+func handleStrength(db *gorm.DB) http.HandlerFunc {
+	return handleAnalytics("strength", func(q analyticsQuery) any { return strengthRanking(db, q) })
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// leveragedKlines 返回收益率为 base 的 factor 倍的K线，用于验证 beta 和相关系数
+func leveragedKlines(base []Kline, symbol string, factor float64) []Kline {
+	out := make([]Kline, len(base))
+	for i, k := range base {
+		c := 50 * math.Pow(k.Close/base[0].Close, factor)
+		out[i] = Kline{Symbol: symbol, OpenTime: k.OpenTime, CloseTime: k.CloseTime, Open: c, High: c, Low: c, Close: c, Volume: k.Volume, IsClosed: k.IsClosed}
+	}
+	return out
+}
+
+func TestPairIndicators(t *testing.T) {
+	btc := fixtureKlines(200, 7)
+	alt := leveragedKlines(btc, "ALTUSDT", 2)
+
+	beta, err := computePairIndicator("beta", alt, btc, []float64{50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	corr, _ := computePairIndicator("corr", alt, btc, []float64{50})
+	rs, _ := computePairIndicator("rs", alt, btc, []float64{50})
+	last := len(btc) - 1
+	if math.Abs(beta[last]-2) > 1e-9 || math.Abs(corr[last]-1) > 1e-9 || !math.IsNaN(beta[49]) || math.IsNaN(beta[50]) {
+		t.Fatalf("beta %v corr %v", beta[last], corr[last])
+	}
+	wantRS := (alt[last].Close/alt[last-50].Close - btc[last].Close/btc[last-50].Close) * 100
+	if math.Abs(rs[last]-wantRS) > 1e-9 {
+		t.Fatalf("rs = %v, want %v", rs[last], wantRS)
+	}
+
+	// 对比代币缺少的K线不参与计算
+	missing, _ := computePairIndicator("rs", alt, btc[:last], []float64{50})
+	if !math.IsNaN(missing[last]) || math.IsNaN(missing[last-1]) {
+		t.Fatal("bars without benchmark data should be NaN")
+	}
+	if _, err := computePairIndicator("beta", alt, btc, []float64{1}); err == nil {
+		t.Fatal("expected window error")
+	}
+
+	// 规则中使用：只在跑赢 BTC 时成立，没有对比数据时不成立
+	rule := SignalRule{Name: "outperform", Interval: "15m", Limit: 300, When: Condition{Compare: &CompareCond{
+		Left: Operand{Indicator: "rs", Params: []float64{50}}, Op: ">", Right: constOperand(0),
+	}}}
+	if err := rule.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	load := func(symbol, interval string, limit int) []Kline {
+		if symbol == defaultBenchmark {
+			return btc
+		}
+		return nil
+	}
+	if got := rule.Match(alt, load); got != (wantRS > 0) {
+		t.Fatalf("rule match = %v, rs = %v", got, wantRS)
+	}
+	if rule.Match(alt, nil) {
+		t.Fatal("rule should not match without benchmark data")
+	}
+	if (Operand{Indicator: "ema", Benchmark: "ETHUSDT"}).validate() == nil {
+		t.Fatal("benchmark should only be accepted by pair indicators")
+	}
+
+	c, err := parseCondition("rs(20)@1h@ETHUSDT > 0 and beta < 1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatOperand(c.All[0].Compare.Left); got != "rs(20)@1h@ETHUSDT" {
+		t.Fatalf("unexpected operand %s", got)
+	}
+	if got := formatOperand(c.All[1].Compare.Left); got != "beta(96)" {
+		t.Fatalf("unexpected operand %s", got)
+	}
+	if _, err := parseCondition("rsi@BTCUSDT > 0"); err == nil {
+		t.Fatal("expected error for benchmark on a plain indicator")
+	}
+}
+
+func TestAnalyticsEndpoints(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内存数据库的每个连接是独立的库，并发加载时共用一个连接
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	oldSymbols := symbols
+	t.Cleanup(func() { symbols = oldSymbols })
+	symbols = []string{"BTCUSDT", "AUSDT", "BUSDT"}
+
+	btc := fixtureKlines(300, 7)
+	shift := time.Now().Truncate(15*time.Minute).UnixMilli() - btc[len(btc)-1].OpenTime
+	for i := range btc {
+		btc[i].Symbol = "BTCUSDT"
+		btc[i].OpenTime += shift
+		btc[i].CloseTime += shift
+		btc[i].IsClosed = i < len(btc)-1 // 当前周期未收盘
+	}
+	other := fixtureKlines(300, 8)
+	for i := range other {
+		other[i].Symbol = "BUSDT"
+		other[i].OpenTime, other[i].CloseTime, other[i].IsClosed = btc[i].OpenTime, btc[i].CloseTime, btc[i].IsClosed
+	}
+	data := map[string][]Kline{"BTCUSDT": btc, "AUSDT": leveragedKlines(btc, "AUSDT", 2), "BUSDT": other}
+	for symbol, klines := range data {
+		if err := ensureKlineTable(db, symbol); err != nil {
+			t.Fatal(err)
+		}
+		db.Table(Kline{Symbol: symbol}.TableName()).CreateInBatches(klines, 100)
+	}
+
+	get := func(handler http.HandlerFunc, query string, v any) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, query, nil))
+		json.Unmarshal(rec.Body.Bytes(), v)
+		return rec
+	}
+
+	var corr struct {
+		Symbols []string    `json:"symbols"`
+		Matrix  [][]float64 `json:"matrix"`
+		Samples [][]int     `json:"samples"`
+	}
+	rec := get(handleCorrelation(db), "/analytics/correlation?interval=15m&lookback=100", &corr)
+	if rec.Code != http.StatusOK || len(corr.Symbols) != 3 || corr.Symbols[1] != "BTCUSDT" || corr.Samples[0][1] != 100 {
+		t.Fatalf("unexpected correlation response: %d %s", rec.Code, rec.Body)
+	}
+	if math.Abs(corr.Matrix[0][0]-1) > 1e-9 || math.Abs(corr.Matrix[0][1]-1) > 1e-9 || corr.Matrix[0][2] != corr.Matrix[2][0] {
+		t.Fatalf("unexpected matrix: %v", corr.Matrix)
+	}
+	if rec := get(handleCorrelation(db), "/analytics/correlation?interval=15m&lookback=100", &corr); rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatal("repeated request should be served from cache")
+	}
+
+	var strength struct {
+		BenchmarkReturn float64 `json:"benchmark_return"`
+		Rows            []struct {
+			Rank             int      `json:"rank"`
+			Symbol           string   `json:"symbol"`
+			Return           float64  `json:"return"`
+			RelativeStrength float64  `json:"relative_strength"`
+			Beta             *float64 `json:"beta"`
+		} `json:"rows"`
+	}
+	rec = get(handleStrength(db), "/analytics/strength?interval=15m&lookback=100&symbols=busdt,AUSDT", &strength)
+	if rec.Code != http.StatusOK || len(strength.Rows) != 2 {
+		t.Fatalf("unexpected strength response: %d %s", rec.Code, rec.Body)
+	}
+	for i, row := range strength.Rows {
+		if row.Rank != i+1 || math.Abs(row.RelativeStrength-(row.Return-strength.BenchmarkReturn)) > 1e-9 {
+			t.Fatalf("unexpected row: %+v", row)
+		}
+		if row.Symbol == "AUSDT" && (row.Beta == nil || math.Abs(*row.Beta-2) > 1e-9) {
+			t.Fatalf("AUSDT beta should be 2: %+v", row)
+		}
+	}
+	if strength.Rows[0].RelativeStrength < strength.Rows[1].RelativeStrength {
+		t.Fatal("rows should be ranked by relative strength")
+	}
+
+	for _, query := range []string{"?symbols=NOPEUSDT", "?benchmark=NOPEUSDT", "?interval=5m", "?lookback=1"} {
+		if rec := get(handleStrength(db), "/analytics/strength"+query, &strength); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
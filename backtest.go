@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+)
+
+// ================= 回测 =================
+
+// backtestMaxBars 回测时每个周期最多读取的K线数量，覆盖数据库保留的全部历史
+const backtestMaxBars = 100000
+
+// BacktestConfig 回测参数，比例均为小数，如 0.03 表示 3%
+type BacktestConfig struct {
+	TakeProfit float64 `json:"take_profit"` // 止盈比例，0 表示不止盈
+	StopLoss   float64 `json:"stop_loss"`   // 止损比例，0 表示不止损
+	MaxBars    int     `json:"max_bars"`    // 最长持仓K线数，0 表示不限
+	Fee        float64 `json:"fee"`         // 单边手续费率
+	Slippage   float64 `json:"slippage"`    // 单边滑点比例
+	From       int64   `json:"from"`        // 只在该时间(ms)之后开仓，0 表示不限
+	To         int64   `json:"to"`          // 只在该时间(ms)之前开仓，0 表示不限
+}
+
+var defaultBacktestConfig = BacktestConfig{TakeProfit: 0.03, StopLoss: 0.02, MaxBars: 96, Fee: 0.0004, Slippage: 0.0002}
+
+// BacktestTrade 一笔模拟交易，只做多
+type BacktestTrade struct {
+	Symbol     string  `json:"symbol"`
+	EntryTime  int64   `json:"entry_time"`
+	EntryPrice float64 `json:"entry_price"`
+	ExitTime   int64   `json:"exit_time"`
+	ExitPrice  float64 `json:"exit_price"`
+	Return     float64 `json:"return"` // 扣除手续费后的收益率
+	Bars       int     `json:"bars"`
+	Reason     string  `json:"reason"` // tp/sl/time/end
+}
+
+// BacktestStats 交易统计，权益曲线按每笔固定名义金额累加收益计算
+type BacktestStats struct {
+	Trades      int     `json:"trades"`
+	Wins        int     `json:"wins"`
+	WinRate     float64 `json:"win_rate"`
+	Expectancy  float64 `json:"expectancy"`   // 平均每笔收益率
+	TotalReturn float64 `json:"total_return"` // 收益率之和
+	MaxDrawdown float64 `json:"max_drawdown"`
+	Sharpe      float64 `json:"sharpe"` // 每笔收益的均值/标准差，未年化
+	AvgBars     float64 `json:"avg_bars"`
+}
+
+type BacktestSymbolReport struct {
+	Symbol string        `json:"symbol"`
+	Bars   int           `json:"bars"`
+	Stats  BacktestStats `json:"stats"`
+}
+
+type BacktestReport struct {
+	Rule      string                 `json:"rule"`
+	Interval  string                 `json:"interval"`
+	Config    BacktestConfig         `json:"config"`
+	Symbols   []BacktestSymbolReport `json:"symbols"`
+	Aggregate BacktestStats          `json:"aggregate"`
+	Trades    []BacktestTrade        `json:"trades,omitempty"`
+}
+
+// simulateRule 逐根K线回放规则，第 i 根K线只使用 i 及之前的数据求值，
+// 信号出现时按该K线收盘价开仓，之后的K线按 exitOnBar 检查平仓。
+// 规则设置了 Chart 时在派生K线上求值，开平仓仍按派生K线完成时所在的源K线成交，
+// 按 ATR 确定幅度时每个时段使用当时的幅度
+func simulateRule(rule SignalRule, klines []Kline, load klineLoader, cfg BacktestConfig) []BacktestTrade {
+	var trades []BacktestTrade
+	bars := rule.Chart.applyHistory(klines)
+	source := sourceIndex(bars, klines)
+	frame := newRuleFrame(rule.Interval, bars, backtestMaxBars, load)
+	for b := 0; b < len(bars); b++ {
+		i := source[b]
+		if b+1 < rule.MinBars || i+1 >= len(klines) {
+			continue
+		}
+		signalBar := klines[i]
+		if (cfg.From > 0 && signalBar.OpenTime < cfg.From) || (cfg.To > 0 && signalBar.OpenTime >= cfg.To) {
+			continue
+		}
+		if !rule.When.eval(frame, b) {
+			continue
+		}
+
+		entry := signalBar.Close * (1 + cfg.Slippage)
+		stop, target := cfg.stopPrice(entry), cfg.targetPrice(entry)
+		trade := BacktestTrade{Symbol: signalBar.Symbol, EntryTime: signalBar.CloseTime, EntryPrice: entry}
+		exitIdx, exit, reason := len(klines)-1, klines[len(klines)-1].Close, "end"
+		for j := i + 1; j < len(klines); j++ {
+			if price, why, ok := exitOnBar(klines[j], stop, target, cfg.MaxBars > 0 && j-i >= cfg.MaxBars); ok {
+				exitIdx, exit, reason = j, price, why
+				break
+			}
+		}
+		exit *= 1 - cfg.Slippage
+		trade.Reason = reason
+		trade.ExitTime = klines[exitIdx].CloseTime
+		trade.ExitPrice = exit
+		trade.Bars = exitIdx - i
+		trade.Return = exit/entry - 1 - 2*cfg.Fee
+		trades = append(trades, trade)
+		// 持仓期间不再开新仓，从平仓K线之后继续寻找信号
+		for b+1 < len(bars) && source[b+1] <= exitIdx {
+			b++
+		}
+	}
+	return trades
+}
+
+// sourceIndex 返回每根派生K线所在源K线的下标，两者均按时间升序
+func sourceIndex(bars, klines []Kline) []int {
+	index := make([]int, len(bars))
+	j := 0
+	for i, k := range bars {
+		for j+1 < len(klines) && klines[j].OpenTime < k.OpenTime {
+			j++
+		}
+		index[i] = j
+	}
+	return index
+}
+
+// stopPrice 返回止损价，未设置止损时返回 0
+func (cfg BacktestConfig) stopPrice(entry float64) float64 {
+	if cfg.StopLoss <= 0 {
+		return 0
+	}
+	return entry * (1 - cfg.StopLoss)
+}
+
+// targetPrice 返回止盈价，未设置止盈时返回 0
+func (cfg BacktestConfig) targetPrice(entry float64) float64 {
+	if cfg.TakeProfit <= 0 {
+		return 0
+	}
+	return entry * (1 + cfg.TakeProfit)
+}
+
+// exitOnBar 检查多单在一根K线上是否平仓，stop/target 为 0 表示不设置，expired 表示达到最长持仓。
+// 同一根K线同时触及时按止损处理，跳空越过止盈止损价时按开盘价成交
+func exitOnBar(k Kline, stop, target float64, expired bool) (float64, string, bool) {
+	if stop > 0 && k.Low <= stop {
+		return math.Min(k.Open, stop), "sl", true
+	}
+	if target > 0 && k.High >= target {
+		return math.Max(k.Open, target), "tp", true
+	}
+	if expired {
+		return k.Close, "time", true
+	}
+	return 0, "", false
+}
+
+// computeBacktestStats 按平仓时间排序后统计
+func computeBacktestStats(trades []BacktestTrade) BacktestStats {
+	var stats BacktestStats
+	if len(trades) == 0 {
+		return stats
+	}
+	sorted := append([]BacktestTrade{}, trades...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ExitTime < sorted[j].ExitTime })
+
+	var equity, peak, sumSq, bars float64
+	for _, t := range sorted {
+		stats.Trades++
+		if t.Return > 0 {
+			stats.Wins++
+		}
+		stats.TotalReturn += t.Return
+		sumSq += t.Return * t.Return
+		bars += float64(t.Bars)
+
+		equity += t.Return
+		peak = math.Max(peak, equity)
+		stats.MaxDrawdown = math.Max(stats.MaxDrawdown, peak-equity)
+	}
+	n := float64(stats.Trades)
+	stats.WinRate = float64(stats.Wins) / n
+	stats.Expectancy = stats.TotalReturn / n
+	stats.AvgBars = bars / n
+	if stats.Trades > 1 {
+		variance := (sumSq - n*stats.Expectancy*stats.Expectancy) / (n - 1)
+		if variance > 0 {
+			stats.Sharpe = stats.Expectancy / math.Sqrt(variance)
+		}
+	}
+	return stats
+}
+
+// findSignalRule 按名称查找当前生效的规则
+func findSignalRule(name string) (SignalRule, error) {
+	rules := signalRules
+	if len(rules) == 0 {
+		rules = defaultSignalRules()
+	}
+	if name == "" {
+		return rules[0], nil
+	}
+	for _, rule := range rules {
+		if rule.Name == name {
+			return rule, nil
+		}
+	}
+	return SignalRule{}, fmt.Errorf("unknown rule: %s", name)
+}
+
+// runBacktest 对每个代币并行回测，并汇总结果
+func runBacktest(db *gorm.DB, rule SignalRule, symbolList []string, cfg BacktestConfig) (BacktestReport, error) {
+	report := BacktestReport{Rule: rule.Name, Interval: rule.Interval, Config: cfg}
+	var mu sync.Mutex
+	var g errgroup.Group
+	g.SetLimit(4)
+	for _, sym := range symbolList {
+		sym := sym
+		g.Go(func() error {
+			klines := getAggKlineAsc(db, sym, rule.Interval, backtestMaxBars, true)
+			load := dbKlineLoader(db, sym)
+			trades := simulateRule(rule, klines, load, cfg)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Symbols = append(report.Symbols, BacktestSymbolReport{Symbol: sym, Bars: len(klines), Stats: computeBacktestStats(trades)})
+			report.Trades = append(report.Trades, trades...)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return report, err
+	}
+	sort.Slice(report.Symbols, func(i, j int) bool { return report.Symbols[i].Symbol < report.Symbols[j].Symbol })
+	sort.Slice(report.Trades, func(i, j int) bool { return report.Trades[i].EntryTime < report.Trades[j].EntryTime })
+	report.Aggregate = computeBacktestStats(report.Trades)
+	return report, nil
+}
+
+// parseTimeArg 解析毫秒时间戳或 2006-01-02 格式的日期
+func parseTimeArg(v string) (int64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return ms, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", v)
+	}
+	return t.UnixMilli(), nil
+}
+
+// resolveSymbols 解析逗号分隔的代币列表，为空时返回全部监控代币
+func resolveSymbols(v string) ([]string, error) {
+	if v == "" {
+		return trackedSymbols(), nil
+	}
+	list := strings.Split(v, ",")
+	for _, s := range list {
+		if !isTrackedSymbol(s) {
+			return nil, fmt.Errorf("unknown symbol: %s", s)
+		}
+	}
+	return list, nil
+}
+
+// bindBacktestFlags 注册止盈止损、持仓和成本相关的命令行参数
+func bindBacktestFlags(fs *flag.FlagSet, cfg *BacktestConfig) {
+	fs.Float64Var(&cfg.TakeProfit, "tp", cfg.TakeProfit, "止盈比例")
+	fs.Float64Var(&cfg.StopLoss, "sl", cfg.StopLoss, "止损比例")
+	fs.IntVar(&cfg.MaxBars, "max-bars", cfg.MaxBars, "最长持仓K线数")
+	fs.Float64Var(&cfg.Fee, "fee", cfg.Fee, "单边手续费率")
+	fs.Float64Var(&cfg.Slippage, "slippage", cfg.Slippage, "单边滑点比例")
+}
+
+// runBacktestCommand 命令行入口：kline backtest -rule bullish_cross -symbols BTCUSDT -tp 0.03 -sl 0.02
+func runBacktestCommand(db *gorm.DB, args []string) error {
+	fs := flag.NewFlagSet("backtest", flag.ContinueOnError)
+	cfg := defaultBacktestConfig
+	ruleName := fs.String("rule", "", "规则名称，默认第一条规则")
+	symbolArg := fs.String("symbols", "", "逗号分隔的代币，默认全部")
+	from := fs.String("from", "", "开始时间，毫秒或 2006-01-02")
+	to := fs.String("to", "", "结束时间，毫秒或 2006-01-02")
+	trades := fs.Bool("trades", false, "输出每笔交易")
+	bindBacktestFlags(fs, &cfg)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var err error
+	if cfg.From, err = parseTimeArg(*from); err != nil {
+		return err
+	}
+	if cfg.To, err = parseTimeArg(*to); err != nil {
+		return err
+	}
+	rule, err := findSignalRule(*ruleName)
+	if err != nil {
+		return err
+	}
+	symbolList, err := resolveSymbols(*symbolArg)
+	if err != nil {
+		return err
+	}
+	report, err := runBacktest(db, rule, symbolList, cfg)
+	if err != nil {
+		return err
+	}
+	if !*trades {
+		report.Trades = nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// parseBacktestQuery 从查询参数解析回测参数，未提供的使用默认值
+func parseBacktestQuery(r *http.Request) (BacktestConfig, error) {
+	q := r.URL.Query()
+	cfg := defaultBacktestConfig
+	floats := map[string]*float64{"tp": &cfg.TakeProfit, "sl": &cfg.StopLoss, "fee": &cfg.Fee, "slippage": &cfg.Slippage}
+	for name, dst := range floats {
+		if v := q.Get(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 {
+				return cfg, fmt.Errorf("invalid %s", name)
+			}
+			*dst = f
+		}
+	}
+	if v := q.Get("max_bars"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, errors.New("invalid max_bars")
+		}
+		cfg.MaxBars = n
+	}
+	var err error
+	if cfg.From, err = parseTimeArg(q.Get("from")); err != nil {
+		return cfg, err
+	}
+	if cfg.To, err = parseTimeArg(q.Get("to")); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// handleBacktest 通过 HTTP 运行回测
+// 例如 /backtest?rule=bullish_cross&symbols=BTCUSDT,ETHUSDT&tp=0.03&sl=0.02&trades=1
+func handleBacktest(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		cfg, err := parseBacktestQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rule, err := findSignalRule(r.URL.Query().Get("rule"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		symbolList, err := resolveSymbols(r.URL.Query().Get("symbols"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		report, err := runBacktest(db, rule, symbolList, cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("trades") != "1" {
+			report.Trades = nil
+		}
+		writeJSON(w, r, report)
+	}
+}
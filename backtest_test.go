@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// flatBacktestKlines 生成价格恒为 100 的K线，signals 中的K线成交量放大作为开仓信号
+func flatBacktestKlines(n int, signals ...int) []Kline {
+	ms := intervalMillis("15m")
+	klines := make([]Kline, n)
+	for i := range klines {
+		open := int64(i) * ms
+		klines[i] = Kline{Symbol: "TESTUSDT", OpenTime: open, CloseTime: open + ms - 1, Open: 100, High: 100, Low: 100, Close: 100, Volume: 1, IsClosed: true}
+	}
+	for _, i := range signals {
+		klines[i].Volume = 10
+	}
+	return klines
+}
+
+var volumeSpikeRule = SignalRule{Name: "volume_spike", Interval: "15m", When: Condition{Compare: &CompareCond{
+	Left: priceOperand("volume"), Op: ">", Right: constOperand(1),
+}}}
+
+func TestExitOnBar(t *testing.T) {
+	bar := func(o, h, l, c float64) Kline { return Kline{Open: o, High: h, Low: l, Close: c} }
+	tests := []struct {
+		name    string
+		k       Kline
+		expired bool
+		price   float64
+		reason  string
+		ok      bool
+	}{
+		{"inside", bar(100, 102, 98, 101), false, 0, "", false},
+		{"take profit", bar(100, 106, 99, 104), false, 105, "tp", true},
+		{"stop loss", bar(100, 101, 96, 98), false, 97, "sl", true},
+		{"gap through stop", bar(95, 96, 94, 95), false, 95, "sl", true},
+		{"gap over target", bar(108, 109, 107, 108), false, 108, "tp", true},
+		{"both touched", bar(100, 106, 96, 104), false, 97, "sl", true},
+		{"expired", bar(100, 102, 98, 101), true, 101, "time", true},
+		{"expired after stop", bar(100, 101, 96, 98), true, 97, "sl", true},
+	}
+	for _, tt := range tests {
+		price, reason, ok := exitOnBar(tt.k, 97, 105, tt.expired)
+		if price != tt.price || reason != tt.reason || ok != tt.ok {
+			t.Errorf("%s: got %v %q %v, want %v %q %v", tt.name, price, reason, ok, tt.price, tt.reason, tt.ok)
+		}
+	}
+	if _, _, ok := exitOnBar(bar(100, 200, 1, 100), 0, 0, false); ok {
+		t.Error("no stop or target should never exit")
+	}
+}
+
+func TestSimulateRuleExits(t *testing.T) {
+	cfg := BacktestConfig{TakeProfit: 0.05, StopLoss: 0.03}
+	tests := []struct {
+		name   string
+		cfg    BacktestConfig
+		edit   func(k []Kline)
+		reason string
+		exit   float64
+		bars   int
+	}{
+		{"take profit", cfg, func(k []Kline) { k[4].High = 106 }, "tp", 105, 2},
+		{"stop loss", cfg, func(k []Kline) { k[3].Low = 96 }, "sl", 97, 1},
+		{"gap through stop", cfg, func(k []Kline) {
+			k[3] = Kline{OpenTime: k[3].OpenTime, CloseTime: k[3].CloseTime, Open: 95, High: 96, Low: 94, Close: 95}
+		}, "sl", 95, 1},
+		{"stop wins on same bar", cfg, func(k []Kline) { k[5].High, k[5].Low = 106, 96 }, "sl", 97, 3},
+		{"time", BacktestConfig{TakeProfit: 0.05, StopLoss: 0.03, MaxBars: 3}, func(k []Kline) { k[5].Close = 101 }, "time", 101, 3},
+		{"end", cfg, func(k []Kline) { k[7].Close = 102 }, "end", 102, 5},
+	}
+	for _, tt := range tests {
+		klines := flatBacktestKlines(8, 2)
+		tt.edit(klines)
+		trades := simulateRule(volumeSpikeRule, klines, nil, tt.cfg)
+		if len(trades) != 1 {
+			t.Fatalf("%s: expected 1 trade, got %+v", tt.name, trades)
+		}
+		trade := trades[0]
+		if trade.EntryTime != klines[2].CloseTime || trade.EntryPrice != 100 {
+			t.Errorf("%s: should enter at the signal close: %+v", tt.name, trade)
+		}
+		if trade.Reason != tt.reason || trade.ExitPrice != tt.exit || trade.Bars != tt.bars || trade.ExitTime != klines[2+tt.bars].CloseTime {
+			t.Errorf("%s: got %+v, want %s at %v after %d bars", tt.name, trade, tt.reason, tt.exit, tt.bars)
+		}
+		if math.Abs(trade.Return-(tt.exit/100-1)) > 1e-12 {
+			t.Errorf("%s: return %v", tt.name, trade.Return)
+		}
+	}
+}
+
+func TestSimulateRuleNoReentry(t *testing.T) {
+	// 第 3、5 根的信号在持仓期间，平仓K线上的信号也不开仓
+	klines := flatBacktestKlines(10, 2, 3, 5, 7)
+	klines[5].High = 106
+	trades := simulateRule(volumeSpikeRule, klines, nil, BacktestConfig{TakeProfit: 0.05})
+	if len(trades) != 2 {
+		t.Fatalf("expected 2 trades, got %+v", trades)
+	}
+	if trades[0].EntryTime != klines[2].CloseTime || trades[0].ExitTime != klines[5].CloseTime || trades[0].Reason != "tp" {
+		t.Fatalf("unexpected first trade: %+v", trades[0])
+	}
+	if trades[1].EntryTime != klines[7].CloseTime || trades[1].Reason != "end" || trades[1].Bars != 2 {
+		t.Fatalf("unexpected second trade: %+v", trades[1])
+	}
+
+	// 最后一根K线上的信号没有后续K线，不开仓
+	if trades := simulateRule(volumeSpikeRule, flatBacktestKlines(5, 4), nil, BacktestConfig{}); len(trades) != 0 {
+		t.Fatalf("signal on the last bar should not trade: %+v", trades)
+	}
+	// From/To 按信号K线的开盘时间过滤
+	cfg := BacktestConfig{From: klines[3].OpenTime, To: klines[7].OpenTime}
+	if trades := simulateRule(volumeSpikeRule, flatBacktestKlines(10, 2, 5, 7), nil, cfg); len(trades) != 1 || trades[0].EntryTime != klines[5].CloseTime {
+		t.Fatalf("unexpected trades in range: %+v", trades)
+	}
+}
+
+func TestSimulateRuleFees(t *testing.T) {
+	klines := flatBacktestKlines(6, 1)
+	klines[3].High = 110
+	cfg := BacktestConfig{TakeProfit: 0.05, Fee: 0.001, Slippage: 0.002}
+	trades := simulateRule(volumeSpikeRule, klines, nil, cfg)
+	if len(trades) != 1 {
+		t.Fatalf("expected 1 trade, got %+v", trades)
+	}
+	// 开仓价含滑点，止盈按开仓价计算，平仓价扣除滑点，收益率扣除双边手续费
+	entry := 100 * 1.002
+	exit := entry * 1.05 * 0.998
+	trade := trades[0]
+	if math.Abs(trade.EntryPrice-entry) > 1e-9 || math.Abs(trade.ExitPrice-exit) > 1e-9 {
+		t.Fatalf("unexpected prices: %+v", trade)
+	}
+	if want := exit/entry - 1 - 0.002; math.Abs(trade.Return-want) > 1e-12 {
+		t.Fatalf("return = %v, want %v", trade.Return, want)
+	}
+}
+
+func TestComputeBacktestStats(t *testing.T) {
+	if stats := computeBacktestStats(nil); stats != (BacktestStats{}) {
+		t.Fatalf("empty trades should give zero stats: %+v", stats)
+	}
+	// 按平仓时间排序后权益依次为 0.1, 0.05, 0, 0.02
+	trades := []BacktestTrade{
+		{ExitTime: 4, Return: 0.02, Bars: 4},
+		{ExitTime: 1, Return: 0.1, Bars: 1},
+		{ExitTime: 3, Return: -0.05, Bars: 3},
+		{ExitTime: 2, Return: -0.05, Bars: 2},
+	}
+	stats := computeBacktestStats(trades)
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-12 }
+	if stats.Trades != 4 || stats.Wins != 2 || stats.WinRate != 0.5 || stats.AvgBars != 2.5 {
+		t.Fatalf("unexpected counts: %+v", stats)
+	}
+	if !near(stats.TotalReturn, 0.02) || !near(stats.Expectancy, 0.005) || !near(stats.MaxDrawdown, 0.1) {
+		t.Fatalf("unexpected returns: %+v", stats)
+	}
+	// 样本标准差：偏差平方和 0.0153，自由度 3
+	if want := 0.005 / math.Sqrt(0.0153/3); math.Abs(stats.Sharpe-want) > 1e-9 {
+		t.Fatalf("sharpe = %v, want %v", stats.Sharpe, want)
+	}
+	if stats := computeBacktestStats(trades[:1]); stats.Sharpe != 0 || stats.MaxDrawdown != 0 {
+		t.Fatalf("single trade should have no sharpe or drawdown: %+v", stats)
+	}
+}
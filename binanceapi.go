@@ -7,6 +7,7 @@ import (
 	"slices"
 	"strconv"
 
+	"github.com/samber/lo"
 	"gorm.io/gorm"
 )
 
@@ -21,6 +22,7 @@ type Kline struct {
 	Close     float64
 	Volume    float64
 	CloseTime int64
+	IsClosed  bool `gorm:"not null;default:false"` // 收盘后由币安返回的最终数据，由 saveKlines 写入，聚合K线要求桶内15m均已收盘
 }
 
 // TableName 为Kline结构体动态生成表名
@@ -52,8 +54,8 @@ func (k UnifiedKline) TableName() string {
 // ================= 币安 API 拉取 =================
 func fetchBinanceKlines(symbol string, interval string, startTime, endTime int64, limit int) ([]Kline, error) {
 	url := fmt.Sprintf(
-		"https://fapi.binance.com/fapi/v1/klines?symbol=%s&interval=%s&limit=%d",
-		symbol, interval, limit,
+		"%s/fapi/v1/klines?symbol=%s&interval=%s&limit=%d",
+		binanceFuturesAPI, symbol, interval, limit,
 	)
 	if startTime > 0 {
 		url += fmt.Sprintf("&startTime=%d", startTime)
@@ -75,31 +77,52 @@ func fetchBinanceKlines(symbol string, interval string, startTime, endTime int64
 
 	klines := make([]Kline, 0, len(raw))
 	for _, item := range raw {
-		openTime := int64(item[0].(float64))
-		open, _ := strconv.ParseFloat(item[1].(string), 64)
-		high, _ := strconv.ParseFloat(item[2].(string), 64)
-		low, _ := strconv.ParseFloat(item[3].(string), 64)
-		closePrice, _ := strconv.ParseFloat(item[4].(string), 64)
-		volume, _ := strconv.ParseFloat(item[5].(string), 64)
-		closeTime := int64(item[6].(float64))
+		if len(item) < 7 {
+			return nil, fmt.Errorf("invalid kline for %s: %v", symbol, item)
+		}
+		openTime, _ := item[0].(float64)
+		closeTime, _ := item[6].(float64)
+		// 解析失败时整批放弃，避免写入为 0 的价格
+		var values [5]float64
+		for i := range values {
+			text, _ := item[i+1].(string)
+			v, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid kline for %s at %d: %w", symbol, int64(openTime), err)
+			}
+			values[i] = v
+		}
 
 		klines = append(klines, Kline{
 			Symbol:    symbol,
-			OpenTime:  openTime,
-			Open:      open,
-			High:      high,
-			Low:       low,
-			Close:     closePrice,
-			Volume:    volume,
-			CloseTime: closeTime,
+			OpenTime:  int64(openTime),
+			Open:      values[0],
+			High:      values[1],
+			Low:       values[2],
+			Close:     values[3],
+			Volume:    values[4],
+			CloseTime: int64(closeTime),
 		})
 	}
 	return klines, nil
 }
 
 // ================= 动态窗口聚合查询 =================
-func queryAggregatedKlines(db *gorm.DB, symbol string, interval string, limit int) ([][]interface{}, error) {
-	var result = getAggKline(db, symbol, interval, limit)
+
+// queryAggregatedKlines 返回币安格式的K线，chart 不为空时返回最近 limit 根派生K线，closedOnly 时只返回已确认收盘的K线
+func queryAggregatedKlines(db *gorm.DB, symbol string, interval string, limit int, chart *ChartType, closedOnly bool) ([][]interface{}, error) {
+	if limit <= 0 {
+		limit = 200
+	}
+	source := chart.sourceBars(limit)
+	if closedOnly {
+		// 未确认收盘的通常只有最新的一两根
+		source += 4
+	}
+	result := chart.apply(getAggKlineAsc(db, symbol, interval, source, closedOnly))
+	if len(result) > limit {
+		result = result[len(result)-limit:]
+	}
 	// 按币安 API 返回格式组装（二维数组）
 	resp := make([][]interface{}, 0)
 	for _, k := range result {
@@ -118,44 +141,48 @@ func queryAggregatedKlines(db *gorm.DB, symbol string, interval string, limit in
 			"0",                           // Ignore
 		})
 	}
-	slices.Reverse(resp)
 	return resp, nil
 }
 
 func getAggKline(db *gorm.DB, symbol string, interval string, limit int) (result []Kline) {
-	// 创建一个带有symbol的Kline实例，用于获取表名
-	kline := Kline{Symbol: symbol}
-
 	if limit == 0 {
 		limit = 200
 	}
 	if !slices.Contains([]string{"15m", "1h", "4h", "1d"}, interval) {
 		interval = "15m"
 	}
+	return queryKlines(db, symbol, interval, "", fmt.Sprintf("ORDER BY open_time desc limit %d", limit))
+}
+
+// getKlineRange 按时间升序返回开盘时间在 [from, to) 内的K线，from 向下取整到周期边界，使第一根聚合K线完整
+func getKlineRange(db *gorm.DB, symbol string, interval string, from, to int64) []Kline {
+	ms := intervalMillis(interval)
+	if ms == 0 {
+		return nil
+	}
+	from = from / ms * ms
+	return queryKlines(db, symbol, interval, "WHERE open_time >= ? AND open_time < ?", "ORDER BY open_time asc", from, to)
+}
+
+// queryKlines 查询15m或聚合K线，where 作用于15m原始数据，order 为排序和数量限制
+func queryKlines(db *gorm.DB, symbol, interval, where, order string, args ...any) (result []Kline) {
+	// 创建一个带有symbol的Kline实例，用于获取表名
+	kline := Kline{Symbol: symbol}
 
 	var query string
 	if interval == "15m" {
 		tableName := kline.TableName()
-		query = fmt.Sprintf(`SELECT symbol, open_time, open, high, low, close, volume, close_time FROM %s ORDER BY open_time desc limit %d;`, tableName, limit)
+		query = fmt.Sprintf(`SELECT symbol, open_time, open, high, low, close, volume, close_time, COALESCE(is_closed, 0) FROM %s %s %s;`, tableName, where, order)
 	} else {
-		var bucketMs int64
-		switch interval {
-		// case "15m":
-		// bucketMs = 15 * 60 * 1000
-		case "1h":
-			bucketMs = 60 * 60 * 1000
-		case "4h":
-			bucketMs = 4 * 60 * 60 * 1000
-		case "1d":
-			bucketMs = 24 * 60 * 60 * 1000
-		default:
+		bucketMs := intervalMillis(interval)
+		if bucketMs == 0 {
 			return
 		}
 		tableName := kline.TableName()
 		query = fmt.Sprintf(`
 		WITH base AS (
-		SELECT symbol, open_time, open, high, low, close, volume, close_time, CAST(open_time / %d AS INTEGER) * %d AS bucket_start
-		FROM %s
+		SELECT symbol, open_time, open, high, low, close, volume, close_time, COALESCE(is_closed, 0) AS is_closed, CAST(open_time / %d AS INTEGER) * %d AS bucket_start
+		FROM %s %s
 		),
 		agg AS (
 		SELECT
@@ -167,13 +194,17 @@ func getAggKline(db *gorm.DB, symbol string, interval string, limit int) (result
 			FIRST_VALUE(close) OVER (PARTITION BY bucket_start ORDER BY open_time DESC) AS close,
 			SUM(volume) OVER (PARTITION BY bucket_start) AS volume,
 			MAX(close_time) OVER (PARTITION BY bucket_start) AS close_time,
+			MIN(is_closed) OVER (PARTITION BY bucket_start) AS all_closed,
+			MAX(open_time) OVER (PARTITION BY bucket_start) AS last_open,
 			ROW_NUMBER() OVER (PARTITION BY bucket_start ORDER BY open_time ASC) AS rn
 		FROM base
 		)
-		SELECT symbol, bucket_start AS open_time, open, high, low, close, volume, close_time FROM agg WHERE rn = 1 ORDER BY open_time desc limit %d;
-	`, bucketMs, bucketMs, tableName, limit)
+		SELECT symbol, bucket_start AS open_time, open, high, low, close, volume, close_time,
+			all_closed = 1 AND last_open = bucket_start + %d AS is_closed
+		FROM agg WHERE rn = 1 %s;
+	`, bucketMs, bucketMs, tableName, where, bucketMs-intervalMillis("15m"), order)
 	}
-	rows, err := db.Raw(query).Rows()
+	rows, err := db.Raw(query, args...).Rows()
 	if err != nil {
 		return
 	}
@@ -181,7 +212,7 @@ func getAggKline(db *gorm.DB, symbol string, interval string, limit int) (result
 
 	for rows.Next() {
 		var k Kline
-		if err := rows.Scan(&k.Symbol, &k.OpenTime, &k.Open, &k.High, &k.Low, &k.Close, &k.Volume, &k.CloseTime); err != nil {
+		if err := rows.Scan(&k.Symbol, &k.OpenTime, &k.Open, &k.High, &k.Low, &k.Close, &k.Volume, &k.CloseTime, &k.IsClosed); err != nil {
 			return
 		}
 		result = append(result, k)
@@ -189,6 +220,31 @@ func getAggKline(db *gorm.DB, symbol string, interval string, limit int) (result
 	return
 }
 
+// intervalMillis 返回支持的聚合周期对应的毫秒数，不支持的周期返回0
+func intervalMillis(interval string) int64 {
+	switch interval {
+	case "15m":
+		return 15 * 60 * 1000
+	case "1h":
+		return 60 * 60 * 1000
+	case "4h":
+		return 4 * 60 * 60 * 1000
+	case "1d":
+		return 24 * 60 * 60 * 1000
+	}
+	return 0
+}
+
+// getAggKlineAsc 按时间升序返回聚合K线，closedOnly 为 true 时只保留已确认收盘的K线
+func getAggKlineAsc(db *gorm.DB, symbol string, interval string, limit int, closedOnly bool) []Kline {
+	klines := getAggKline(db, symbol, interval, limit)
+	if closedOnly {
+		klines = lo.Filter(klines, func(item Kline, index int) bool { return item.IsClosed })
+	}
+	slices.Reverse(klines)
+	return klines
+}
+
 // createIndexForKlineTable 为Kline表动态创建联合索引
 func createIndexForKlineTable(db *gorm.DB, tableName string) error {
 	// 生成动态索引名，包含表名以确保唯一性
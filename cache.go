@@ -1,106 +1,288 @@
 package main
 
 import (
+	"container/list"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
-	"log"
 	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/ledisdb/ledisdb/config"
 	"github.com/ledisdb/ledisdb/ledis"
 )
 
-// LedisCache 使用ledisdb实现的缓存
-type LedisCache struct {
-	ledis *ledis.Ledis
-	db    *ledis.DB
+// Cache 键值缓存，ttl 为 0 时不过期
+type Cache interface {
+	// Get 返回键对应的值，键不存在或已过期时返回 false
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	// SetNX 键不存在或已过期时写入并返回 true，用于去重
+	SetNX(key string, value []byte, ttl time.Duration) (bool, error)
+	Delete(key string) error
+	Close() error
 }
 
-// NewLedisCache 创建新的缓存实例
-func NewLedisCache() *LedisCache {
-	// 创建数据目录
-	dataDir := "./cache_data"
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		log.Fatal("Failed to create cache data directory:", err)
-	}
+// cache 全局缓存实例，main 按配置替换为 LedisDB，测试使用内存缓存
+var cache Cache = NewMemoryCache(10000)
 
-	// 配置ledisdb
-	cfg := config.NewConfigDefault()
-	cfg.DataDir = dataDir
+// ================= 编解码 =================
 
-	// 创建ledis实例
-	l, err := ledis.Open(cfg)
+// Codec 在类型 T 和缓存中的字节之间转换
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// JSONCodec 以 JSON 保存任意类型
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) { return json.Marshal(v) }
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// StringCodec 原样保存字符串
+type StringCodec struct{}
+
+func (StringCodec) Encode(v string) ([]byte, error)    { return []byte(v), nil }
+func (StringCodec) Decode(data []byte) (string, error) { return string(data), nil }
+
+// Int64Codec 以十进制文本保存整数
+type Int64Codec struct{}
+
+func (Int64Codec) Encode(v int64) ([]byte, error) { return strconv.AppendInt(nil, v, 10), nil }
+func (Int64Codec) Decode(data []byte) (int64, error) {
+	return strconv.ParseInt(string(data), 10, 64)
+}
+
+// TypedCache 通过 Codec 读写类型 T 的值
+type TypedCache[T any] struct {
+	Cache Cache
+	Codec Codec[T]
+}
+
+// NewTypedCache 创建类型化的缓存视图
+func NewTypedCache[T any](c Cache, codec Codec[T]) TypedCache[T] {
+	return TypedCache[T]{Cache: c, Codec: codec}
+}
+
+func (t TypedCache[T]) Get(key string) (T, bool, error) {
+	var zero T
+	data, ok, err := t.Cache.Get(key)
+	if err != nil || !ok {
+		return zero, false, err
+	}
+	v, err := t.Codec.Decode(data)
 	if err != nil {
-		log.Fatal("Failed to open ledis:", err)
+		return zero, false, fmt.Errorf("decode %s: %w", key, err)
 	}
+	return v, true, nil
+}
 
-	// 选择数据库0
-	db, err := l.Select(0)
+func (t TypedCache[T]) Set(key string, v T, ttl time.Duration) error {
+	data, err := t.Codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return t.Cache.Set(key, data, ttl)
+}
+
+func (t TypedCache[T]) SetNX(key string, v T, ttl time.Duration) (bool, error) {
+	data, err := t.Codec.Encode(v)
 	if err != nil {
-		log.Fatal("Failed to select database:", err)
+		return false, err
 	}
+	return t.Cache.SetNX(key, data, ttl)
+}
 
-	cache := &LedisCache{
-		ledis: l,
-		db:    db,
+// expireAt 返回 ttl 对应的过期时间（毫秒），0 表示不过期
+func expireAt(now time.Time, ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
 	}
+	return now.Add(ttl).UnixMilli()
+}
+
+// ================= 内存 LRU =================
+
+// MemoryCache 进程内的 LRU 缓存，超过容量时淘汰最久未使用的键
+type MemoryCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List // 队首为最近使用
+	items    map[string]*list.Element
+	now      func() time.Time
+}
+
+type memoryEntry struct {
+	key      string
+	value    []byte
+	expireAt int64
+}
 
-	return cache
+// NewMemoryCache 创建容量为 capacity 个键的内存缓存，capacity <= 0 时不限制
+func NewMemoryCache(capacity int) *MemoryCache {
+	return &MemoryCache{capacity: capacity, ll: list.New(), items: map[string]*list.Element{}, now: time.Now}
 }
 
-// SetEx 设置键值对，过期时间以小时为单位
-func (c *LedisCache) SetEx(key string, value interface{}, hours int64) {
-	// 将值转换为字节
-	var valueBytes []byte
-	switch v := value.(type) {
-	case string:
-		valueBytes = []byte(v)
-	case []byte:
-		valueBytes = v
-	default:
-		// 对于其他类型，转换为字符串
-		valueBytes = []byte(fmt.Sprintf("%v", v))
+// lookup 返回未过期的条目并标记为最近使用，过期的条目顺便删除，调用方持有锁
+func (c *MemoryCache) lookup(key string) (*memoryEntry, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*memoryEntry)
+	if e.expireAt != 0 && c.now().UnixMilli() >= e.expireAt {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return nil, false
 	}
+	c.ll.MoveToFront(el)
+	return e, true
+}
 
-	// 设置键值对
-	if err := c.db.Set([]byte(key), valueBytes); err != nil {
-		log.Printf("Failed to set key %s: %v", key, err)
+// put 写入条目并淘汰超出容量的键，调用方持有锁
+func (c *MemoryCache) put(key string, value []byte, ttl time.Duration) {
+	e := &memoryEntry{key: key, value: append([]byte(nil), value...), expireAt: expireAt(c.now(), ttl)}
+	if el, ok := c.items[key]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
 		return
 	}
+	c.items[key] = c.ll.PushFront(e)
+	for c.capacity > 0 && c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*memoryEntry).key)
+	}
+}
 
-	// 设置过期时间（以秒为单位）
-	duration := hours * 3600 // 转换为秒
-	if _, err := c.db.Expire([]byte(key), duration); err != nil {
-		log.Printf("Failed to set expiration for key %s: %v", key, err)
+func (c *MemoryCache) Get(key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.lookup(key)
+	if !ok {
+		return nil, false, nil
 	}
+	return append([]byte(nil), e.value...), true, nil
 }
 
-// Get 获取键对应的值
-func (c *LedisCache) Get(key string) (interface{}, bool) {
-	value, err := c.db.Get([]byte(key))
-	if err != nil {
-		log.Printf("Failed to get key %s: %v", key, err)
-		return nil, false
+func (c *MemoryCache) Set(key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(key, value, ttl)
+	return nil
+}
+
+func (c *MemoryCache) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.lookup(key); ok {
+		return false, nil
 	}
+	c.put(key, value, ttl)
+	return true, nil
+}
 
-	// 检查键是否存在
-	if value == nil {
-		return nil, false
+func (c *MemoryCache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.Remove(el)
+		delete(c.items, key)
 	}
+	return nil
+}
+
+func (c *MemoryCache) Close() error { return nil }
+
+// ================= LedisDB =================
+
+// LedisCache 使用ledisdb实现的持久化缓存。
+// ledis 的 TTL 只有秒级，且无法区分未设置过期和已过期未清理的键，
+// 因此值前 8 字节保存毫秒级的过期时间（0 为不过期），读取时以此为准
+type LedisCache struct {
+	mu    sync.Mutex // 保证 SetNX 的检查和写入是原子的
+	ledis *ledis.Ledis
+	db    *ledis.DB
+	now   func() time.Time
+}
 
-	// 检查是否过期
-	ttl, err := c.db.TTL([]byte(key))
+// NewLedisCache 在 dir 目录打开 ledisdb
+func NewLedisCache(dir string) (*LedisCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create cache directory: %w", err)
+	}
+	cfg := config.NewConfigDefault()
+	cfg.DataDir = dir
+	l, err := ledis.Open(cfg)
 	if err != nil {
-		log.Printf("Failed to get TTL for key %s: %v", key, err)
-		return nil, false
+		return nil, fmt.Errorf("open ledis: %w", err)
 	}
+	db, err := l.Select(0)
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("select ledis db: %w", err)
+	}
+	return &LedisCache{ledis: l, db: db, now: time.Now}, nil
+}
 
-	// 如果TTL为-1，表示键已过期或不存在
-	// 如果TTL为-2，表示键不存在
-	if ttl == -1 || ttl == -2 {
-		return nil, false
+func (c *LedisCache) get(key string) ([]byte, bool, error) {
+	data, err := c.db.Get([]byte(key))
+	if err != nil || len(data) < 8 {
+		return nil, false, err
+	}
+	if at := int64(binary.BigEndian.Uint64(data)); at != 0 && c.now().UnixMilli() >= at {
+		return nil, false, nil
 	}
+	return data[8:], true, nil
+}
+
+func (c *LedisCache) set(key string, value []byte, ttl time.Duration) error {
+	data := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(value)), uint64(expireAt(c.now(), ttl)))
+	data = append(data, value...)
+	if ttl <= 0 {
+		if err := c.db.Set([]byte(key), data); err != nil {
+			return err
+		}
+		_, err := c.db.Persist([]byte(key))
+		return err
+	}
+	// 物理过期向上取整到秒，由 ledis 在后台清理
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	return c.db.SetEX([]byte(key), seconds, data)
+}
+
+func (c *LedisCache) Get(key string) ([]byte, bool, error) {
+	return c.get(key)
+}
+
+func (c *LedisCache) Set(key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.set(key, value, ttl)
+}
+
+func (c *LedisCache) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok, err := c.get(key); err != nil || ok {
+		return false, err
+	}
+	return true, c.set(key, value, ttl)
+}
 
-	return string(value), true
+func (c *LedisCache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.db.Del([]byte(key))
+	return err
 }
 
 // Close 关闭缓存连接
@@ -110,3 +292,38 @@ func (c *LedisCache) Close() error {
 	}
 	return nil
 }
+
+// ================= 配置 =================
+
+// cacheConfig 缓存后端配置
+type cacheConfig struct {
+	Backend  string // ledis（默认）或 memory
+	Dir      string // ledis 数据目录
+	Capacity int    // memory 的最大键数
+}
+
+// cacheConfigFromEnv 从 CACHE_BACKEND、CACHE_DIR、CACHE_CAPACITY 读取缓存配置
+func cacheConfigFromEnv() cacheConfig {
+	cfg := cacheConfig{Backend: os.Getenv("CACHE_BACKEND"), Dir: os.Getenv("CACHE_DIR"), Capacity: 10000}
+	if cfg.Backend == "" {
+		cfg.Backend = "ledis"
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = "./cache_data"
+	}
+	if v, err := strconv.Atoi(os.Getenv("CACHE_CAPACITY")); err == nil && v > 0 {
+		cfg.Capacity = v
+	}
+	return cfg
+}
+
+// newCache 按配置创建缓存
+func newCache(cfg cacheConfig) (Cache, error) {
+	switch cfg.Backend {
+	case "ledis":
+		return NewLedisCache(cfg.Dir)
+	case "memory":
+		return NewMemoryCache(cfg.Capacity), nil
+	}
+	return nil, fmt.Errorf("unknown cache backend %q", cfg.Backend)
+}
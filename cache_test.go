@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testCache 对任意实现执行相同的行为检查，advance 推进实现使用的时钟
+func testCache(t *testing.T, c Cache, advance func(time.Duration)) {
+	t.Helper()
+	if err := c.Set("forever", []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("short", []byte("s"), 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := c.Get("forever"); err != nil || !ok || string(v) != "v" {
+		t.Fatalf("key without ttl should exist: %q %v %v", v, ok, err)
+	}
+
+	if ok, _ := c.SetNX("short", []byte("x"), time.Minute); ok {
+		t.Fatal("SetNX should not overwrite a live key")
+	}
+	advance(time.Second)
+	if _, ok, _ := c.Get("short"); !ok {
+		t.Fatal("key should live until its ttl")
+	}
+	advance(time.Second)
+	if _, ok, _ := c.Get("short"); ok {
+		t.Fatal("key should expire after its ttl")
+	}
+	if ok, _ := c.SetNX("short", []byte("x"), time.Minute); !ok {
+		t.Fatal("SetNX should write an expired key")
+	}
+
+	typed := NewTypedCache[map[string]int](c, JSONCodec[map[string]int]{})
+	if err := typed.Set("json", map[string]int{"a": 1}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := typed.Get("json"); err != nil || !ok || v["a"] != 1 {
+		t.Fatalf("typed get = %v %v %v", v, ok, err)
+	}
+	if _, _, err := NewTypedCache[int64](c, Int64Codec{}).Get("json"); err == nil {
+		t.Fatal("expected decode error")
+	}
+
+	if err := c.Delete("forever"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := c.Get("forever"); ok {
+		t.Fatal("deleted key should be gone")
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	c := NewMemoryCache(3)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	testCache(t, c, func(d time.Duration) { now = now.Add(d) })
+
+	// 超过容量时淘汰最久未使用的键
+	c = NewMemoryCache(2)
+	c.Set("a", []byte("1"), 0)
+	c.Set("b", []byte("2"), 0)
+	c.Get("a")
+	c.Set("c", []byte("3"), 0)
+	if _, ok, _ := c.Get("b"); ok {
+		t.Fatal("least recently used key should be evicted")
+	}
+	if _, ok, _ := c.Get("a"); !ok {
+		t.Fatal("recently used key should be kept")
+	}
+}
+
+func TestLedisCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewLedisCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	testCache(t, c, func(d time.Duration) { now = now.Add(d) })
+
+	c.Set("persist", []byte("p"), 0)
+	c.Close()
+	c, err = NewLedisCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if v, ok, _ := c.Get("persist"); !ok || string(v) != "p" {
+		t.Fatal("value should survive reopening")
+	}
+
+	if _, err := newCache(cacheConfig{Backend: "redis"}); err == nil {
+		t.Fatal("expected unknown backend error")
+	}
+}
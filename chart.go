@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// ================= K线图渲染 =================
+
+// chartOptions 图表参数
+type chartOptions struct {
+	Width  int
+	Height int
+	Bars   int   // 显示的K线数量
+	EMA    []int // 叠加在价格图上的 EMA 周期
+}
+
+var defaultChartOptions = chartOptions{Width: 960, Height: 640, Bars: 120, EMA: []int{20, 144}}
+
+// chartWarmup 为指标预热额外加载的K线数量
+func (o chartOptions) chartWarmup() int {
+	warmup := 100
+	for _, p := range o.EMA {
+		warmup = max(warmup, p*2)
+	}
+	return warmup
+}
+
+var (
+	chartBackground = color.RGBA{255, 255, 255, 255}
+	chartGrid       = color.RGBA{236, 236, 236, 255}
+	chartText       = color.RGBA{60, 60, 60, 255}
+	chartUp         = color.RGBA{38, 166, 154, 255}
+	chartDown       = color.RGBA{239, 83, 80, 255}
+	chartUpVolume   = color.RGBA{160, 215, 210, 255}
+	chartDownVolume = color.RGBA{245, 175, 173, 255}
+	chartLines      = []color.RGBA{{41, 98, 255, 255}, {255, 152, 0, 255}, {156, 39, 176, 255}, {0, 150, 136, 255}}
+)
+
+// glyphs 5x7 点阵字体，只包含图表需要的字符，周期中的 m/h/d 保留小写
+var glyphs = map[rune]string{
+	'0': "01110 10001 10011 10101 11001 10001 01110",
+	'1': "00100 01100 00100 00100 00100 00100 01110",
+	'2': "01110 10001 00001 00010 00100 01000 11111",
+	'3': "11111 00010 00100 00010 00001 10001 01110",
+	'4': "00010 00110 01010 10010 11111 00010 00010",
+	'5': "11111 10000 11110 00001 00001 10001 01110",
+	'6': "00110 01000 10000 11110 10001 10001 01110",
+	'7': "11111 00001 00010 00100 01000 01000 01000",
+	'8': "01110 10001 10001 01110 10001 10001 01110",
+	'9': "01110 10001 10001 01111 00001 00010 01100",
+	'A': "01110 10001 10001 11111 10001 10001 10001",
+	'B': "11110 10001 10001 11110 10001 10001 11110",
+	'C': "01110 10001 10000 10000 10000 10001 01110",
+	'D': "11100 10010 10001 10001 10001 10010 11100",
+	'E': "11111 10000 10000 11110 10000 10000 11111",
+	'F': "11111 10000 10000 11110 10000 10000 10000",
+	'G': "01110 10001 10000 10111 10001 10001 01111",
+	'H': "10001 10001 10001 11111 10001 10001 10001",
+	'I': "01110 00100 00100 00100 00100 00100 01110",
+	'J': "00111 00010 00010 00010 00010 10010 01100",
+	'K': "10001 10010 10100 11000 10100 10010 10001",
+	'L': "10000 10000 10000 10000 10000 10000 11111",
+	'M': "10001 11011 10101 10101 10001 10001 10001",
+	'N': "10001 10001 11001 10101 10011 10001 10001",
+	'O': "01110 10001 10001 10001 10001 10001 01110",
+	'P': "11110 10001 10001 11110 10000 10000 10000",
+	'Q': "01110 10001 10001 10001 10101 10010 01101",
+	'R': "11110 10001 10001 11110 10100 10010 10001",
+	'S': "01111 10000 10000 01110 00001 00001 11110",
+	'T': "11111 00100 00100 00100 00100 00100 00100",
+	'U': "10001 10001 10001 10001 10001 10001 01110",
+	'V': "10001 10001 10001 10001 10001 01010 00100",
+	'W': "10001 10001 10001 10101 10101 10101 01010",
+	'X': "10001 10001 01010 00100 01010 10001 10001",
+	'Y': "10001 10001 10001 01010 00100 00100 00100",
+	'Z': "11111 00001 00010 00100 01000 10000 11111",
+	'd': "00001 00001 01101 10011 10001 10001 01111",
+	'h': "10000 10000 10110 11001 10001 10001 10001",
+	'm': "00000 00000 11010 10101 10101 10001 10001",
+	'.': "00000 00000 00000 00000 00000 01100 01100",
+	',': "00000 00000 00000 00000 01100 00100 01000",
+	'-': "00000 00000 00000 11111 00000 00000 00000",
+	'+': "00000 00100 00100 11111 00100 00100 00000",
+	':': "00000 01100 01100 00000 01100 01100 00000",
+	'%': "11000 11001 00010 00100 01000 10011 00011",
+	'/': "00000 00001 00010 00100 01000 10000 00000",
+	'(': "00010 00100 01000 01000 01000 00100 00010",
+	')': "01000 00100 00010 00010 00010 00100 01000",
+	' ': "00000 00000 00000 00000 00000 00000 00000",
+}
+
+// canvas 带裁剪区域的绘图辅助
+type canvas struct {
+	img  *image.RGBA
+	clip image.Rectangle
+}
+
+func (c *canvas) set(x, y int, col color.RGBA) {
+	if image.Pt(x, y).In(c.clip) {
+		c.img.SetRGBA(x, y, col)
+	}
+}
+
+func (c *canvas) fill(r image.Rectangle, col color.RGBA) {
+	r = r.Intersect(c.clip)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c.img.SetRGBA(x, y, col)
+		}
+	}
+}
+
+// line Bresenham 直线
+func (c *canvas) line(x0, y0, x1, y1 int, col color.RGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		c.set(x0, y0, col)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if e2 := 2 * e; e2 >= dy {
+			e += dy
+			x0 += sx
+		} else {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// text 以 scale 倍绘制文字，没有对应字形的小写字母按大写绘制，返回文字宽度
+func (c *canvas) text(x, y int, s string, scale int, col color.RGBA) int {
+	start := x
+	for _, r := range s {
+		glyph, ok := glyphs[r]
+		if !ok {
+			glyph = glyphs[unicode.ToUpper(r)]
+		}
+		rows := strings.Fields(glyph)
+		for gy, row := range rows {
+			for gx, bit := range row {
+				if bit == '1' {
+					c.fill(image.Rect(x+gx*scale, y+gy*scale, x+(gx+1)*scale, y+(gy+1)*scale), col)
+				}
+			}
+		}
+		x += 6 * scale
+	}
+	return x - start
+}
+
+// pane 图表中的一个分区，把数值映射到纵坐标
+type pane struct {
+	rect     image.Rectangle
+	min, max float64
+}
+
+func (p pane) y(v float64) int {
+	if p.max <= p.min {
+		return (p.rect.Min.Y + p.rect.Max.Y) / 2
+	}
+	return p.rect.Max.Y - 1 - int(math.Round((v-p.min)/(p.max-p.min)*float64(p.rect.Dy()-1)))
+}
+
+// newPane 根据序列的有限值确定范围，并留出上下边距
+func newPane(rect image.Rectangle, series ...[]float64) pane {
+	p := pane{rect: rect, min: math.Inf(1), max: math.Inf(-1)}
+	for _, s := range series {
+		for _, v := range s {
+			if !math.IsNaN(v) && !math.IsInf(v, 0) {
+				p.min = math.Min(p.min, v)
+				p.max = math.Max(p.max, v)
+			}
+		}
+	}
+	if math.IsInf(p.min, 0) {
+		p.min, p.max = 0, 1
+	}
+	pad := (p.max - p.min) * 0.05
+	if pad == 0 {
+		pad = math.Abs(p.max)*0.01 + 1e-9
+	}
+	p.min -= pad
+	p.max += pad
+	return p
+}
+
+// formatChartPrice 按有效数字格式化价格
+func formatChartPrice(v float64) string {
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
+
+// renderChart 绘制K线、成交量、EMA 和 MACD，klines 按时间升序，前面多出的K线只用于指标预热
+func renderChart(w io.Writer, symbol, interval string, klines []Kline, opts chartOptions) error {
+	if len(klines) == 0 {
+		return errors.New("no klines")
+	}
+	if opts.Width < 320 || opts.Height < 240 {
+		return errors.New("chart too small")
+	}
+	closes := klineCloses(klines)
+	emas := make([][]float64, len(opts.EMA))
+	for i, p := range opts.EMA {
+		values, _, err := computeIndicator("ema", klines, []float64{float64(p)})
+		if err != nil {
+			return err
+		}
+		emas[i] = values["ema"]
+	}
+	macd, _, err := computeIndicator("macd", klines, nil)
+	if err != nil {
+		return err
+	}
+
+	// 只显示最后 Bars 根
+	start := max(0, len(klines)-opts.Bars)
+	bars := klines[start:]
+	n := len(bars)
+	visible := func(s []float64) []float64 { return s[start:] }
+	highs, lows, _ := klineHLC(bars)
+	volumes := make([]float64, n)
+	for i, k := range bars {
+		volumes[i] = k.Volume
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+	c := &canvas{img: img, clip: img.Bounds()}
+	c.fill(img.Bounds(), chartBackground)
+
+	const scale = 2
+	left, right, top, bottom, gap := 8, 110, 36, 28, 8
+	plotW := opts.Width - left - right
+	plotH := opts.Height - top - bottom - 2*gap
+	priceH, volumeH := plotH*60/100, plotH*15/100
+	priceRect := image.Rect(left, top, left+plotW, top+priceH)
+	volumeRect := image.Rect(left, priceRect.Max.Y+gap, left+plotW, priceRect.Max.Y+gap+volumeH)
+	macdRect := image.Rect(left, volumeRect.Max.Y+gap, left+plotW, opts.Height-bottom)
+
+	emaVisible := make([][]float64, len(emas))
+	for i := range emas {
+		emaVisible[i] = visible(emas[i])
+	}
+	price := newPane(priceRect, append([][]float64{highs, lows}, emaVisible...)...)
+	volume := pane{rect: volumeRect, max: slices.Max(volumes) * 1.05}
+	macdLine, signalLine, hist := visible(macd["macd"]), visible(macd["signal"]), visible(macd["hist"])
+	macdPane := newPane(macdRect, macdLine, signalLine, hist, []float64{0})
+
+	slot := float64(plotW) / float64(n)
+	body := max(1, int(slot*0.7))
+	xOf := func(i int) int { return left + int(slot*float64(i)+slot/2) }
+
+	// 网格和右侧价格刻度
+	for t := 0; t <= 4; t++ {
+		v := price.min + (price.max-price.min)*float64(t)/4
+		y := price.y(v)
+		c.fill(image.Rect(left, y, left+plotW, y+1), chartGrid)
+		c.text(left+plotW+6, y-7, formatChartPrice(v), scale, chartText)
+	}
+	for _, r := range []image.Rectangle{priceRect, volumeRect, macdRect} {
+		c.fill(image.Rect(r.Max.X, r.Min.Y, r.Max.X+1, r.Max.Y), chartText)
+	}
+	zero := macdPane.y(0)
+	c.fill(image.Rect(left, zero, left+plotW, zero+1), chartGrid)
+
+	// 底部时间刻度
+	layout := "01-02 15:04"
+	if interval == "1d" {
+		layout = "2006-01-02"
+	}
+	labelW := len(layout) * 6 * scale
+	step := max(1, int(math.Ceil(float64(labelW+20)/slot)))
+	for i := step / 2; i < n; i += step {
+		x := xOf(i)
+		c.fill(image.Rect(x, top, x+1, opts.Height-bottom), chartGrid)
+		if x-labelW/2 >= 0 && x+labelW/2 <= left+plotW {
+			c.text(x-labelW/2, opts.Height-bottom+6, time.UnixMilli(bars[i].OpenTime).Format(layout), scale, chartText)
+		}
+	}
+
+	// K线和成交量
+	for i, k := range bars {
+		x := xOf(i)
+		col, volCol := chartUp, chartUpVolume
+		if k.Close < k.Open {
+			col, volCol = chartDown, chartDownVolume
+		}
+		c.clip = priceRect
+		c.fill(image.Rect(x, price.y(k.High), x+1, price.y(k.Low)+1), col)
+		yOpen, yClose := price.y(k.Open), price.y(k.Close)
+		c.fill(image.Rect(x-body/2, min(yOpen, yClose), x-body/2+body, max(yOpen, yClose)+1), col)
+		c.clip = volumeRect
+		c.fill(image.Rect(x-body/2, volume.y(k.Volume), x-body/2+body, volumeRect.Max.Y), volCol)
+		c.clip = macdRect
+		if h := hist[i]; !math.IsNaN(h) {
+			histCol := chartUpVolume
+			if h < 0 {
+				histCol = chartDownVolume
+			}
+			y := macdPane.y(h)
+			c.fill(image.Rect(x-body/2, min(y, zero), x-body/2+body, max(y, zero)+1), histCol)
+		}
+	}
+
+	// 折线
+	polyline := func(p pane, s []float64, col color.RGBA) {
+		c.clip = p.rect
+		for i := 1; i < len(s); i++ {
+			if math.IsNaN(s[i-1]) || math.IsNaN(s[i]) {
+				continue
+			}
+			c.line(xOf(i-1), p.y(s[i-1]), xOf(i), p.y(s[i]), col)
+		}
+	}
+	for i, s := range emaVisible {
+		polyline(price, s, chartLines[i%len(chartLines)])
+	}
+	polyline(macdPane, macdLine, chartLines[0])
+	polyline(macdPane, signalLine, chartLines[1])
+
+	// 标题、图例和最新价
+	c.clip = img.Bounds()
+	last := closes[len(closes)-1]
+	x := c.text(left, 10, fmt.Sprintf("%s %s  C %s", symbol, interval, formatChartPrice(last)), scale, chartText) + left + 24
+	for i, p := range opts.EMA {
+		x += c.text(x, 10, fmt.Sprintf("EMA%d", p), scale, chartLines[i%len(chartLines)]) + 18
+	}
+	lastCol := chartUp
+	if bars[n-1].Close < bars[n-1].Open {
+		lastCol = chartDown
+	}
+	y := price.y(last)
+	c.fill(image.Rect(left+plotW+2, y-9, opts.Width, y+9), lastCol)
+	c.text(left+plotW+6, y-7, formatChartPrice(last), scale, chartBackground)
+	c.text(left+4, volumeRect.Min.Y+4, "VOL", scale, chartText)
+	c.text(left+4, macdRect.Min.Y+4, "MACD(12,26,9)", scale, chartText)
+
+	return png.Encode(w, img)
+}
+
+// renderSymbolChart 从数据库加载K线并渲染 PNG
+func renderSymbolChart(db *gorm.DB, symbol, interval string, opts chartOptions) ([]byte, error) {
+	klines := getAggKlineAsc(db, symbol, interval, opts.Bars+opts.chartWarmup(), false)
+	if len(klines) == 0 {
+		return nil, fmt.Errorf("%s 暂无数据", symbol)
+	}
+	var buf bytes.Buffer
+	if err := renderChart(&buf, symbol, interval, klines, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// alertChartsMax 每条通知最多附带的图片数量
+const alertChartsMax = 10
+
+// alertCharts 为提醒中的代币渲染规则周期的K线图，ALERT_CHARTS=off 时不渲染
+func alertCharts(db *gorm.DB, interval string, symbolList []string) []NotificationImage {
+	if os.Getenv("ALERT_CHARTS") == "off" {
+		return nil
+	}
+	var images []NotificationImage
+	for _, symbol := range symbolList[:min(len(symbolList), alertChartsMax)] {
+		data, err := renderSymbolChart(db, symbol, interval, defaultChartOptions)
+		if err != nil {
+			log.Printf("渲染 %s K线图失败: %v", symbol, err)
+			continue
+		}
+		images = append(images, NotificationImage{Name: symbol + ".png", Caption: symbol + " " + interval, Data: data})
+	}
+	return images
+}
+
+// handleChartPNG 返回K线图
+// 例如 /chart.png?symbol=BTCUSDT&interval=1h&bars=120&ema=20,144&width=960&height=640
+func handleChartPNG(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		q := r.URL.Query()
+		symbol := q.Get("symbol")
+		interval := q.Get("interval")
+		if interval == "" {
+			interval = "1h"
+		}
+		if !isTrackedSymbol(symbol) {
+			http.Error(w, "unknown symbol", http.StatusNotFound)
+			return
+		}
+		if intervalMillis(interval) == 0 {
+			http.Error(w, "unsupported interval", http.StatusBadRequest)
+			return
+		}
+		opts := defaultChartOptions
+		if v, err := strconv.Atoi(q.Get("bars")); err == nil && v > 0 {
+			opts.Bars = min(v, 500)
+		}
+		if v, err := strconv.Atoi(q.Get("width")); err == nil {
+			opts.Width = min(v, 2000)
+		}
+		if v, err := strconv.Atoi(q.Get("height")); err == nil {
+			opts.Height = min(v, 2000)
+		}
+		if v := q.Get("ema"); v != "" {
+			periods, err := parseIntList(v)
+			if err == nil && (len(periods) > len(chartLines) || slices.Max(periods) > 500) {
+				err = fmt.Errorf("at most %d ema periods up to 500", len(chartLines))
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			opts.EMA = periods
+		}
+
+		data, err := renderSymbolChart(db, symbol, interval, opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write(data)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRenderChart(t *testing.T) {
+	klines := fixtureKlines(300, 4)
+	var buf bytes.Buffer
+	opts := chartOptions{Width: 800, Height: 500, Bars: 100, EMA: []int{20, 144}}
+	if err := renderChart(&buf, "TESTUSDT", "15m", klines, opts); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 800 || b.Dy() != 500 {
+		t.Fatalf("unexpected size %v", b)
+	}
+	// 最后一根K线所在列应有K线颜色
+	found := false
+	for y := 36; y < 300 && !found; y++ {
+		c := img.At(800-110-3, y)
+		found = c == chartUp || c == chartDown
+	}
+	if !found {
+		t.Fatal("last candle not drawn")
+	}
+
+	if err := renderChart(&buf, "TESTUSDT", "15m", klines[:5], opts); err != nil {
+		t.Fatalf("short history should still render: %v", err)
+	}
+}
+
+func TestTelegramNotifierSendsPhotos(t *testing.T) {
+	fake := &fakeTelegram{replies: make(chan map[string]any, 10)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	if err := renderChart(&buf, "TESTUSDT", "15m", fixtureKlines(200, 1), defaultChartOptions); err != nil {
+		t.Fatal(err)
+	}
+	tg := &TelegramNotifier{APIBase: srv.URL, Token: "t", ChatID: "42"}
+	n := Notification{Text: "alert", Images: []NotificationImage{{Name: "TESTUSDT.png", Caption: "TESTUSDT 15m", Data: buf.Bytes()}}}
+	if err := tg.Notify(t.Context(), n); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"", "sendPhoto"} {
+		select {
+		case got := <-fake.replies:
+			method, _ := got["method"].(string)
+			if method != want {
+				t.Fatalf("expected %q request, got %v", want, got)
+			}
+			if want == "sendPhoto" && (got["caption"] != "TESTUSDT 15m" || got["reply_to_message_id"] != "100" || got["photo_size"] != int64(buf.Len())) {
+				t.Fatalf("unexpected photo request: %v", got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no request received")
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+// ================= 非时间K线 =================
+
+// chartSourceBars 砖形图和等幅K线加载的源K线数量，生成的K线数量取决于价格波动
+const chartSourceBars = 1000
+
+// chartMaxBars 幅度相对波动过小时生成的K线数量上限，超过时不生成
+const chartMaxBars = 50000
+
+// ChartType 由时间K线派生的K线类型，用于 /klines 的 type 参数和规则的 chart 字段
+type ChartType struct {
+	Type string  `json:"type"`           // heikin_ashi/renko/range
+	Size float64 `json:"size,omitempty"` // renko 砖块或 range 每根K线的价格幅度
+	ATR  int     `json:"atr,omitempty"`  // 未设置 size 时以最近一个时段开始前 atr 根源K线的平均真实波幅作为幅度，默认 14
+}
+
+// normalize 填充默认值并校验参数
+func (c *ChartType) normalize() error {
+	switch c.Type {
+	case "heikin_ashi":
+		if c.Size != 0 || c.ATR != 0 {
+			return fmt.Errorf("%s does not take size or atr", c.Type)
+		}
+	case "renko", "range":
+		if c.Size < 0 || c.ATR < 0 || (c.Size > 0 && c.ATR > 0) {
+			return fmt.Errorf("%s needs either a positive size or an atr period", c.Type)
+		}
+		if c.Size == 0 && c.ATR == 0 {
+			c.ATR = 14
+		}
+	default:
+		return fmt.Errorf("unsupported chart type %q", c.Type)
+	}
+	return nil
+}
+
+// chartTypeFromQuery 解析 type、size、atr 参数，type 为空或 candle 时返回 nil
+func chartTypeFromQuery(v url.Values) (*ChartType, error) {
+	t := v.Get("type")
+	if t == "" || t == "candle" {
+		return nil, nil
+	}
+	c := &ChartType{Type: t}
+	var err error
+	if s := v.Get("size"); s != "" {
+		if c.Size, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("invalid size %q", s)
+		}
+	}
+	if s := v.Get("atr"); s != "" {
+		if c.ATR, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("invalid atr %q", s)
+		}
+	}
+	return c, c.normalize()
+}
+
+// key 返回缓存键中的类型部分，nil 为普通K线
+func (c *ChartType) key() string {
+	if c == nil {
+		return "candle"
+	}
+	return fmt.Sprintf("%s:%g:%d", c.Type, c.Size, c.ATR)
+}
+
+// sourceBars 返回生成 limit 根K线需要加载的源K线数量
+func (c *ChartType) sourceBars(limit int) int {
+	switch {
+	case c == nil:
+		return limit
+	case c.Type == "heikin_ashi":
+		// 平均K线的开盘价依赖之前所有K线，多取一段使其收敛
+		return limit + 100
+	}
+	return max(limit, chartSourceBars)
+}
+
+// apply 由按时间升序的源K线生成派生K线，nil 时原样返回
+// 砖形图和等幅K线只包含已完成的K线，开盘时间为完成时所在源K线的开盘时间，同一根源K线可能完成多根
+func (c *ChartType) apply(klines []Kline) []Kline {
+	if c == nil || len(klines) == 0 {
+		return klines
+	}
+	if c.Type == "heikin_ashi" {
+		return heikinAshi(klines)
+	}
+	size := c.size(klines)
+	if size <= 0 {
+		return nil
+	}
+	// 每根派生K线至少需要 size 的价格路径，按源K线的振幅和相邻K线间的跳空估算数量上限
+	var travel float64
+	for i, k := range klines {
+		travel += 2 * (k.High - k.Low)
+		if i > 0 {
+			travel += math.Abs(k.Open - klines[i-1].Close)
+		}
+		if travel/size > chartMaxBars {
+			return nil
+		}
+	}
+	if c.Type == "renko" {
+		return renkoBars(klines, size)
+	}
+	return rangeBars(klines, size)
+}
+
+// applyHistory 回放整段历史时使用：按 ATR 确定幅度时逐个时段生成派生K线，
+// 每个时段只使用该时段结束前最近 chartSourceBars 根源K线，幅度与当时实时求值一致，不使用之后的数据
+func (c *ChartType) applyHistory(klines []Kline) []Kline {
+	if c == nil || c.Type == "heikin_ashi" || c.Size > 0 || len(klines) < 2 {
+		return c.apply(klines)
+	}
+	session := c.session(klines)
+	var out []Kline
+	for start := 0; start < len(klines); {
+		from := session(klines[start].OpenTime)
+		end := start + 1
+		for end < len(klines) && session(klines[end].OpenTime) == from {
+			end++
+		}
+		for _, k := range c.apply(klines[max(0, end-chartSourceBars):end]) {
+			if k.OpenTime >= klines[start].OpenTime {
+				out = append(out, k)
+			}
+		}
+		start = end
+	}
+	return out
+}
+
+// session 返回源K线所属时段的起始时间函数，日内周期为 UTC 日，日线及以上为周
+func (c *ChartType) session(klines []Kline) func(int64) int64 {
+	if klines[1].OpenTime-klines[0].OpenTime >= intervalMillis("1d") {
+		return vwapSessions["week"]
+	}
+	return vwapSessions["day"]
+}
+
+// size 返回砖块或K线幅度，ATR 数据不足时返回 0
+// 按 ATR 时取最近一个时段（日内周期为 UTC 日，日线为周）开始前 atr 根K线的平均真实波幅，
+// 同一时段内不随加载的K线窗口和新收盘的K线变化
+func (c *ChartType) size(klines []Kline) float64 {
+	if c.Size > 0 {
+		return c.Size
+	}
+	if len(klines) < 2 {
+		return 0
+	}
+	start := c.session(klines)(klines[len(klines)-1].OpenTime)
+	end, _ := slices.BinarySearchFunc(klines, start, func(k Kline, t int64) int { return cmp.Compare(k.OpenTime, t) })
+	if end <= c.ATR {
+		return 0
+	}
+	var sum float64
+	for i := end - c.ATR; i < end; i++ {
+		prev := klines[i-1].Close
+		sum += max(klines[i].High, prev) - min(klines[i].Low, prev)
+	}
+	return sum / float64(c.ATR)
+}
+
+// heikinAshi 平均K线：收盘价为 OHLC 均值，开盘价为前一根平均K线开盘价与收盘价的均值
+func heikinAshi(klines []Kline) []Kline {
+	out := make([]Kline, len(klines))
+	for i, k := range klines {
+		ha := k
+		ha.Close = (k.Open + k.High + k.Low + k.Close) / 4
+		if i == 0 {
+			ha.Open = (k.Open + k.Close) / 2
+		} else {
+			ha.Open = (out[i-1].Open + out[i-1].Close) / 2
+		}
+		ha.High = max(k.High, ha.Open, ha.Close)
+		ha.Low = min(k.Low, ha.Open, ha.Close)
+		out[i] = ha
+	}
+	return out
+}
+
+// derivedBar 以源K线的时间生成一根派生K线
+func derivedBar(src Kline, open, high, low, close, volume float64) Kline {
+	return Kline{Symbol: src.Symbol, OpenTime: src.OpenTime, CloseTime: src.CloseTime, IsClosed: src.IsClosed, Open: open, High: high, Low: low, Close: close, Volume: volume}
+}
+
+// splitVolume 把累计的成交量平均分给同一根源K线内完成的派生K线
+func splitVolume(bars []Kline, volume float64) {
+	for i := range bars {
+		bars[i].Volume = volume / float64(len(bars))
+	}
+}
+
+// renkoBars 按收盘价生成砖形图，同向超过一个砖块时生成新砖，反向需要超过两个砖块
+// 砖块边界位于 size 的整数倍上，与加载的K线窗口起点无关，窗口不同时在第一次创出新高或新低后结果一致
+func renkoBars(klines []Kline, size float64) []Kline {
+	level := func(n int64) float64 { return float64(n) * size }
+	var out []Kline
+	top := int64(math.Floor(klines[0].Close / size))
+	bottom := top
+	volume := klines[0].Volume
+	for _, k := range klines[1:] {
+		volume += k.Volume
+		start := len(out)
+		for k.Close >= level(top+1) {
+			out = append(out, derivedBar(k, level(top), level(top+1), level(top), level(top+1), 0))
+			top, bottom = top+1, top
+		}
+		for k.Close <= level(bottom-1) {
+			out = append(out, derivedBar(k, level(bottom), level(bottom), level(bottom-1), level(bottom-1), 0))
+			top, bottom = bottom, bottom-1
+		}
+		if len(out) > start {
+			splitVolume(out[start:], volume)
+			volume = 0
+		}
+	}
+	return out
+}
+
+// rangeBars 生成等幅K线：开盘价位于 size 的整数倍上，价格距开盘价达到 size 时在相邻的整数倍上收盘，
+// 下一根从收盘价开盘；价格第一次触及整数倍之前不生成K线，使结果与加载的K线窗口起点无关
+// 源K线内的价格路径假设为 开-低-高-收（阳线）或 开-高-低-收（阴线）
+func rangeBars(klines []Kline, size float64) []Kline {
+	level := func(n int64) float64 { return float64(n) * size }
+	var out []Kline
+	price := klines[0].Open
+	n := int64(math.Floor(price / size)) // 开盘价所在的整数倍，触及之前为价格下方的整数倍
+	synced := price == level(n)
+	high, low := price, price
+	var volume float64
+	for _, k := range klines {
+		volume += k.Volume
+		start := len(out)
+		path := []float64{k.Open, k.High, k.Low, k.Close}
+		if k.Close >= k.Open {
+			path[1], path[2] = k.Low, k.High
+		}
+		for _, to := range path {
+			for to != price {
+				target := n + 1
+				if to < price {
+					if target = n - 1; !synced {
+						target = n
+					}
+				}
+				line := level(target)
+				if (to > price && to < line) || (to < price && to > line) {
+					high, low, price = max(high, to), min(low, to), to
+					continue
+				}
+				if synced {
+					out = append(out, derivedBar(k, level(n), max(high, line), min(low, line), line, 0))
+				}
+				n, synced, price, high, low = target, true, line, line, line
+			}
+		}
+		if len(out) > start {
+			splitVolume(out[start:], volume)
+			volume = 0
+		} else if !synced {
+			volume = 0
+		}
+	}
+	return out
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestDerivedBars(t *testing.T) {
+	ha := heikinAshi([]Kline{{Open: 10, High: 12, Low: 9, Close: 11}, {Open: 11, High: 13, Low: 10, Close: 12}})
+	if ha[0].Open != 10.5 || ha[0].Close != 10.5 || ha[1].Open != 10.5 || ha[1].Close != 11.5 || ha[1].High != 13 || ha[1].Low != 10 {
+		t.Fatalf("unexpected heikin ashi: %+v", ha)
+	}
+
+	var klines []Kline
+	for i, c := range []float64{10, 12.5, 11, 8.9, 9.5} {
+		klines = append(klines, Kline{OpenTime: int64(i), Open: c, High: c, Low: c, Close: c, Volume: 1})
+	}
+	renko := renkoBars(klines, 1)
+	want := [][3]float64{{1, 10, 11}, {1, 11, 12}, {3, 11, 10}, {3, 10, 9}} // 完成时间、开盘、收盘
+	if len(renko) != len(want) {
+		t.Fatalf("unexpected bricks: %+v", renko)
+	}
+	for i, w := range want {
+		b := renko[i]
+		if float64(b.OpenTime) != w[0] || b.Open != w[1] || b.Close != w[2] || b.Volume != 1 {
+			t.Fatalf("brick %d = %+v, want %v", i, b, w)
+		}
+	}
+
+	// 阳线的价格路径为 开-低-高-收：10 -> 9 -> 13 -> 12，在 12 收盘后没有再触及 10 或 14
+	bars := rangeBars([]Kline{{OpenTime: 7, Open: 10, High: 13, Low: 9, Close: 12, Volume: 1}, {OpenTime: 8, Open: 12, High: 12.5, Low: 9.5, Close: 9.6, Volume: 2}}, 2)
+	want2 := []Kline{{OpenTime: 7, Open: 10, High: 12, Low: 9, Close: 12, Volume: 1}, {OpenTime: 8, Open: 12, High: 13, Low: 10, Close: 10, Volume: 2}}
+	if len(bars) != len(want2) || bars[0] != want2[0] || bars[1] != want2[1] {
+		t.Fatalf("unexpected range bars: %+v", bars)
+	}
+	// 开盘价不在整数倍上时，第一次触及之前不生成K线
+	if bars := rangeBars([]Kline{{Open: 11, High: 11.5, Low: 10.5, Close: 11, Volume: 1}}, 2); len(bars) != 0 {
+		t.Fatalf("expected no bars before touching the grid: %+v", bars)
+	}
+
+	// 按 ATR 确定砖块大小
+	source := fixtureKlines(300, 5)
+	chart := &ChartType{Type: "renko"}
+	if err := chart.normalize(); err != nil || chart.ATR != 14 {
+		t.Fatalf("normalize: %v %+v", err, chart)
+	}
+	size := chart.size(source)
+	bricks := chart.apply(source)
+	if size <= 0 || len(bricks) == 0 {
+		t.Fatalf("expected ATR bricks, size %v", size)
+	}
+	for i, b := range bricks {
+		if math.Abs(math.Abs(b.Close-b.Open)-size) > 1e-9 || (i > 0 && b.OpenTime < bricks[i-1].OpenTime) {
+			t.Fatalf("invalid brick %d: %+v", i, b)
+		}
+	}
+	// 幅度只取决于最近一个时段之前的K线，滑动窗口不改变幅度和已完成的K线
+	if window := chart.size(source[100:]); window != size {
+		t.Fatalf("atr size depends on the window: %v != %v", window, size)
+	}
+	for _, c := range []*ChartType{chart, {Type: "range", Size: 0.4}} {
+		full, tail := c.apply(source), c.apply(source[100:])
+		if !derivedTailMatches(full, tail) {
+			t.Fatalf("%s bars differ between windows: %d vs %d", c.Type, len(full), len(tail))
+		}
+	}
+	if tiny := (&ChartType{Type: "range", Size: 1e-9}).apply(source); tiny != nil {
+		t.Fatal("too small a size should not generate bars")
+	}
+	// 振幅为 0 的K线之间的跳空同样计入数量上限
+	var gaps []Kline
+	for i := range 100 {
+		c := float64(1 + 99*(i%2))
+		gaps = append(gaps, Kline{OpenTime: int64(i), Open: c, High: c, Low: c, Close: c})
+	}
+	for _, c := range []*ChartType{{Type: "renko", Size: 1e-3}, {Type: "range", Size: 1e-3}} {
+		if bars := c.apply(gaps); bars != nil {
+			t.Fatalf("%s: gaps between flat bars should hit the cap, got %d bars", c.Type, len(bars))
+		}
+	}
+
+	for _, bad := range []url.Values{{"type": {"foo"}}, {"type": {"heikin_ashi"}, "size": {"1"}}, {"type": {"renko"}, "size": {"-1"}}, {"type": {"range"}, "size": {"1"}, "atr": {"14"}}, {"type": {"renko"}, "atr": {"x"}}} {
+		if _, err := chartTypeFromQuery(bad); err == nil {
+			t.Errorf("%v: expected error", bad)
+		}
+	}
+}
+
+// derivedTailMatches 判断从较短窗口生成的派生K线在与完整历史对齐后是否一致，
+// 对齐的第一根只比较价格和时间，成交量取决于窗口起点
+func derivedTailMatches(full, tail []Kline) bool {
+	for i, k := range tail {
+		for j := range full {
+			a, b := full[j], k
+			a.Volume, b.Volume = 0, 0
+			if a != b {
+				continue
+			}
+			// 最多允许窗口开头几根在收敛前不同
+			return i < 5 && len(full)-j == len(tail)-i && slices.Equal(full[j+1:], tail[i+1:])
+		}
+	}
+	return false
+}
+
+func TestDerivedBarRules(t *testing.T) {
+	klines := fixtureKlines(600, 9)
+	rule := SignalRule{Name: "renko_up", Interval: "15m", Chart: &ChartType{Type: "renko", Size: 0.5}, When: Condition{All: []Condition{
+		{Compare: &CompareCond{Left: priceOperand("close"), Op: ">", Right: priceOperand("open")}},
+		{Compare: &CompareCond{Left: priceOperand("close").shift(1), Op: "<", Right: priceOperand("open").shift(1)}},
+	}}}
+	if err := rule.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	bricks := rule.Chart.apply(klines)
+	last := len(bricks) - 1
+	reversal := bricks[last].Close > bricks[last].Open && bricks[last-1].Close < bricks[last-1].Open
+	if rule.Match(bricks, nil) != reversal {
+		t.Fatal("rule should be evaluated on bricks")
+	}
+
+	// 回测在砖块上求值，按源K线收盘价开仓
+	cfg := defaultBacktestConfig
+	trades := simulateRule(rule, klines, nil, cfg)
+	if len(trades) == 0 {
+		t.Fatal("expected trades on renko reversals")
+	}
+	for _, trade := range trades {
+		found := false
+		for _, k := range klines {
+			if k.CloseTime == trade.EntryTime {
+				found = math.Abs(trade.EntryPrice-k.Close*(1+cfg.Slippage)) < 1e-9
+			}
+		}
+		if !found {
+			t.Fatalf("trade should fill at a source close: %+v", trade)
+		}
+	}
+	if err := (&SignalRule{Name: "x", Chart: &ChartType{Type: "kagi"}, When: rule.When}).normalize(); err == nil {
+		t.Fatal("expected error for unsupported chart type")
+	}
+}
+
+// TestDerivedBarsHistoryNoLookAhead 回测按 ATR 确定幅度时，截断历史不改变之前的派生K线和交易
+func TestDerivedBarsHistoryNoLookAhead(t *testing.T) {
+	source := fixtureKlines(1500, 12)
+	chart := &ChartType{Type: "renko"}
+	if err := chart.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	full := chart.applyHistory(source)
+	if len(full) == 0 {
+		t.Fatal("expected ATR bricks")
+	}
+	for _, cut := range []int{400, 777, 1100} {
+		prefix := chart.applyHistory(source[:cut])
+		if len(prefix) > len(full) || !slices.Equal(prefix, full[:len(prefix)]) {
+			t.Fatalf("cut %d: bricks depend on later bars", cut)
+		}
+	}
+	// 每个时段的砖块大小为该时段开始前的 ATR
+	sizes := map[float64]bool{}
+	for _, b := range full {
+		sizes[math.Round(math.Abs(b.Close-b.Open)*1e9)/1e9] = true
+	}
+	if len(sizes) < 2 {
+		t.Fatalf("expected brick size to change between sessions: %v", sizes)
+	}
+
+	rule := SignalRule{Name: "renko_up", Interval: "15m", Chart: chart, When: Condition{Compare: &CompareCond{Left: priceOperand("close"), Op: ">", Right: priceOperand("open")}}}
+	if err := rule.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := BacktestConfig{TakeProfit: 0.01, StopLoss: 0.01}
+	all := simulateRule(rule, source, nil, cfg)
+	cut := 1000
+	var want []BacktestTrade
+	for _, trade := range all {
+		if trade.ExitTime < source[cut-1].CloseTime {
+			want = append(want, trade)
+		}
+	}
+	got := simulateRule(rule, source[:cut], nil, cfg)
+	if len(want) == 0 || len(got) < len(want) || !slices.Equal(got[:len(want)], want) {
+		t.Fatalf("trades depend on later bars: %d vs %d", len(got), len(want))
+	}
+}
+
+func TestKlinesChartType(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	klines := fixtureKlines(300, 6)
+	if err := ensureKlineTable(db, "TESTUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	db.Table(Kline{Symbol: "TESTUSDT"}.TableName()).CreateInBatches(klines, 100)
+
+	handler := handleKlineQuery(db)
+	get := func(query string) (*httptest.ResponseRecorder, [][]any) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/klines?symbol=TESTUSDT&interval=15m&limit=20"+query, nil))
+		var rows [][]any
+		json.Unmarshal(rec.Body.Bytes(), &rows)
+		return rec, rows
+	}
+
+	_, candles := get("")
+	rec, rows := get("&type=heikin_ashi")
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "MISS" || len(rows) != 20 || len(rows[0]) != 12 {
+		t.Fatalf("unexpected heikin ashi response: %d %s", rec.Code, rec.Body)
+	}
+	ha := heikinAshi(klines)
+	if rows[19][0] != candles[19][0] || rows[19][4] != fmt.Sprintf("%.8f", ha[len(ha)-1].Close) {
+		t.Fatalf("last heikin ashi bar %v, want close %v", rows[19], ha[len(ha)-1].Close)
+	}
+	if rec, rows := get("&type=range&size=0.3"); rec.Code != http.StatusOK || len(rows) == 0 || len(rows) > 20 {
+		t.Fatalf("unexpected range response: %d %d rows", rec.Code, len(rows))
+	}
+	if rec, _ := get("&type=renko&size=-1"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+}
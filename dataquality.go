@@ -0,0 +1,570 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ================= 数据质量 =================
+
+// 数据问题的类型
+const (
+	issueZeroPrice   = "zero_price"   // 价格为 0 或负数，通常是解析失败
+	issueInvalidOHLC = "invalid_ohlc" // 最高价低于开收盘价、最低价高于开收盘价或成交量为负
+	issueDuplicate   = "duplicate"    // 同一开盘时间有多行
+	issueTimestamp   = "timestamp"    // 开盘时间不在15m边界，或收盘时间与开盘时间不匹配
+	issueGap         = "gap"          // 两根K线之间缺少数据
+	issueMismatch    = "mismatch"     // 与币安不一致，多为收盘前写入后没有再更新
+	issueUnclosed    = "unclosed"     // 收盘超过 staleAfter 仍未确认收盘
+	issueFlatline    = "flatline"     // 最近连续无成交或价格不变，可能已下架
+	issueStale       = "stale"        // 长时间没有新K线
+)
+
+// localIssueKinds 只靠本地数据就能检查的问题类型
+var localIssueKinds = []string{issueZeroPrice, issueInvalidOHLC, issueDuplicate, issueTimestamp, issueUnclosed, issueGap, issueFlatline, issueStale}
+
+// repairableIssue 可以通过重新拉取该区间修复的问题，flatline/stale 只能报告
+func repairableIssue(kind string) bool {
+	return kind != issueFlatline && kind != issueStale
+}
+
+const (
+	// flatlineBars 最近连续多少根已收盘K线无成交或最高价等于最低价时视为停滞
+	flatlineBars = 8
+	// staleAfter 最新K线早于该时长时视为数据停止更新
+	staleAfter = time.Hour
+)
+
+// DataIssue 一段K线数据的问题，同一代币、类型和起始K线只有一条记录
+type DataIssue struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	Symbol     string `gorm:"uniqueIndex:idx_data_issue" json:"symbol"`
+	Kind       string `gorm:"uniqueIndex:idx_data_issue" json:"kind"`
+	OpenTime   int64  `gorm:"uniqueIndex:idx_data_issue" json:"open_time"` // 受影响的第一根K线开盘时间
+	EndTime    int64  `json:"end_time"`                                    // 受影响的最后一根K线开盘时间
+	Count      int    `json:"count"`                                       // 受影响的K线数量
+	Detail     string `json:"detail"`
+	DetectedAt int64  `json:"detected_at"`
+	LastSeen   int64  `json:"last_seen"`                // 最近一次检查仍存在的时间
+	ResolvedAt int64  `gorm:"index" json:"resolved_at"` // 0 表示仍存在
+	Repaired   bool   `json:"repaired"`                 // 由重新拉取修复
+	Ignored    bool   `json:"ignored"`                  // 币安同样没有数据，不再报告
+}
+
+// migrateDataQualityTables 创建数据问题表
+func migrateDataQualityTables(db *gorm.DB) error {
+	return db.AutoMigrate(&DataIssue{})
+}
+
+// dataQualityConfig 数据质量检查配置
+type dataQualityConfig struct {
+	Every      time.Duration // 检查周期，DATA_CHECK_EVERY，默认 1h
+	Sample     int           // 每次与币安核对的代币数量，DATA_CHECK_SAMPLE，默认 5，轮流核对
+	Bars       int           // 核对最近多少根已收盘K线，DATA_CHECK_BARS，默认 96
+	AutoRepair bool          // DATA_AUTO_REPAIR=on 时检查后自动重新拉取有问题的区间
+}
+
+// loadDataQualityConfig 从环境变量读取数据质量检查配置
+func loadDataQualityConfig() dataQualityConfig {
+	cfg := dataQualityConfig{Every: time.Hour, Sample: 5, Bars: 96, AutoRepair: os.Getenv("DATA_AUTO_REPAIR") == "on"}
+	if d, err := time.ParseDuration(os.Getenv("DATA_CHECK_EVERY")); err == nil && d > 0 {
+		cfg.Every = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("DATA_CHECK_SAMPLE")); err == nil && n >= 0 {
+		cfg.Sample = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("DATA_CHECK_BARS")); err == nil && n > 0 && n < 1500 {
+		cfg.Bars = n
+	}
+	return cfg
+}
+
+// issueRanges 把有问题的开盘时间合并为连续区间，times 需升序且不重复
+func issueRanges(symbol, kind string, times []int64, detail string) []DataIssue {
+	ms := intervalMillis("15m")
+	var out []DataIssue
+	for _, t := range times {
+		if n := len(out); n > 0 && t-out[n-1].EndTime <= ms {
+			out[n-1].EndTime = t
+			out[n-1].Count++
+			continue
+		}
+		out = append(out, DataIssue{Symbol: symbol, Kind: kind, OpenTime: t, EndTime: t, Count: 1, Detail: detail})
+	}
+	return out
+}
+
+// scanKlines 检查按开盘时间升序的全部15m K线，返回本地可发现的问题
+func scanKlines(symbol string, rows []Kline, now int64) []DataIssue {
+	ms := intervalMillis("15m")
+	bad := map[string][]int64{}
+	mark := func(kind string, t int64) {
+		if times := bad[kind]; len(times) == 0 || times[len(times)-1] != t {
+			bad[kind] = append(times, t)
+		}
+	}
+	var issues []DataIssue
+	for i, k := range rows {
+		switch {
+		case k.Open <= 0 || k.High <= 0 || k.Low <= 0 || k.Close <= 0:
+			mark(issueZeroPrice, k.OpenTime)
+		case k.High < max(k.Open, k.Close, k.Low) || k.Low > min(k.Open, k.Close, k.High) || k.Volume < 0:
+			mark(issueInvalidOHLC, k.OpenTime)
+		}
+		if k.OpenTime%ms != 0 || k.CloseTime != k.OpenTime+ms-1 {
+			mark(issueTimestamp, k.OpenTime)
+		}
+		if !k.IsClosed && k.CloseTime < now-staleAfter.Milliseconds() {
+			mark(issueUnclosed, k.OpenTime)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := rows[i-1]
+		if k.OpenTime == prev.OpenTime {
+			mark(issueDuplicate, k.OpenTime)
+		} else if missing := (k.OpenTime - prev.OpenTime) / ms; missing > 1 && prev.OpenTime%ms == 0 && k.OpenTime%ms == 0 {
+			issues = append(issues, DataIssue{Symbol: symbol, Kind: issueGap, OpenTime: prev.OpenTime + ms, EndTime: k.OpenTime - ms, Count: int(missing - 1)})
+		}
+	}
+	details := map[string]string{
+		issueZeroPrice:   "价格为 0 或负数",
+		issueInvalidOHLC: "最高/最低价与开收盘价矛盾或成交量为负",
+		issueDuplicate:   "同一开盘时间有多行",
+		issueTimestamp:   "时间戳不在15m边界或收盘时间不匹配",
+		issueUnclosed:    "收盘后未重新拉取确认",
+	}
+	for _, kind := range localIssueKinds {
+		if times := bad[kind]; len(times) > 0 {
+			issues = append(issues, issueRanges(symbol, kind, times, details[kind])...)
+		}
+	}
+	for i := range issues {
+		if issues[i].Kind == issueGap {
+			issues[i].Detail = fmt.Sprintf("缺少 %d 根K线", issues[i].Count)
+		}
+	}
+
+	closed := slices.DeleteFunc(slices.Clone(rows), func(k Kline) bool { return k.OpenTime+ms > now })
+	if n := len(closed); n >= flatlineBars {
+		run := 0
+		for run < n && (closed[n-1-run].Volume == 0 || closed[n-1-run].High == closed[n-1-run].Low) {
+			run++
+		}
+		if run >= flatlineBars {
+			issues = append(issues, DataIssue{Symbol: symbol, Kind: issueFlatline, OpenTime: closed[n-run].OpenTime, EndTime: closed[n-1].OpenTime, Count: run,
+				Detail: fmt.Sprintf("最近 %d 根K线无成交或价格不变", run)})
+		}
+	}
+	if n := len(rows); n > 0 && rows[n-1].OpenTime < now-staleAfter.Milliseconds() {
+		last := rows[n-1].OpenTime
+		issues = append(issues, DataIssue{Symbol: symbol, Kind: issueStale, OpenTime: last, EndTime: last, Count: 1,
+			Detail: fmt.Sprintf("最新K线为 %s", time.UnixMilli(last).UTC().Format(time.RFC3339))})
+	}
+	return issues
+}
+
+// sameValue 比较价格和成交量，允许浮点误差
+func sameValue(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// sameKline 比较两根K线的 OHLCV
+func sameKline(a, b Kline) bool {
+	return sameValue(a.Open, b.Open) && sameValue(a.High, b.High) && sameValue(a.Low, b.Low) && sameValue(a.Close, b.Close) && sameValue(a.Volume, b.Volume)
+}
+
+// compareWithBinance 与币安返回的已收盘K线逐根比较，本地缺失的K线由 gap 检查负责
+func compareWithBinance(symbol string, rows, remote []Kline, now int64) []DataIssue {
+	local := make(map[int64]Kline, len(rows))
+	for _, k := range rows {
+		if _, ok := local[k.OpenTime]; !ok {
+			local[k.OpenTime] = k
+		}
+	}
+	var times []int64
+	for _, r := range remote {
+		k, ok := local[r.OpenTime]
+		if !ok || r.CloseTime >= now {
+			continue
+		}
+		if !sameKline(k, r) {
+			times = append(times, r.OpenTime)
+		}
+	}
+	return issueRanges(symbol, issueMismatch, times, "与币安的K线不一致")
+}
+
+// loadKlineRows 读取代币的全部15m K线，包括重复行
+func loadKlineRows(db *gorm.DB, symbol string) ([]Kline, error) {
+	var rows []Kline
+	err := db.Table(Kline{Symbol: symbol}.TableName()).Order("open_time ASC, id ASC").Find(&rows).Error
+	return rows, err
+}
+
+// recordDataIssues 保存本次检查发现的问题，kinds 中已检查但不再出现的问题标记为已解决，返回新出现的问题
+func recordDataIssues(db *gorm.DB, symbol string, kinds []string, found []DataIssue, now int64) ([]DataIssue, error) {
+	var created []DataIssue
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var existing []DataIssue
+		if err := tx.Where("symbol = ? AND kind IN ?", symbol, kinds).Find(&existing).Error; err != nil {
+			return err
+		}
+		byKey := make(map[string]*DataIssue, len(existing))
+		for i := range existing {
+			e := &existing[i]
+			byKey[fmt.Sprintf("%s:%d", e.Kind, e.OpenTime)] = e
+		}
+		seen := map[uint]bool{}
+		for _, issue := range found {
+			e, ok := byKey[fmt.Sprintf("%s:%d", issue.Kind, issue.OpenTime)]
+			if !ok {
+				issue.DetectedAt, issue.LastSeen = now, now
+				if err := tx.Create(&issue).Error; err != nil {
+					return err
+				}
+				created = append(created, issue)
+				continue
+			}
+			seen[e.ID] = true
+			e.EndTime, e.Count, e.LastSeen = issue.EndTime, issue.Count, now
+			if !e.Ignored {
+				e.Detail = issue.Detail
+				if e.ResolvedAt != 0 {
+					// 修复后再次出现
+					e.ResolvedAt, e.Repaired, e.DetectedAt = 0, false, now
+					created = append(created, *e)
+				}
+			}
+			if err := tx.Save(e).Error; err != nil {
+				return err
+			}
+		}
+		for _, e := range existing {
+			if !seen[e.ID] && e.ResolvedAt == 0 {
+				if err := tx.Model(&DataIssue{}).Where("id = ?", e.ID).Update("resolved_at", now).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return created, err
+}
+
+// fetchKlineRange 分批拉取开盘时间在 [from, to] 内的15m K线
+func fetchKlineRange(symbol string, from, to int64) ([]Kline, error) {
+	ms := intervalMillis("15m")
+	var out []Kline
+	for start := from; start <= to; {
+		batch, err := fetchBinanceKlines(symbol, "15m", start, to, 1000)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, batch...)
+		if len(batch) < 1000 {
+			break
+		}
+		start = batch[len(batch)-1].OpenTime + ms
+	}
+	return out, nil
+}
+
+// replaceKlineRange 用币安的已收盘K线替换 [from, to] 内的本地数据
+func replaceKlineRange(db *gorm.DB, symbol string, from, to int64, klines []Kline, now int64) error {
+	table := Kline{Symbol: symbol}.TableName()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table(table).Where("open_time >= ? AND open_time <= ?", from, to).Delete(&Kline{}).Error; err != nil {
+			return err
+		}
+		for _, k := range klines {
+			if k.CloseTime >= now {
+				continue
+			}
+			k.ID, k.Symbol, k.IsClosed = 0, symbol, true
+			if err := tx.Table(table).Create(&k).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// repairDataIssues 重新拉取代币未解决问题所在的区间，返回修复的问题数量
+// 币安在缺口内同样没有数据时标记为忽略，不再报告
+func repairDataIssues(db *gorm.DB, symbol string, now int64) (int, error) {
+	var issues []DataIssue
+	if err := db.Where("symbol = ? AND resolved_at = 0 AND ignored = ?", symbol, false).Order("open_time").Find(&issues).Error; err != nil {
+		return 0, err
+	}
+	ms := intervalMillis("15m")
+	repaired := 0
+	for _, issue := range issues {
+		if !repairableIssue(issue.Kind) {
+			continue
+		}
+		from, to := issue.OpenTime/ms*ms, issue.EndTime
+		klines, err := fetchKlineRange(symbol, from, to)
+		if err != nil {
+			return repaired, err
+		}
+		if len(klines) == 0 && issue.Kind == issueGap {
+			issue.Ignored, issue.Detail = true, issue.Detail+"，币安同样没有数据"
+			if err := db.Save(&issue).Error; err != nil {
+				return repaired, err
+			}
+			continue
+		}
+		if err := replaceKlineRange(db, symbol, from, to, klines, now); err != nil {
+			return repaired, err
+		}
+		issue.ResolvedAt, issue.Repaired = now, true
+		if err := db.Save(&issue).Error; err != nil {
+			return repaired, err
+		}
+		repaired++
+	}
+	if repaired > 0 {
+		bumpDataVersion(symbol)
+		log.Printf("%s 重新拉取修复了 %d 个数据问题", symbol, repaired)
+	}
+	return repaired, nil
+}
+
+// dataQualityStatus 最近一次检查的概况
+type dataQualityStatus struct {
+	LastRun  time.Time `json:"last_run"`
+	Duration string    `json:"duration"`
+	Scanned  int       `json:"scanned"`
+	Sampled  []string  `json:"sampled"` // 与币安核对的代币
+	New      int       `json:"new"`     // 新发现的问题数量
+	Repaired int       `json:"repaired"`
+}
+
+// dataQualityMonitor 定期检查所有代币的K线表
+type dataQualityMonitor struct {
+	db     *gorm.DB
+	cfg    dataQualityConfig
+	mu     sync.Mutex
+	cursor int // 下一次核对从第几个代币开始
+	status dataQualityStatus
+}
+
+// sample 轮流选出本次与币安核对的代币
+func (m *dataQualityMonitor) sample(symbols []string) []string {
+	n := min(m.cfg.Sample, len(symbols))
+	out := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		out = append(out, symbols[(m.cursor+i)%len(symbols)])
+	}
+	if len(symbols) > 0 {
+		m.cursor = (m.cursor + n) % len(symbols)
+	}
+	return out
+}
+
+// run 检查一次所有监控的代币，按配置自动修复，新问题汇总后发送系统通知
+func (m *dataQualityMonitor) run(now time.Time) dataQualityStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	symbols := trackedSymbols()
+	status := dataQualityStatus{LastRun: now, Scanned: len(symbols), Sampled: m.sample(symbols)}
+	ms := now.UnixMilli()
+	var lines []string
+	for _, symbol := range symbols {
+		rows, err := loadKlineRows(m.db, symbol)
+		if err != nil {
+			log.Printf("读取 %s 的K线失败: %v", symbol, err)
+			continue
+		}
+		issues := scanKlines(symbol, rows, ms)
+		kinds := localIssueKinds
+		if slices.Contains(status.Sampled, symbol) {
+			if remote, err := fetchBinanceKlines(symbol, "15m", 0, 0, m.cfg.Bars+1); err != nil {
+				log.Printf("核对 %s 的K线失败: %v", symbol, err)
+			} else {
+				issues = append(issues, compareWithBinance(symbol, rows, remote, ms)...)
+				kinds = append(slices.Clone(kinds), issueMismatch)
+			}
+		}
+		created, err := recordDataIssues(m.db, symbol, kinds, issues, ms)
+		if err != nil {
+			log.Printf("保存 %s 的数据问题失败: %v", symbol, err)
+			continue
+		}
+		status.New += len(created)
+		if m.cfg.AutoRepair {
+			n, err := repairDataIssues(m.db, symbol, ms)
+			if err != nil {
+				log.Printf("修复 %s 的数据失败: %v", symbol, err)
+			}
+			status.Repaired += n
+		}
+		var open []string
+		for _, issue := range created {
+			if !m.cfg.AutoRepair || !repairableIssue(issue.Kind) {
+				open = append(open, issue.Kind)
+			}
+		}
+		if len(open) > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %s", symbol, strings.Join(slices.Compact(open), ", ")))
+		}
+	}
+	status.Duration = time.Since(now).Round(time.Millisecond).String()
+	m.status = status
+	if len(lines) > 0 {
+		text := "以下代币的K线数据有新问题，详见 /health/data：\n" + strings.Join(lines, "\n")
+		if err := notifier.Send(Notification{Title: "数据质量", Text: text}); err != nil {
+			log.Printf("发送数据质量通知失败: %v", err)
+		}
+	}
+	return status
+}
+
+// lastStatus 返回最近一次检查的概况
+func (m *dataQualityMonitor) lastStatus() dataQualityStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// start 在后台按周期检查，第一次检查在启动后一个周期执行，避开启动时的补数据
+func (m *dataQualityMonitor) start() {
+	go func() {
+		ticker := time.NewTicker(m.cfg.Every)
+		defer ticker.Stop()
+		for range ticker.C {
+			m.run(time.Now())
+		}
+	}()
+}
+
+// symbolDataHealth 一个代币的数据概况和未解决的问题
+type symbolDataHealth struct {
+	Symbol string         `json:"symbol"`
+	Status string         `json:"status"` // ok/warn/error：有可修复的问题时为 error，只有缺口或停滞时为 warn
+	Bars   int64          `json:"bars"`
+	First  int64          `json:"first"` // 最早和最新K线的开盘时间
+	Last   int64          `json:"last"`
+	Open   map[string]int `json:"open"` // 未解决的问题数量，按类型
+	Issues []DataIssue    `json:"issues"`
+}
+
+// dataHealthReport /health/data 的响应
+type dataHealthReport struct {
+	LastCheck dataQualityStatus  `json:"last_check"`
+	Symbols   []symbolDataHealth `json:"symbols"`
+}
+
+// symbolHealth 汇总代币的K线数量、时间范围和问题，all 为 true 时包含已解决和忽略的问题
+func symbolHealth(db *gorm.DB, symbol string, all bool) (symbolDataHealth, error) {
+	h := symbolDataHealth{Symbol: symbol, Status: "ok", Open: map[string]int{}, Issues: []DataIssue{}}
+	var stats struct {
+		Bars  int64
+		First int64
+		Last  int64
+	}
+	table := Kline{Symbol: symbol}.TableName()
+	if err := db.Table(table).Select("COUNT(*) AS bars, COALESCE(MIN(open_time), 0) AS first, COALESCE(MAX(open_time), 0) AS last").Scan(&stats).Error; err != nil {
+		return h, err
+	}
+	h.Bars, h.First, h.Last = stats.Bars, stats.First, stats.Last
+	query := db.Where("symbol = ?", symbol)
+	if !all {
+		query = query.Where("resolved_at = 0 AND ignored = ?", false)
+	}
+	if err := query.Order("open_time DESC").Limit(200).Find(&h.Issues).Error; err != nil {
+		return h, err
+	}
+	for _, issue := range h.Issues {
+		if issue.ResolvedAt != 0 || issue.Ignored {
+			continue
+		}
+		h.Open[issue.Kind]++
+		if repairableIssue(issue.Kind) && issue.Kind != issueGap {
+			h.Status = "error"
+		} else if h.Status == "ok" {
+			h.Status = "warn"
+		}
+	}
+	return h, nil
+}
+
+// handleDataHealth 返回每个代币的数据质量报告
+// 例如 /health/data、/health/data?symbol=BTCUSDT&all=1
+func handleDataHealth(db *gorm.DB, m *dataQualityMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		symbols := trackedSymbols()
+		if s := r.URL.Query().Get("symbol"); s != "" {
+			if !isTrackedSymbol(s) {
+				http.Error(w, fmt.Sprintf("unknown symbol: %s", s), http.StatusBadRequest)
+				return
+			}
+			symbols = []string{s}
+		}
+		report := dataHealthReport{LastCheck: m.lastStatus(), Symbols: []symbolDataHealth{}}
+		for _, symbol := range symbols {
+			h, err := symbolHealth(db, symbol, r.URL.Query().Get("all") == "1")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			report.Symbols = append(report.Symbols, h)
+		}
+		writeJSON(w, r, report)
+	}
+}
+
+// handleDataRepair 立即检查并重新拉取有问题的区间，symbol 为空时处理所有代币
+// 例如 POST /health/data/repair?symbol=BTCUSDT
+func handleDataRepair(db *gorm.DB, m *dataQualityMonitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		symbols := trackedSymbols()
+		if s := r.URL.Query().Get("symbol"); s != "" {
+			if !isTrackedSymbol(s) {
+				http.Error(w, fmt.Sprintf("unknown symbol: %s", s), http.StatusBadRequest)
+				return
+			}
+			symbols = []string{s}
+		}
+		now := time.Now().UnixMilli()
+		result := map[string]int{}
+		for _, symbol := range symbols {
+			rows, err := loadKlineRows(db, symbol)
+			if err == nil {
+				_, err = recordDataIssues(db, symbol, localIssueKinds, scanKlines(symbol, rows, now), now)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			n, err := repairDataIssues(db, symbol, now)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s: %v", symbol, err), http.StatusBadGateway)
+				return
+			}
+			result[symbol] = n
+		}
+		writeJSON(w, r, map[string]any{"repaired": result})
+	}
+}
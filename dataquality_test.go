@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// alignedKlines 生成从 start 开始、对齐到15m边界的K线
+func alignedKlines(symbol string, start int64, n int) []Kline {
+	ms := intervalMillis("15m")
+	klines := make([]Kline, n)
+	for i := range klines {
+		open := start + int64(i)*ms
+		p := 100 + float64(i%7)
+		klines[i] = Kline{Symbol: symbol, OpenTime: open, CloseTime: open + ms - 1, Open: p, High: p + 2, Low: p - 1, Close: p + 1, Volume: 10 + float64(i), IsClosed: true}
+	}
+	return klines
+}
+
+func TestScanKlines(t *testing.T) {
+	ms := intervalMillis("15m")
+	rows := alignedKlines("TESTUSDT", 100*ms, 20)
+	now := rows[19].OpenTime + ms
+	if issues := scanKlines("TESTUSDT", rows, now); len(issues) != 0 {
+		t.Fatalf("clean data reported issues: %+v", issues)
+	}
+
+	rows[2].Close = 0
+	rows[3].Open = 0
+	rows[5].High = rows[5].Low - 1
+	rows[7].CloseTime++
+	rows = append(rows[:9], rows[12:]...)                                // 缺少 3 根
+	rows = append(rows[:14], append([]Kline{rows[13]}, rows[14:]...)...) // 重复一行
+	got := map[string]DataIssue{}
+	for _, issue := range scanKlines("TESTUSDT", rows, now) {
+		if _, ok := got[issue.Kind]; ok {
+			t.Fatalf("expected a single %s range", issue.Kind)
+		}
+		got[issue.Kind] = issue
+	}
+	want := map[string][2]int64{
+		issueZeroPrice:   {102 * ms, 2},
+		issueInvalidOHLC: {105 * ms, 1},
+		issueTimestamp:   {107 * ms, 1},
+		issueGap:         {109 * ms, 3},
+		issueDuplicate:   {116 * ms, 1},
+	}
+	for kind, w := range want {
+		if issue := got[kind]; issue.OpenTime != w[0] || int64(issue.Count) != w[1] {
+			t.Errorf("%s: got %+v, want start %d count %d", kind, issue, w[0], w[1])
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected issues: %+v", got)
+	}
+
+	// 下架后成交量为 0，且长时间没有新数据
+	flat := alignedKlines("TESTUSDT", 100*ms, 20)
+	for i := 10; i < 20; i++ {
+		flat[i].Volume = 0
+	}
+	got = map[string]DataIssue{}
+	for _, issue := range scanKlines("TESTUSDT", flat, now+2*staleAfter.Milliseconds()) {
+		got[issue.Kind] = issue
+	}
+	if got[issueFlatline].Count != 10 || got[issueFlatline].OpenTime != 110*ms || got[issueStale].OpenTime != 119*ms || len(got) != 2 {
+		t.Fatalf("expected flatline and stale: %+v", got)
+	}
+
+	remote := alignedKlines("TESTUSDT", 100*ms, 20)
+	local := alignedKlines("TESTUSDT", 100*ms, 20)
+	local[4].Close += 0.5
+	local[19].Volume = 1 // 未收盘的K线不比较
+	mismatch := compareWithBinance("TESTUSDT", local, remote, local[19].OpenTime+1)
+	if len(mismatch) != 1 || mismatch[0].OpenTime != 104*ms || mismatch[0].Count != 1 {
+		t.Fatalf("unexpected mismatch: %+v", mismatch)
+	}
+}
+
+func TestDataQualityRepair(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := migrateDataQualityTables(db); err != nil {
+		t.Fatal(err)
+	}
+	oldSymbols := symbols
+	t.Cleanup(func() { symbols = oldSymbols })
+	symbols = []string{"BTCUSDT"}
+
+	ms := intervalMillis("15m")
+	now := time.Now().UnixMilli()
+	start := now/ms*ms - 60*ms
+	truth := alignedKlines("BTCUSDT", start, 61)
+	truth[60].IsClosed = false // 最后一根未收盘
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		from, _ := strconv.ParseInt(q.Get("startTime"), 10, 64)
+		to, _ := strconv.ParseInt(q.Get("endTime"), 10, 64)
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		var out [][]any
+		for _, k := range truth {
+			if k.OpenTime >= from && (to == 0 || k.OpenTime <= to) {
+				out = append(out, []any{k.OpenTime, fmt.Sprint(k.Open), fmt.Sprint(k.High), fmt.Sprint(k.Low), fmt.Sprint(k.Close), fmt.Sprint(k.Volume), k.CloseTime})
+			}
+		}
+		if len(out) > limit {
+			out = out[len(out)-limit:]
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	defer srv.Close()
+	old := binanceFuturesAPI
+	binanceFuturesAPI = srv.URL
+	t.Cleanup(func() { binanceFuturesAPI = old })
+
+	local := append([]Kline(nil), truth...)
+	local[10].Close = 0  // 同时与币安不一致
+	local[40].Close += 1 // 收盘前写入后未更新
+	local = append(local[:20], local[23:]...)
+	if err := ensureKlineTable(db, "BTCUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	db.Table(Kline{Symbol: "BTCUSDT"}.TableName()).CreateInBatches(local, 100)
+
+	m := &dataQualityMonitor{db: db, cfg: dataQualityConfig{Every: time.Hour, Sample: 1, Bars: 96}}
+	if status := m.run(time.UnixMilli(now)); status.New != 4 || len(status.Sampled) != 1 || status.Repaired != 0 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	get := func() dataHealthReport {
+		rec := httptest.NewRecorder()
+		handleDataHealth(db, m)(rec, httptest.NewRequest(http.MethodGet, "/health/data?symbol=BTCUSDT", nil))
+		var report dataHealthReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("unexpected report: %d %s", rec.Code, rec.Body)
+		}
+		return report
+	}
+	h := get().Symbols[0]
+	if h.Status != "error" || h.Bars != 58 || h.First != start || h.Open[issueZeroPrice] != 1 || h.Open[issueGap] != 1 || h.Open[issueMismatch] != 2 {
+		t.Fatalf("unexpected health: %+v", h)
+	}
+	if created, _ := recordDataIssues(db, "BTCUSDT", localIssueKinds, scanKlines("BTCUSDT", local, now), now); len(created) != 0 {
+		t.Fatal("known issues should not be reported again")
+	}
+
+	rec := httptest.NewRecorder()
+	handleDataRepair(db, m)(rec, httptest.NewRequest(http.MethodGet, "/health/data/repair", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handleDataRepair(db, m)(rec, httptest.NewRequest(http.MethodPost, "/health/data/repair?symbol=BTCUSDT", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("repair failed: %d %s", rec.Code, rec.Body)
+	}
+	rows, _ := loadKlineRows(db, "BTCUSDT")
+	if len(rows) != 61 {
+		t.Fatalf("expected 61 bars after repair, got %d", len(rows))
+	}
+	for i, k := range rows[:60] {
+		k.ID = 0
+		if k != truth[i] {
+			t.Fatalf("bar %d = %+v, want %+v", i, k, truth[i])
+		}
+	}
+	if h := get().Symbols[0]; h.Status != "ok" || len(h.Issues) != 0 {
+		t.Fatalf("issues should be resolved: %+v", h)
+	}
+	var repaired int64
+	db.Model(&DataIssue{}).Where("repaired = ?", true).Count(&repaired)
+	if repaired != 4 {
+		t.Fatalf("expected 4 repaired issues, got %d", repaired)
+	}
+	if status := m.run(time.UnixMilli(now)); status.New != 0 {
+		t.Fatalf("repaired data should be clean: %+v", status)
+	}
+}
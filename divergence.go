@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ================= 背离检测 =================
+
+// 背离类型
+const (
+	RegularBullish = "regular_bullish" // 价格更低的低点，指标更高的低点
+	HiddenBullish  = "hidden_bullish"  // 价格更高的低点，指标更低的低点
+	RegularBearish = "regular_bearish" // 价格更高的高点，指标更低的高点
+	HiddenBearish  = "hidden_bearish"  // 价格更低的高点，指标更高的高点
+)
+
+// Divergence 一次价格与指标的背离，Prev 为前一个拐点
+type Divergence struct {
+	Kind         string  `json:"kind"`
+	PrevTime     int64   `json:"prev_time"` // 前一个价格拐点的开盘时间
+	Time         int64   `json:"time"`      // 后一个价格拐点的开盘时间
+	ConfirmTime  int64   `json:"confirm_time"`
+	PrevPrice    float64 `json:"prev_price"`
+	Price        float64 `json:"price"`
+	PrevValue    float64 `json:"prev_value"` // 指标拐点的值
+	Value        float64 `json:"value"`
+	prevIndex    int
+	index        int
+	confirmIndex int // 右侧K线走完、拐点得到确认的下标，此前不可见
+}
+
+// divergenceOptions 拐点与配对参数
+type divergenceOptions struct {
+	Left      int    // 拐点左侧K线数
+	Right     int    // 拐点右侧K线数，决定确认延迟
+	MinGap    int    // 两个拐点的最小间隔
+	MaxGap    int    // 两个拐点的最大间隔
+	Tolerance int    // 指标拐点与价格拐点允许相差的K线数
+	Source    string // hl 使用最高/最低价，close 使用收盘价
+}
+
+var defaultDivergenceOptions = divergenceOptions{Left: 3, Right: 3, MinGap: 5, MaxGap: 60, Tolerance: 2, Source: "hl"}
+
+// findPivots 返回局部极值的下标，lows 为 true 时寻找低点
+// 左侧允许相等，右侧必须严格，避免平台区域重复出现拐点
+func findPivots(series []float64, left, right int, lows bool) []int {
+	var pivots []int
+	for i := left; i+right < len(series); i++ {
+		v := series[i]
+		if math.IsNaN(v) {
+			continue
+		}
+		ok := true
+		for j := i - left; j <= i+right && ok; j++ {
+			if j == i {
+				continue
+			}
+			w := series[j]
+			if math.IsNaN(w) {
+				ok = false
+			} else if lows {
+				ok = (j < i && v <= w) || (j > i && v < w)
+			} else {
+				ok = (j < i && v >= w) || (j > i && v > w)
+			}
+		}
+		if ok {
+			pivots = append(pivots, i)
+		}
+	}
+	return pivots
+}
+
+// nearestPivot 返回离 target 最近且在容差内的拐点下标，没有返回 -1
+func nearestPivot(pivots []int, target, tolerance int) int {
+	best := -1
+	for _, p := range pivots {
+		if d := abs(p - target); d <= tolerance && (best < 0 || d < abs(best-target)) {
+			best = p
+		}
+	}
+	return best
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// findDivergences 在价格与指标序列中寻找相邻价格拐点构成的背离
+// low/high 为价格低点和高点序列（收盘价模式下两者相同），indicator 与之对齐
+func findDivergences(low, high, indicator []float64, opts divergenceOptions) []Divergence {
+	var result []Divergence
+	scan := func(price []float64, lows bool) {
+		pricePivots := findPivots(price, opts.Left, opts.Right, lows)
+		indPivots := findPivots(indicator, opts.Left, opts.Right, lows)
+		for n := 1; n < len(pricePivots); n++ {
+			p1, p2 := pricePivots[n-1], pricePivots[n]
+			if gap := p2 - p1; gap < opts.MinGap || gap > opts.MaxGap {
+				continue
+			}
+			q1 := nearestPivot(indPivots, p1, opts.Tolerance)
+			q2 := nearestPivot(indPivots, p2, opts.Tolerance)
+			if q1 < 0 || q2 < 0 || q1 >= q2 {
+				continue
+			}
+			var kind string
+			priceUp, indUp := price[p2] > price[p1], indicator[q2] > indicator[q1]
+			priceDown, indDown := price[p2] < price[p1], indicator[q2] < indicator[q1]
+			switch {
+			case lows && priceDown && indUp:
+				kind = RegularBullish
+			case lows && priceUp && indDown:
+				kind = HiddenBullish
+			case !lows && priceUp && indDown:
+				kind = RegularBearish
+			case !lows && priceDown && indUp:
+				kind = HiddenBearish
+			default:
+				continue
+			}
+			result = append(result, Divergence{
+				Kind:         kind,
+				PrevPrice:    price[p1],
+				Price:        price[p2],
+				PrevValue:    indicator[q1],
+				Value:        indicator[q2],
+				prevIndex:    p1,
+				index:        p2,
+				confirmIndex: max(p2, q2) + opts.Right,
+			})
+		}
+	}
+	scan(low, true)
+	scan(high, false)
+	sort.Slice(result, func(i, j int) bool { return result[i].confirmIndex < result[j].confirmIndex })
+	return result
+}
+
+// detectDivergences 在K线上检测价格与 MACD 柱状图或 RSI 的背离，K线需按时间升序
+func detectDivergences(klines []Kline, indicator string, params []float64, opts divergenceOptions) ([]Divergence, error) {
+	var output string
+	switch indicator {
+	case "macd":
+		output = "hist"
+	case "rsi":
+		output = "rsi"
+	default:
+		return nil, fmt.Errorf("divergence supports macd or rsi, got %s", indicator)
+	}
+	values, _, err := computeIndicator(indicator, klines, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var low, high []float64
+	if opts.Source == "close" {
+		low = klineCloses(klines)
+		high = low
+	} else {
+		high, low, _ = klineHLC(klines)
+	}
+	divergences := findDivergences(low, high, values[output], opts)
+	for i := range divergences {
+		d := &divergences[i]
+		d.PrevTime = klines[d.prevIndex].OpenTime
+		d.Time = klines[d.index].OpenTime
+		if d.confirmIndex < len(klines) {
+			d.ConfirmTime = klines[d.confirmIndex].OpenTime
+		}
+	}
+	return divergences, nil
+}
+
+// DivergenceCond 规则条件：最近 Within 根K线内确认了指定类型的背离
+type DivergenceCond struct {
+	Indicator string    `json:"indicator"`        // macd/rsi
+	Params    []float64 `json:"params,omitempty"` // 指标参数
+	Kind      string    `json:"kind,omitempty"`   // 具体类型，或 bullish/bearish，为空匹配全部
+	Within    int       `json:"within,omitempty"` // 默认 1，即只看当前K线
+	Source    string    `json:"source,omitempty"` // hl/close，默认 hl
+	Left      int       `json:"left,omitempty"`
+	Right     int       `json:"right,omitempty"`
+	MaxGap    int       `json:"max_gap,omitempty"`
+}
+
+func (c DivergenceCond) options() divergenceOptions {
+	opts := defaultDivergenceOptions
+	if c.Source != "" {
+		opts.Source = c.Source
+	}
+	if c.Left > 0 {
+		opts.Left = c.Left
+	}
+	if c.Right > 0 {
+		opts.Right = c.Right
+	}
+	if c.MaxGap > 0 {
+		opts.MaxGap = c.MaxGap
+	}
+	return opts
+}
+
+func (c DivergenceCond) validate() error {
+	if c.Indicator != "macd" && c.Indicator != "rsi" {
+		return fmt.Errorf("divergence indicator must be macd or rsi, got %q", c.Indicator)
+	}
+	if _, _, err := computeIndicator(c.Indicator, nil, c.Params); err != nil {
+		return err
+	}
+	switch c.Kind {
+	case "", "bullish", "bearish", RegularBullish, HiddenBullish, RegularBearish, HiddenBearish:
+	default:
+		return fmt.Errorf("invalid divergence kind %q", c.Kind)
+	}
+	if c.Source != "" && c.Source != "hl" && c.Source != "close" {
+		return fmt.Errorf("invalid divergence source %q", c.Source)
+	}
+	return nil
+}
+
+func (c DivergenceCond) matchKind(kind string) bool {
+	switch c.Kind {
+	case "":
+		return true
+	case "bullish", "bearish":
+		return strings.HasSuffix(kind, c.Kind)
+	}
+	return c.Kind == kind
+}
+
+// divergenceKey 返回检测结果在 ruleFrame 中的缓存键，与 Kind、Within 无关
+func divergenceKey(c DivergenceCond) string {
+	return fmt.Sprintf("%s:%v:%+v", c.Indicator, c.Params, c.options())
+}
+
+// eval 第 i 根K线及之前 Within-1 根内是否确认了背离，只使用已确认的拐点
+func (c DivergenceCond) eval(f *ruleFrame, i int) bool {
+	key := divergenceKey(c)
+	divergences, ok := f.divergences[key]
+	if !ok {
+		divergences, _ = detectDivergences(f.klines, c.Indicator, c.Params, c.options())
+		f.divergences[key] = divergences
+	}
+	within := c.Within
+	if within <= 0 {
+		within = 1
+	}
+	for _, d := range divergences {
+		if d.confirmIndex <= i && d.confirmIndex > i-within && c.matchKind(d.Kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleDivergences 返回代币的背离列表
+// 例如 /divergences?symbol=BTCUSDT&interval=1h&indicator=rsi&limit=300
+func handleDivergences(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		q := r.URL.Query()
+		symbol := q.Get("symbol")
+		interval := q.Get("interval")
+		if symbol == "" || interval == "" {
+			http.Error(w, "missing symbol or interval", http.StatusBadRequest)
+			return
+		}
+		if !isTrackedSymbol(symbol) {
+			http.Error(w, "unknown symbol", http.StatusNotFound)
+			return
+		}
+		if intervalMillis(interval) == 0 {
+			http.Error(w, "unsupported interval", http.StatusBadRequest)
+			return
+		}
+		limit, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 300
+		}
+		indicator := q.Get("indicator")
+		if indicator == "" {
+			indicator = "macd"
+		}
+		params, err := parseIndicatorParams(q.Get("params"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cond := DivergenceCond{Indicator: indicator, Params: params, Kind: q.Get("kind"), Source: q.Get("source")}
+		cond.Left, _ = strconv.Atoi(q.Get("left"))
+		cond.Right, _ = strconv.Atoi(q.Get("right"))
+		cond.MaxGap, _ = strconv.Atoi(q.Get("max_gap"))
+		if err := cond.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		klines := getAggKlineAsc(db, symbol, interval, limit, true)
+		divergences, err := detectDivergences(klines, indicator, params, cond.options())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result := make([]Divergence, 0, len(divergences))
+		for _, d := range divergences {
+			if cond.matchKind(d.Kind) {
+				result = append(result, d)
+			}
+		}
+		writeJSON(w, r, result)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+)
+
+// vShape 生成在 center 处取得最小值 bottom 的折线，用于构造拐点
+func vShape(series []float64, center int, bottom, slope float64) {
+	for i := range series {
+		d := float64(abs(i - center))
+		if v := bottom + d*slope; v < series[i] {
+			series[i] = v
+		}
+	}
+}
+
+func TestFindDivergencesRegularBullish(t *testing.T) {
+	n := 40
+	low := make([]float64, n)
+	ind := make([]float64, n)
+	for i := range low {
+		low[i] = 200
+		ind[i] = 50
+	}
+	// 价格第二个低点更低，指标第二个低点更高
+	vShape(low, 10, 100, 3)
+	vShape(low, 25, 90, 3)
+	vShape(ind, 10, 20, 2)
+	vShape(ind, 26, 30, 2)
+	high := make([]float64, n)
+	for i := range high {
+		high[i] = low[i] + 1
+	}
+
+	opts := defaultDivergenceOptions
+	divergences := findDivergences(low, high, ind, opts)
+	var found *Divergence
+	for i := range divergences {
+		if divergences[i].Kind == RegularBullish {
+			found = &divergences[i]
+		}
+	}
+	if found == nil {
+		t.Fatalf("expected regular bullish divergence, got %+v", divergences)
+	}
+	if found.prevIndex != 10 || found.index != 25 || found.confirmIndex != 26+opts.Right {
+		t.Fatalf("unexpected pivots: %+v", *found)
+	}
+
+	// 确认之前的K线不能看到这次背离
+	cond := DivergenceCond{Indicator: "rsi", Kind: "bullish"}
+	frame := newRuleFrame("15m", nil, 0, nil)
+	frame.divergences[divergenceKey(cond)] = divergences
+	if cond.eval(frame, found.confirmIndex-1) {
+		t.Fatal("divergence visible before confirmation")
+	}
+	if !cond.eval(frame, found.confirmIndex) {
+		t.Fatal("divergence not visible on confirmation bar")
+	}
+	if cond.eval(frame, found.confirmIndex+1) {
+		t.Fatal("within=1 should only match the confirmation bar")
+	}
+	cond.Within = 3
+	if !cond.eval(frame, found.confirmIndex+2) {
+		t.Fatal("within=3 should match two bars after confirmation")
+	}
+}
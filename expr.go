@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ================= 条件表达式 =================
+//
+// 把 "rsi(14)@4h < 30 and close > ema(200)@1d and volume24h > 10M" 这样的文本编译为规则条件：
+//   - 取值：数字（可带 K/M/B 后缀）、open/high/low/close/volume（price 同 close）、
+//     指标 name(参数).输出，以及别名 volume24h、change24h
+//   - 取值后缀：[n] 向前偏移 n 根K线，@周期 在其他周期上取值，rs/beta/corr 的 @代币 指定对比代币
+//   - 比较：> >= < <= == !=，above/below 同 > <，crosses above/crosses below 为上穿/下穿
+//   - 组合：and/&&、or/||、not/!，括号改变优先级
+
+// exprAliases 常用取值的别名，均按15m K线计算
+var exprAliases = map[string]Operand{
+	"volume24h": {Indicator: "qvol", Params: []float64{96}, Interval: "15m"},
+	"change24h": {Indicator: "roc", Params: []float64{96}, Interval: "15m"},
+}
+
+// exprToken 表达式的词法单元
+type exprToken struct {
+	kind string // num/ident/op/punct/interval/eof
+	text string
+	num  float64
+	pos  int
+}
+
+var exprNumberRe = regexp.MustCompile(`^(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?[KMB]?`)
+
+// lexExpr 把表达式拆分为词法单元
+func lexExpr(s string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '@':
+			j := i + 1
+			for j < len(s) && isExprWord(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("position %d: missing interval after @", i)
+			}
+			tokens = append(tokens, exprToken{kind: "interval", text: s[i+1 : j], pos: i})
+			i = j
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			m := exprNumberRe.FindString(s[i:])
+			text, scale := m, 1.0
+			switch m[len(m)-1] {
+			case 'K':
+				text, scale = m[:len(m)-1], 1e3
+			case 'M':
+				text, scale = m[:len(m)-1], 1e6
+			case 'B':
+				text, scale = m[:len(m)-1], 1e9
+			}
+			v, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("position %d: invalid number %q", i, m)
+			}
+			tokens = append(tokens, exprToken{kind: "num", text: m, num: v * scale, pos: i})
+			i += len(m)
+		case isExprWord(c):
+			j := i
+			for j < len(s) && isExprWord(s[j]) {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: "ident", text: strings.ToLower(s[i:j]), pos: i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{">=", "<=", "==", "!=", "&&", "||", ">", "<", "!"} {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op != "" {
+				tokens = append(tokens, exprToken{kind: "op", text: op, pos: i})
+				i += len(op)
+				continue
+			}
+			if !strings.ContainsRune("(),.[]-", rune(c)) {
+				return nil, fmt.Errorf("position %d: unexpected %q", i, c)
+			}
+			tokens = append(tokens, exprToken{kind: "punct", text: string(c), pos: i})
+			i++
+		}
+	}
+	return append(tokens, exprToken{kind: "eof", pos: len(s)}), nil
+}
+
+func isExprWord(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// exprParser 递归下降解析器
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek() exprToken { return p.tokens[p.pos] }
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+// accept 当前词法单元为 text 时跳过并返回 true
+func (p *exprParser) accept(texts ...string) bool {
+	if t := p.peek(); t.kind != "num" && t.kind != "eof" && slices.Contains(texts, t.text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) errorf(t exprToken, format string, args ...any) error {
+	return fmt.Errorf("position %d: %s", t.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return p.errorf(t, "expected %q, got %q", text, t.text)
+	}
+	return nil
+}
+
+// parseCondition 把表达式编译为规则条件
+func parseCondition(s string) (Condition, error) {
+	tokens, err := lexExpr(s)
+	if err != nil {
+		return Condition{}, err
+	}
+	p := &exprParser{tokens: tokens}
+	c, err := p.parseOr()
+	if err != nil {
+		return Condition{}, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return Condition{}, p.errorf(t, "unexpected %q", t.text)
+	}
+	return c, c.validate()
+}
+
+// parseOperandExpr 解析单个取值，如 "rsi(14)@4h"
+func parseOperandExpr(s string) (Operand, error) {
+	tokens, err := lexExpr(s)
+	if err != nil {
+		return Operand{}, err
+	}
+	p := &exprParser{tokens: tokens}
+	o, err := p.parseOperand()
+	if err != nil {
+		return Operand{}, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return Operand{}, p.errorf(t, "unexpected %q", t.text)
+	}
+	return o, nil
+}
+
+func (p *exprParser) parseOr() (Condition, error) {
+	var any []Condition
+	for {
+		c, err := p.parseAnd()
+		if err != nil {
+			return c, err
+		}
+		any = append(any, c)
+		if !p.accept("or", "||") {
+			break
+		}
+	}
+	if len(any) == 1 {
+		return any[0], nil
+	}
+	return Condition{Any: any}, nil
+}
+
+func (p *exprParser) parseAnd() (Condition, error) {
+	var all []Condition
+	for {
+		c, err := p.parseUnary()
+		if err != nil {
+			return c, err
+		}
+		all = append(all, c)
+		if !p.accept("and", "&&") {
+			break
+		}
+	}
+	if len(all) == 1 {
+		return all[0], nil
+	}
+	return Condition{All: all}, nil
+}
+
+func (p *exprParser) parseUnary() (Condition, error) {
+	if p.accept("not", "!") {
+		c, err := p.parseUnary()
+		if err != nil {
+			return c, err
+		}
+		return Condition{Not: &c}, nil
+	}
+	// 取值不会以括号开头，括号只用于分组
+	if p.accept("(") {
+		c, err := p.parseOr()
+		if err != nil {
+			return c, err
+		}
+		return c, p.expect(")")
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (Condition, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return Condition{}, err
+	}
+	t := p.next()
+	op := t.text
+	switch {
+	case t.kind == "op" && validOp(op):
+	case t.kind == "ident" && op == "above":
+		op = ">"
+	case t.kind == "ident" && op == "below":
+		op = "<"
+	case t.kind == "ident" && op == "crosses":
+		direction := "up"
+		if p.accept("below") {
+			direction = "down"
+		} else if err := p.expect("above"); err != nil {
+			return Condition{}, err
+		}
+		right, err := p.parseOperand()
+		if err != nil {
+			return Condition{}, err
+		}
+		return Condition{Cross: &CrossCond{Fast: left, Slow: right, Direction: direction}}, nil
+	default:
+		return Condition{}, p.errorf(t, "expected comparison, got %q", t.text)
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return Condition{}, err
+	}
+	return Condition{Compare: &CompareCond{Left: left, Op: op, Right: right}}, nil
+}
+
+func (p *exprParser) parseOperand() (Operand, error) {
+	negative := p.accept("-")
+	t := p.next()
+	if t.kind == "num" {
+		if negative {
+			return constOperand(-t.num), nil
+		}
+		return constOperand(t.num), nil
+	}
+	if negative || t.kind != "ident" {
+		return Operand{}, p.errorf(t, "expected value, got %q", t.text)
+	}
+
+	var o Operand
+	if alias, ok := exprAliases[t.text]; ok {
+		o = alias
+		o.Params = slices.Clone(alias.Params)
+	} else if t.text == "price" {
+		o = priceOperand("close")
+	} else if slices.Contains([]string{"open", "high", "low", "close", "volume"}, t.text) {
+		o = priceOperand(t.text)
+	} else if defaults, outputs, ok := indicatorDefaults(t.text); ok {
+		o = Operand{Indicator: t.text}
+		if p.accept("(") {
+			for !p.accept(")") {
+				if len(o.Params) > 0 {
+					if err := p.expect(","); err != nil {
+						return o, err
+					}
+				}
+				v := p.next()
+				if v.kind != "num" {
+					return o, p.errorf(v, "expected number, got %q", v.text)
+				}
+				o.Params = append(o.Params, v.num)
+			}
+		}
+		// 补全默认参数，使 rsi 与 rsi(14) 为同一个取值
+		if len(o.Params) < len(defaults) {
+			o.Params = append(o.Params, defaults[len(o.Params):]...)
+		}
+		if p.accept(".") {
+			out := p.next()
+			if out.kind != "ident" {
+				return o, p.errorf(out, "expected output name, got %q", out.text)
+			}
+			if len(outputs) == 0 || out.text != outputs[0] {
+				o.Output = out.text
+			}
+		}
+	} else {
+		return o, p.errorf(t, "unknown value %q", t.text)
+	}
+
+	if p.accept("[") {
+		n := p.next()
+		if n.kind != "num" || n.num != float64(int(n.num)) {
+			return o, p.errorf(n, "expected bar offset, got %q", n.text)
+		}
+		o.Offset = int(n.num)
+		if err := p.expect("]"); err != nil {
+			return o, err
+		}
+	}
+	for p.peek().kind == "interval" {
+		at := p.next()
+		switch {
+		case intervalMillis(at.text) > 0:
+			o.Interval = at.text
+		case isPairIndicator(o.Indicator):
+			if benchmark := strings.ToUpper(at.text); benchmark != defaultBenchmark {
+				o.Benchmark = benchmark
+			}
+		default:
+			return o, p.errorf(at, "unsupported interval %q", at.text)
+		}
+	}
+	if err := o.validate(); err != nil {
+		return o, p.errorf(t, "%v", err)
+	}
+	return o, nil
+}
+
+// formatOperand 返回取值的规范写法，解析结果相同的取值写法相同
+func formatOperand(o Operand) string {
+	var b strings.Builder
+	switch {
+	case o.Value != nil:
+		return strconv.FormatFloat(*o.Value, 'g', -1, 64)
+	case o.Price != "":
+		b.WriteString(o.Price)
+	default:
+		b.WriteString(strings.ToLower(o.Indicator))
+		if len(o.Params) > 0 {
+			parts := make([]string, len(o.Params))
+			for i, v := range o.Params {
+				parts[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+			b.WriteString("(" + strings.Join(parts, ",") + ")")
+		}
+		if o.Output != "" {
+			b.WriteString("." + o.Output)
+		}
+	}
+	if o.Offset > 0 {
+		fmt.Fprintf(&b, "[%d]", o.Offset)
+	}
+	if o.Interval != "" {
+		b.WriteString("@" + o.Interval)
+	}
+	if o.Benchmark != "" {
+		b.WriteString("@" + o.Benchmark)
+	}
+	return b.String()
+}
+
+// indicatorDefaults 返回普通指标或跨代币指标的默认参数和输出名称
+func indicatorDefaults(name string) ([]float64, []string, bool) {
+	if spec, ok := indicatorRegistry[name]; ok {
+		return spec.Defaults, spec.Outputs, true
+	}
+	if spec, ok := pairIndicators[name]; ok {
+		return spec.Defaults, nil, true
+	}
+	return nil, nil, false
+}
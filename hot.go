@@ -1,23 +1,34 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
-	"sort"
+	"net/http"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/parnurzeal/gorequest"
-	"github.com/remeh/sizedwaitgroup"
 	"github.com/tidwall/gjson"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
 )
 
 var binanceExcludes []string = []string{"USDC", "FDUSD", "TUSD", "USDP", "FDUSD", "AEUR", "ASR", "OG", "WNXM", "WBETH", "WBTC",
 	"WAXP", "FOR", "JST", "SUN", "WIN", "TRX", "UTK", "TROY", "WRX", "DOCK", "C98", "EUR", "USTC", "USDS", "AUD", "DAI"}
 
 var DOWNUP []string = []string{"DOWN", "UP"}
-var request *gorequest.SuperAgent
+
+// binanceFuturesAPI 币安 U 本位合约接口地址
+var binanceFuturesAPI = "https://fapi.binance.com"
+
+// hotHTTPClient 热门代币请求共用的客户端，可以安全地在多个 goroutine 中使用
+var hotHTTPClient = &http.Client{Timeout: 15 * time.Second}
 
 type KLine struct {
 	Symbol    string
@@ -29,6 +40,9 @@ type HotPair struct {
 	LastPrice   float64
 	Percent     float64
 	QuoteVolume float64
+	FundingRate *float64 `json:",omitempty"` // 最近一次资金费率（%）
+	Score       float64  // 排序依据的数值，含义取决于 sort 参数
+	SparkSource string   `json:",omitempty"` // 走势来源：local 本地K线表，binance 币安接口
 	Klines      []KLine
 }
 type HotPairList []*HotPair
@@ -52,65 +66,328 @@ func ContainsString(slice []string, target string) bool {
 	}
 	return false
 }
-func HotList() (symols HotPairList) {
-	request = gorequest.New()
-	url := "https://fapi.binance.com/fapi/v1/ticker/24hr"
-	_, responseBody, err := request.Get(url).End()
+
+// binanceGet 请求币安接口并返回响应体，非 2xx 状态视为错误
+func binanceGet(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, binanceFuturesAPI+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hotHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, &httpStatusError{Status: resp.StatusCode, Body: string(body)}
+	}
+	return body, nil
+}
+
+// hotData 一次刷新得到的全部行情，以及默认参数下的涨幅榜
+type hotData struct {
+	universe []hotTicker
+	list     HotPairList
+}
+
+// loadHotData 获取行情并按 hotSettings 排序，获取行情失败时返回错误，
+// 单个代币走势失败只记录日志，该代币的 Klines 为空
+func loadHotData(ctx context.Context, db *gorm.DB) (hotData, error) {
+	universe, err := fetchHotUniverse(ctx)
 	if err != nil {
-		log.Fatal("Error making GET request:", err)
-	}
-
-	value := gjson.Parse(responseBody).Array()
-	for _, symbol := range value {
-		symbolCoin := symbol.Get("symbol").String()
-		volume24h := symbol.Get("quoteVolume").Float()
-		lastPrice := symbol.Get("lastPrice").Float()
-		closeTime := symbol.Get("closeTime").Int()
-		if closeTime > time.Now().Add(-1*time.Hour).UnixMilli() && volume24h > 5_000_000 && strings.Contains(symbolCoin, "USDT") {
-			baseAsset := symbolCoin[:len(symbolCoin)-4]
-			quoteAsset := symbolCoin[len(symbolCoin)-4:]
-			if quoteAsset == "USDT" && !ContainsString(binanceExcludes, baseAsset) && !strings.HasSuffix(baseAsset, "DOWN") && !strings.HasSuffix(baseAsset, "UP") {
-				priceChangePercent := symbol.Get("priceChangePercent").Float()
-				pair := HotPair{Symbol: baseAsset, LastPrice: lastPrice, QuoteVolume: volume24h, Percent: priceChangePercent}
-				symols = append(symols, &pair)
+		return hotData{}, err
+	}
+	return hotData{universe: universe, list: rankHotList(ctx, db, universe, hotSettings.hotQuery)}, nil
+}
+
+// CollectTrendWithSymbol 返回代币最近 limit 根K线的收盘价
+func CollectTrendWithSymbol(ctx context.Context, pair string, interval string, limit int) (klines []KLine, err error) {
+	body, err := binanceGet(ctx, fmt.Sprintf("/fapi/v1/klines?symbol=%sUSDT&interval=%s&limit=%d", pair, interval, limit))
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range gjson.ParseBytes(body).Array() {
+		row := v.Array()
+		if len(row) < 7 {
+			continue
+		}
+		c, _ := strconv.ParseFloat(row[4].String(), 64)
+		t, _ := strconv.ParseInt(row[6].String(), 10, 64)
+		klines = append(klines, KLine{Symbol: pair, Price: c, TimeStamp: t})
+	}
+	return klines, nil
+}
+
+// ================= 后台刷新 =================
+
+// hotSnapshot 最近一次成功获取的涨幅榜，以及之后的刷新错误
+type hotSnapshot struct {
+	resp      cachedResponse
+	list      HotPairList
+	universe  []hotTicker // 全部行情，用于按请求参数重新排序
+	updatedAt time.Time   // 最近一次成功刷新的时间，零值表示还没有成功过
+	lastError error       // 最近一次刷新的错误，成功后清空
+	errorAt   time.Time
+}
+
+// hotResponder 在后台定期刷新涨幅榜，接口和机器人读取最近一次成功的快照
+type hotResponder struct {
+	db    *gorm.DB                                   // 读取本地走势和自动监控，为 nil 时只请求币安
+	load  func(ctx context.Context) (hotData, error) // 为 nil 时使用 loadHotData
+	every time.Duration                              // 刷新周期，为 0 时使用 HOT_REFRESH
+	track time.Duration                              // 连续上榜超过该时长的代币自动加入监控列表，0 表示不自动监控
+	group singleflight.Group
+
+	mu   sync.RWMutex
+	snap hotSnapshot
+	seen map[string]time.Time // 默认涨幅榜中每个代币本次连续上榜的起始时间
+}
+
+// hotPairs /hot 接口和机器人共用的涨幅榜，启动时设置 db 和 track
+var hotPairs = &hotResponder{}
+
+func (h *hotResponder) interval() time.Duration {
+	if h.every > 0 {
+		return h.every
+	}
+	return hotRefreshInterval()
+}
+
+// hotCacheTTLWarning 使用旧变量 HOT_CACHE_TTL 时只提示一次
+var hotCacheTTLWarning sync.Once
+
+// hotRefreshInterval 返回 HOT_REFRESH，默认 1 分钟；未设置时兼容旧的 HOT_CACHE_TTL
+func hotRefreshInterval() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("HOT_REFRESH")); err == nil && d > 0 {
+		return d
+	}
+	if d, err := time.ParseDuration(os.Getenv("HOT_CACHE_TTL")); err == nil && d > 0 {
+		hotCacheTTLWarning.Do(func() { log.Printf("HOT_CACHE_TTL 已改名为 HOT_REFRESH，请更新配置") })
+		return d
+	}
+	return time.Minute
+}
+
+// snapshot 返回当前快照的副本
+func (h *hotResponder) snapshot() hotSnapshot {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.snap
+}
+
+// refresh 获取一次涨幅榜，失败时保留上一次的快照；并发调用只请求一次，panic 也转为错误
+func (h *hotResponder) refresh(ctx context.Context) error {
+	_, err, _ := h.group.Do("hot", func() (_ any, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+		var data hotData
+		if h.load != nil {
+			data, err = h.load(ctx)
+		} else {
+			data, err = loadHotData(ctx, h.db)
+		}
+		var resp cachedResponse
+		if err == nil {
+			resp, err = newCachedResponse(data.list)
+		}
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if err != nil {
+			h.snap.lastError, h.snap.errorAt = err, time.Now()
+			return nil, err
+		}
+		h.snap = hotSnapshot{resp: resp, list: data.list, universe: data.universe, updatedAt: time.Now()}
+		h.updateSeen(data.list)
+		return nil, nil
+	})
+	return err
+}
+
+// updateSeen 记录默认涨幅榜中代币的连续上榜时间，掉出榜单的代币重新计时，调用方持有 h.mu
+func (h *hotResponder) updateSeen(list HotPairList) {
+	now := time.Now()
+	seen := make(map[string]time.Time, len(list))
+	for _, p := range list {
+		if first, ok := h.seen[p.Symbol]; ok {
+			seen[p.Symbol] = first
+		} else {
+			seen[p.Symbol] = now
+		}
+	}
+	h.seen = seen
+}
+
+// autoTrack 把连续上榜超过 track 的代币加入监控列表，并发送一条通知
+func (h *hotResponder) autoTrack(now time.Time) {
+	d := h.track
+	if h.db == nil || d <= 0 {
+		return
+	}
+	h.mu.RLock()
+	var due []string
+	for symbol, first := range h.seen {
+		if now.Sub(first) >= d && !isTrackedSymbol(symbol+"USDT") {
+			due = append(due, symbol)
+		}
+	}
+	h.mu.RUnlock()
+	slices.Sort(due)
+
+	var added []string
+	for _, symbol := range due {
+		ok, err := watchSymbol(h.db, symbol+"USDT")
+		if err != nil {
+			log.Printf("自动监控 %s 失败: %v", symbol, err)
+			continue
+		}
+		if ok {
+			added = append(added, symbol+"USDT")
+		}
+	}
+	if len(added) == 0 {
+		return
+	}
+	log.Printf("自动监控涨幅榜代币: %v", added)
+	text := fmt.Sprintf("以下代币连续 %s 出现在涨幅榜中，已加入监控列表：%s", d, strings.Join(added, ", "))
+	if err := notifier.Send(Notification{Title: "自动监控", Text: text}); err != nil {
+		log.Printf("发送自动监控通知失败: %v", err)
+	}
+}
+
+// start 立即刷新一次，之后按周期在后台刷新，ctx 结束时停止
+func (h *hotResponder) start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(h.interval())
+		defer ticker.Stop()
+		for {
+			if err := h.refresh(ctx); err != nil {
+				log.Printf("刷新涨幅榜失败，继续使用上一次的数据: %v", err)
+			} else {
+				h.autoTrack(time.Now())
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
 			}
 		}
+	}()
+}
+
+// get 返回最近一次成功的快照。还没有快照时同步刷新一次；
+// 快照和上次失败都早于一个刷新周期时（例如后台刷新没有运行）在后台刷新，本次仍返回旧数据
+func (h *hotResponder) get(ctx context.Context) (hotSnapshot, error) {
+	snap := h.snapshot()
+	if snap.updatedAt.IsZero() {
+		err := h.refresh(ctx)
+		if snap = h.snapshot(); snap.updatedAt.IsZero() {
+			return snap, err
+		}
+		return snap, nil
+	}
+	last := snap.updatedAt
+	if snap.errorAt.After(last) {
+		last = snap.errorAt
 	}
-	sort.Sort(symols)
-	symols = symols[:30]
-	swg := sizedwaitgroup.New(4)
-	for _, s := range symols {
-		swg.Add()
-		go func(s *HotPair) {
-			defer swg.Done()
-			list := CollectTrendWithSymbol(s.Symbol, "5m")
-			s.Klines = list
-			// time.Sleep(time.Millisecond * 200)
-		}(s)
+	if time.Since(last) > h.interval() {
+		go h.refresh(context.Background())
 	}
-	swg.Wait()
+	return snap, nil
+}
 
-	return
+// stale 快照超过两个刷新周期没有更新
+func (h *hotResponder) stale(snap hotSnapshot) bool {
+	return time.Since(snap.updatedAt) > 2*h.interval()
 }
-func CollectTrendWithSymbol(pair string, interval string) (klines []KLine) {
-	url := fmt.Sprintf("https://fapi.binance.com/fapi/v1/klines?symbol=%sUSDT&interval=%s&limit=%d", pair, interval, 50)
-	_, bodyBytes, err := request.Get(url).End()
-	// response, err := http.Get(url)
+
+// list 返回最近一次成功的涨幅榜，从未成功时为空
+func (h *hotResponder) list() HotPairList {
+	snap, _ := h.get(context.Background())
+	return snap.list
+}
+
+// view 按请求参数从快照的行情重新排序，结果按参数和快照时间缓存，相同的并发请求只计算一次；
+// 参数与默认值相同时直接返回快照
+func (h *hotResponder) view(ctx context.Context, snap hotSnapshot, q hotQuery) (cachedResponse, bool, error) {
+	if q.key() == hotSettings.hotQuery.key() {
+		return snap.resp, true, nil
+	}
+	key := fmt.Sprintf("hot:%s:%d", q.key(), snap.updatedAt.UnixNano())
+	if resp, ok, _ := responseCache.Get(key); ok {
+		return resp, true, nil
+	}
+	v, err, _ := h.group.Do(key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		resp, err := newCachedResponse(rankHotList(ctx, h.db, snap.universe, q))
+		if err != nil {
+			return nil, err
+		}
+		responseCache.Set(key, resp, 2*h.interval())
+		return resp, nil
+	})
 	if err != nil {
-		log.Fatal("Error making GET request:", err)
-	}
-	// defer response.Body.Close()
-	// bodyBytes, err := io.ReadAll(response.Body)
-	// if err != nil {
-	// 	log.Fatal("Error reading response body:", err)
-	// }
-	value := gjson.Parse(string(bodyBytes)).Array()
-	if len(value) > 0 {
-		for _, v := range value {
-			c, _ := strconv.ParseFloat(v.Array()[4].String(), 64)
-			t, _ := strconv.ParseInt(v.Array()[6].String(), 10, 64)
-			klines = append(klines, KLine{Symbol: pair, Price: c, TimeStamp: t})
-		}
-	}
-	return
+		return cachedResponse{}, false, err
+	}
+	return v.(cachedResponse), false, nil
+}
+
+// hotResponse /hot?meta=1 的响应
+type hotResponse struct {
+	Data      HotPairList `json:"data"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	Age       float64     `json:"age"` // 秒
+	Stale     bool        `json:"stale"`
+	Error     string      `json:"error,omitempty"` // 最近一次刷新失败的原因
+}
+
+// handleHotSymbols 返回最近一次成功获取的涨幅榜，响应头说明数据时间和刷新错误，
+// sort/order/limit/min_volume/exclude/include/spark_interval/spark_length 覆盖 hot.json 的默认参数，
+// meta=1 时以 {"data", "updated_at", "age", "stale", "error"} 返回
+func handleHotSymbols(h *hotResponder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return // 处理预检请求
+		}
+		q, err := hotQueryFromValues(hotSettings.hotQuery, r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		snap, err := h.get(r.Context())
+		if snap.updatedAt.IsZero() {
+			http.Error(w, fmt.Sprintf("hot list unavailable: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+		age := time.Since(snap.updatedAt)
+		stale := h.stale(snap)
+		w.Header().Set("Last-Modified", snap.updatedAt.UTC().Format(http.TimeFormat))
+		w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+		w.Header().Set("X-Hot-Stale", strconv.FormatBool(stale))
+		var errText string
+		if snap.lastError != nil {
+			errText = snap.lastError.Error()
+			w.Header().Set("X-Hot-Error", strings.ReplaceAll(errText, "\n", " "))
+		}
+		resp, hit, err := h.view(r.Context(), snap, q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("meta") == "1" {
+			var list HotPairList
+			json.Unmarshal(resp.Body, &list)
+			writeJSON(w, r, hotResponse{Data: list, UpdatedAt: snap.updatedAt, Age: age.Seconds(), Stale: stale, Error: errText})
+			return
+		}
+		writeCached(w, r, resp, hit)
+	}
 }
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestHotListFromBinance(t *testing.T) {
+	hotMetricCache = NewMemoryCache(4096)
+	closeTime := time.Now().UnixMilli()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/fapi/v1/ticker/24hr":
+			fmt.Fprintf(w, `[
+				{"symbol":"PEPEUSDT","quoteVolume":"9000000","lastPrice":"0.01","closeTime":%[1]d,"priceChangePercent":"12.5"},
+				{"symbol":"DOGEUSDT","quoteVolume":"8000000","lastPrice":"0.2","closeTime":%[1]d,"priceChangePercent":"3"},
+				{"symbol":"USDCUSDT","quoteVolume":"9000000","lastPrice":"1","closeTime":%[1]d,"priceChangePercent":"0"},
+				{"symbol":"TINYUSDT","quoteVolume":"100","lastPrice":"1","closeTime":%[1]d,"priceChangePercent":"50"}
+			]`, closeTime)
+		case r.URL.Query().Get("symbol") == "DOGEUSDT":
+			http.Error(w, "busy", http.StatusTooManyRequests)
+		default:
+			fmt.Fprintf(w, `[[0,"1","1","1","1.5","1",%d]]`, closeTime)
+		}
+	}))
+	defer srv.Close()
+	old := binanceFuturesAPI
+	binanceFuturesAPI = srv.URL
+	t.Cleanup(func() { binanceFuturesAPI = old })
+
+	// 少于 30 个代币时不会越界，单个代币走势失败不影响整体
+	data, err := loadHotData(t.Context(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := data.list
+	if len(data.universe) != 4 || len(list) != 2 || list[0].Symbol != "PEPE" || len(list[0].Klines) != 1 || list[0].Klines[0].Price != 1.5 || list[1].Klines != nil {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	defer down.Close()
+	binanceFuturesAPI = down.URL
+	if _, err := loadHotData(t.Context(), nil); err == nil {
+		t.Fatal("expected error for failed ticker request")
+	}
+}
+
+func TestHotResponderServesLastGoodSnapshot(t *testing.T) {
+	var fail atomic.Bool
+	var loads atomic.Int32
+	h := &hotResponder{every: 20 * time.Millisecond, load: func(ctx context.Context) (hotData, error) {
+		loads.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		if fail.Load() {
+			return hotData{}, errors.New("binance down")
+		}
+		return hotData{list: HotPairList{{Symbol: "PEPE", Percent: 12.5}}}, nil
+	}}
+	handler := handleHotSymbols(h)
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/hot"+query, nil))
+		return rec
+	}
+
+	fail.Store(true)
+	if rec := get(""); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "binance down") {
+		t.Fatalf("expected 503 without any snapshot, got %d %s", rec.Code, rec.Body)
+	}
+
+	// 并发的首次请求只加载一次
+	fail.Store(false)
+	loads.Store(0)
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get("")
+		}()
+	}
+	wg.Wait()
+	if loads.Load() != 1 {
+		t.Fatalf("concurrent requests should load once, got %d", loads.Load())
+	}
+	rec := get("")
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Hot-Stale") != "false" || rec.Header().Get("ETag") == "" {
+		t.Fatalf("unexpected response: %d %v", rec.Code, rec.Header())
+	}
+
+	// 刷新失败后继续返回旧数据，并在响应中说明
+	fail.Store(true)
+	if err := h.refresh(t.Context()); err == nil {
+		t.Fatal("expected refresh error")
+	}
+	time.Sleep(50 * time.Millisecond)
+	rec = get("?meta=1")
+	var body hotResponse
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusOK || len(body.Data) != 1 || !body.Stale || body.Error != "binance down" || rec.Header().Get("X-Hot-Error") != "binance down" {
+		t.Fatalf("expected stale snapshot with error, got %d %s", rec.Code, rec.Body)
+	}
+
+	// 恢复后后台刷新清除错误
+	fail.Store(false)
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	h.start(ctx)
+	time.Sleep(50 * time.Millisecond)
+	if snap := h.snapshot(); snap.lastError != nil || h.stale(snap) {
+		t.Fatalf("background refresh should recover: %+v", snap)
+	}
+}
+
+// TestHotResponderConcurrentRefresh 后台刷新与并发请求同时进行时，请求始终拿到完整的快照，重叠的刷新只加载一次
+func TestHotResponderConcurrentRefresh(t *testing.T) {
+	var loads atomic.Int32
+	var delay atomic.Int64
+	delay.Store(int64(2 * time.Millisecond))
+	h := &hotResponder{every: 5 * time.Millisecond, load: func(ctx context.Context) (hotData, error) {
+		n := loads.Add(1)
+		time.Sleep(time.Duration(delay.Load()))
+		return hotData{list: HotPairList{{Symbol: fmt.Sprintf("T%d", n), Percent: float64(n)}}}, nil
+	}}
+	if err := h.refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	h.start(ctx)
+
+	handler := handleHotSymbols(h)
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				rec := httptest.NewRecorder()
+				handler(rec, httptest.NewRequest(http.MethodGet, "/hot", nil))
+				var list HotPairList
+				if err := json.Unmarshal(rec.Body.Bytes(), &list); rec.Code != http.StatusOK || err != nil || len(list) != 1 || list[0].Symbol != fmt.Sprintf("T%d", int(list[0].Percent)) {
+					t.Errorf("inconsistent snapshot: %d %s", rec.Code, rec.Body)
+					return
+				}
+				if list := h.list(); len(list) != 1 {
+					t.Errorf("unexpected list: %+v", list)
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+	if loads.Load() < 3 {
+		t.Fatalf("background refresh should keep running, got %d loads", loads.Load())
+	}
+
+	// 同时触发的刷新合并为一次加载
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	delay.Store(int64(100 * time.Millisecond))
+	before := loads.Load()
+	var refreshes sync.WaitGroup
+	for range 10 {
+		refreshes.Add(1)
+		go func() {
+			defer refreshes.Done()
+			h.refresh(t.Context())
+		}()
+	}
+	refreshes.Wait()
+	if n := loads.Load() - before; n != 1 {
+		t.Fatalf("overlapping refreshes should share one load, got %d", n)
+	}
+}
+
+func TestHotRefreshInterval(t *testing.T) {
+	t.Setenv("HOT_REFRESH", "")
+	t.Setenv("HOT_CACHE_TTL", "")
+	if d := hotRefreshInterval(); d != time.Minute {
+		t.Fatalf("default interval %v", d)
+	}
+	t.Setenv("HOT_CACHE_TTL", "30s")
+	if d := hotRefreshInterval(); d != 30*time.Second {
+		t.Fatalf("HOT_CACHE_TTL should still be honoured, got %v", d)
+	}
+	t.Setenv("HOT_REFRESH", "2m")
+	if d := hotRefreshInterval(); d != 2*time.Minute {
+		t.Fatalf("HOT_REFRESH should take precedence, got %v", d)
+	}
+}
+
+func TestHotRankingModes(t *testing.T) {
+	hotMetricCache = NewMemoryCache(4096)
+	closeTime := time.Now().UnixMilli()
+	dailyVolume := map[string]string{"AAAUSDT": "1000000", "BBBUSDT": "50000000", "CCCUSDT": "4000000"}
+	openInterest := map[string][2]string{"AAAUSDT": {"100", "110"}, "CCCUSDT": {"100", "150"}}
+	var sparkLimit atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		symbol := r.URL.Query().Get("symbol")
+		switch r.URL.Path {
+		case "/fapi/v1/ticker/24hr":
+			fmt.Fprintf(w, `[
+				{"symbol":"AAAUSDT","quoteVolume":"10000000","lastPrice":"1","closeTime":%[1]d,"priceChangePercent":"10"},
+				{"symbol":"BBBUSDT","quoteVolume":"50000000","lastPrice":"1","closeTime":%[1]d,"priceChangePercent":"-8"},
+				{"symbol":"CCCUSDT","quoteVolume":"20000000","lastPrice":"1","closeTime":%[1]d,"priceChangePercent":"2"}
+			]`, closeTime)
+		case "/fapi/v1/premiumIndex":
+			fmt.Fprint(w, `[{"symbol":"AAAUSDT","lastFundingRate":"0.0001"},{"symbol":"BBBUSDT","lastFundingRate":"-0.0003"},{"symbol":"CCCUSDT","lastFundingRate":"0.0005"}]`)
+		case "/fapi/v1/klines":
+			var rows []string
+			if r.URL.Query().Get("interval") == "1d" {
+				for range 8 {
+					rows = append(rows, fmt.Sprintf(`[0,"1","1","1","1","1",%d,"%s"]`, closeTime, dailyVolume[symbol]))
+				}
+			} else {
+				sparkLimit.Store(r.URL.Query().Get("limit"))
+				rows = append(rows, fmt.Sprintf(`[0,"1","1","1","2","1",%d,"0"]`, closeTime))
+			}
+			fmt.Fprintf(w, "[%s]", strings.Join(rows, ","))
+		case "/futures/data/openInterestHist":
+			oi, ok := openInterest[symbol]
+			if !ok {
+				http.Error(w, "no data", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, `[{"sumOpenInterest":"%s"},{"sumOpenInterest":"%s"}]`, oi[0], oi[1])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	old, oldSettings := binanceFuturesAPI, hotSettings
+	binanceFuturesAPI = srv.URL
+	hotSettings = defaultHotConfig()
+	t.Cleanup(func() { binanceFuturesAPI, hotSettings = old, oldSettings })
+
+	handler := handleHotSymbols(&hotResponder{every: time.Hour})
+	get := func(query string) (*httptest.ResponseRecorder, HotPairList) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/hot"+query, nil))
+		var list HotPairList
+		json.Unmarshal(rec.Body.Bytes(), &list)
+		return rec, list
+	}
+	symbolsOf := func(list HotPairList) string {
+		var out []string
+		for _, p := range list {
+			out = append(out, p.Symbol)
+		}
+		return strings.Join(out, ",")
+	}
+
+	cases := map[string]string{
+		"":                          "AAA,CCC,BBB",
+		"?order=asc&limit=1":        "BBB",
+		"?sort=volume":              "BBB,CCC,AAA",
+		"?sort=funding":             "CCC,AAA,BBB",
+		"?sort=surge":               "AAA,CCC,BBB",
+		"?sort=oi":                  "CCC,AAA", // BBB 持仓数据获取失败，不参与排序
+		"?exclude=aaa":              "CCC,BBB",
+		"?include=BBBUSDT,CCC":      "CCC,BBB",
+		"?min_volume=15000000":      "CCC,BBB",
+		"?sort=atr&include=missing": "",
+	}
+	for query, want := range cases {
+		rec, list := get(query)
+		if rec.Code != http.StatusOK || symbolsOf(list) != want {
+			t.Errorf("%q: got %d %q, want %q", query, rec.Code, symbolsOf(list), want)
+		}
+	}
+
+	_, list := get("?sort=surge")
+	if list[0].Score != 10 || list[0].FundingRate == nil || *list[0].FundingRate != 0.01 {
+		t.Fatalf("unexpected metrics: %+v", list[0])
+	}
+	if rec, _ := get("?sort=surge"); rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatal("repeated query should be served from cache")
+	}
+	if _, list := get("?spark_length=0&limit=1"); list[0].Klines != nil {
+		t.Fatal("spark_length=0 should omit sparklines")
+	}
+	if _, list := get("?spark_interval=1h&spark_length=3&limit=1"); len(list[0].Klines) != 1 || sparkLimit.Load() != "3" {
+		t.Fatalf("sparkline should use the requested length: %+v", list[0])
+	}
+	for _, query := range []string{"?sort=hype", "?order=up", "?limit=0", "?spark_interval=7m", "?min_volume=x"} {
+		if rec, _ := get(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestHotSparklinesFromLocalStore(t *testing.T) {
+	hotMetricCache = NewMemoryCache(4096)
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSymbols, oldFile := symbols, symbolsFile
+	t.Cleanup(func() { symbols, symbolsFile = oldSymbols, oldFile })
+	symbols = []string{"TESTUSDT"}
+	symbolsFile = filepath.Join(t.TempDir(), "symbols.json")
+
+	// 本地K线截止到当前的15m周期
+	klines := fixtureKlines(200, 5)
+	shift := time.Now().Truncate(15*time.Minute).UnixMilli() - klines[len(klines)-1].OpenTime
+	for i := range klines {
+		klines[i].OpenTime += shift
+		klines[i].CloseTime += shift
+		klines[i].IsClosed = i < len(klines)-1
+	}
+	if err := ensureKlineTable(db, "TESTUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	db.Table(Kline{Symbol: "TESTUSDT"}.TableName()).Create(klines)
+
+	closeTime := time.Now().UnixMilli()
+	var restSparks sync.Map
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fapi/v1/ticker/24hr":
+			fmt.Fprintf(w, `[
+				{"symbol":"TESTUSDT","quoteVolume":"9000000","lastPrice":"1","closeTime":%[1]d,"priceChangePercent":"5"},
+				{"symbol":"NEWUSDT","quoteVolume":"9000000","lastPrice":"1","closeTime":%[1]d,"priceChangePercent":"3"}
+			]`, closeTime)
+		case "/fapi/v1/klines":
+			restSparks.Store(r.URL.Query().Get("symbol")+":"+r.URL.Query().Get("interval"), true)
+			fmt.Fprintf(w, `[[0,"1","1","1","1.5","1",%d]]`, closeTime)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	old, oldSettings := binanceFuturesAPI, hotSettings
+	binanceFuturesAPI = srv.URL
+	t.Cleanup(func() { binanceFuturesAPI, hotSettings = old, oldSettings })
+	hotSettings = defaultHotConfig()
+
+	// 默认设置下已监控且数据较新的代币不请求币安K线
+	h := &hotResponder{db: db, every: time.Hour, track: time.Hour}
+	if err := h.refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	restSparks.Range(func(key, _ any) bool {
+		if strings.HasPrefix(key.(string), "TESTUSDT:") {
+			t.Errorf("default refresh fetched %s from binance", key)
+		}
+		return true
+	})
+	if list := h.snapshot().list; list[0].SparkSource != "local" || len(list[0].Klines) != hotSettings.SparkLength {
+		t.Fatalf("default sparkline should come from local klines: %+v", list[0])
+	}
+
+	// 已监控且数据较新的代币从本地读取，其余请求币安
+	hotSettings.SparkInterval, hotSettings.SparkLength = "1h", 10
+	if err := h.refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	list := h.snapshot().list
+	if len(list) != 2 || list[0].Symbol != "TEST" || list[0].SparkSource != "local" || list[1].SparkSource != "binance" {
+		t.Fatalf("unexpected sources: %+v %+v", list[0], list[1])
+	}
+	if len(list[0].Klines) != 10 || list[0].Klines[9].Price != klines[len(klines)-1].Close {
+		t.Fatalf("local sparkline should end at the latest stored close: %+v", list[0].Klines)
+	}
+	if _, ok := restSparks.Load("TESTUSDT:1h"); ok {
+		t.Fatal("tracked symbol should not be fetched from binance")
+	}
+
+	// 5m 不能由15m K线聚合，仍请求币安
+	q := hotSettings.hotQuery
+	q.SparkInterval = "5m"
+	if list := rankHotList(t.Context(), db, h.snapshot().universe, q); list[0].SparkSource != "binance" {
+		t.Fatalf("5m sparkline should come from binance: %+v", list[0])
+	}
+
+	// 连续上榜未满 auto_track 时不监控，满足后加入监控列表
+	h.autoTrack(time.Now())
+	if isTrackedSymbol("NEWUSDT") {
+		t.Fatal("symbol should not be tracked before auto_track elapses")
+	}
+	h.autoTrack(time.Now().Add(2 * time.Hour))
+	if !isTrackedSymbol("NEWUSDT") || !db.Migrator().HasTable(Kline{Symbol: "NEWUSDT"}.TableName()) {
+		t.Fatal("symbol should be tracked after staying in the hot list")
+	}
+	if saved, err := loadSymbolsFromFile(symbolsFile); err != nil || !slices.Contains(saved, "NEWUSDT") {
+		t.Fatalf("auto-tracked symbol should be saved: %v %v", saved, err)
+	}
+}
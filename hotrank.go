@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/markcheno/go-talib"
+	"github.com/remeh/sizedwaitgroup"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+// ================= 涨幅榜排序与过滤 =================
+
+// hotSorts 支持的排序方式
+var hotSorts = []string{"percent", "volume", "surge", "atr", "funding", "oi"}
+
+// hotSparkIntervals 走势图可用的K线周期
+var hotSparkIntervals = []string{"1m", "3m", "5m", "15m", "30m", "1h", "2h", "4h", "6h", "8h", "12h", "1d"}
+
+// hotQuery 涨幅榜的排序、过滤和走势图参数，hot.json 提供默认值，请求参数覆盖
+type hotQuery struct {
+	Sort          string   `json:"sort"`           // percent/volume/surge/atr/funding/oi
+	Order         string   `json:"order"`          // desc 从大到小（涨幅榜），asc 从小到大（跌幅榜）
+	Limit         int      `json:"limit"`          // 返回数量
+	MinVolume     float64  `json:"min_volume"`     // 24h 成交额下限（USDT）
+	Exclude       []string `json:"exclude"`        // 排除的币种，如 USDC
+	Include       []string `json:"include"`        // 非空时只在这些币种中排序
+	SparkInterval string   `json:"spark_interval"` // 走势图K线周期
+	SparkLength   int      `json:"spark_length"`   // 走势图K线数量，0 表示不返回走势
+	Candidates    int      `json:"candidates"`     // surge/atr/oi 需要逐个请求，只计算成交额最大的这些代币
+}
+
+// defaultHotQuery 与最初的涨幅榜一致：按 24h 涨幅排序，前 30，5m 走势 50 根
+func defaultHotQuery() hotQuery {
+	return hotQuery{
+		Sort:          "percent",
+		Order:         "desc",
+		Limit:         30,
+		MinVolume:     5_000_000,
+		Exclude:       slices.Clone(binanceExcludes),
+		SparkInterval: "5m",
+		SparkLength:   50,
+		Candidates:    100,
+	}
+}
+
+// hotConfig hot.json 的内容：默认参数，以及走势来源和自动监控设置
+type hotConfig struct {
+	hotQuery
+	SparkSource string   `json:"spark_source"` // auto 已监控且数据较新的代币从本地K线表读取走势，binance 始终请求币安
+	AutoTrack   Duration `json:"auto_track"`   // 代币连续出现在默认涨幅榜中超过该时长后自动加入监控列表，0 表示不自动监控
+}
+
+// defaultHotConfig 默认优先使用本地K线，走势周期取本地能提供的 15m
+func defaultHotConfig() hotConfig {
+	cfg := hotConfig{hotQuery: defaultHotQuery(), SparkSource: "auto"}
+	cfg.SparkInterval = "15m"
+	return cfg
+}
+
+// hotSettings 当前生效的 hot.json 设置
+var hotSettings = defaultHotConfig()
+
+func (q *hotQuery) validate() error {
+	if !slices.Contains(hotSorts, q.Sort) {
+		return fmt.Errorf("unknown sort %q, expected one of %s", q.Sort, strings.Join(hotSorts, "/"))
+	}
+	if q.Order != "desc" && q.Order != "asc" {
+		return fmt.Errorf("order must be desc or asc")
+	}
+	if q.Limit <= 0 || q.Limit > 200 {
+		return fmt.Errorf("limit must be between 1 and 200")
+	}
+	if !slices.Contains(hotSparkIntervals, q.SparkInterval) {
+		return fmt.Errorf("unknown spark_interval %q", q.SparkInterval)
+	}
+	if q.SparkLength < 0 || q.SparkLength > 500 {
+		return fmt.Errorf("spark_length must be between 0 and 500")
+	}
+	if q.Candidates <= 0 {
+		q.Candidates = 100
+	}
+	for i, s := range q.Exclude {
+		q.Exclude[i] = strings.ToUpper(s)
+	}
+	for i, s := range q.Include {
+		q.Include[i] = strings.ToUpper(s)
+	}
+	return nil
+}
+
+// splitList 解析逗号分隔的币种列表，去掉 USDT 后缀
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "USDT"); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// hotQueryFromValues 以 base 为默认值解析请求参数，exclude 追加到默认排除列表，include 替换默认列表
+func hotQueryFromValues(base hotQuery, v url.Values) (hotQuery, error) {
+	q := base
+	q.Exclude = slices.Clone(base.Exclude)
+	if s := v.Get("sort"); s != "" {
+		q.Sort = s
+	}
+	if s := v.Get("order"); s != "" {
+		q.Order = s
+	}
+	var err error
+	ints := map[string]*int{"limit": &q.Limit, "spark_length": &q.SparkLength}
+	for name, dst := range ints {
+		if s := v.Get(name); s != "" {
+			if *dst, err = strconv.Atoi(s); err != nil {
+				return q, fmt.Errorf("invalid %s: %w", name, err)
+			}
+		}
+	}
+	if s := v.Get("min_volume"); s != "" {
+		if q.MinVolume, err = strconv.ParseFloat(s, 64); err != nil {
+			return q, fmt.Errorf("invalid min_volume: %w", err)
+		}
+	}
+	if s := v.Get("spark_interval"); s != "" {
+		q.SparkInterval = s
+	}
+	q.Exclude = append(q.Exclude, splitList(v.Get("exclude"))...)
+	if s := v.Get("include"); s != "" {
+		q.Include = splitList(s)
+	}
+	return q, q.validate()
+}
+
+// key 返回参数的规范化表示，用作缓存键
+func (q hotQuery) key() string {
+	exclude, include := slices.Clone(q.Exclude), slices.Clone(q.Include)
+	slices.Sort(exclude)
+	slices.Sort(include)
+	return fmt.Sprintf("%s|%s|%d|%g|%s|%s|%s|%d|%d", q.Sort, q.Order, q.Limit, q.MinVolume,
+		strings.Join(exclude, ","), strings.Join(include, ","), q.SparkInterval, q.SparkLength, q.Candidates)
+}
+
+// loadHotConfig 从 hot.json 读取设置，文件不存在时使用 defaultHotConfig，未设置的字段保留默认值
+func loadHotConfig(filename string) (hotConfig, error) {
+	cfg := defaultHotConfig()
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, err
+	}
+	if cfg.SparkSource != "auto" && cfg.SparkSource != "binance" {
+		return cfg, fmt.Errorf("spark_source must be auto or binance")
+	}
+	// 始终请求币安且未设置 spark_interval 时保持最初的 5m 走势
+	var set struct {
+		SparkInterval *string `json:"spark_interval"`
+	}
+	json.Unmarshal(data, &set)
+	if set.SparkInterval == nil && cfg.SparkSource == "binance" {
+		cfg.SparkInterval = defaultHotQuery().SparkInterval
+	}
+	if cfg.AutoTrack < 0 {
+		return cfg, fmt.Errorf("auto_track must not be negative")
+	}
+	return cfg, cfg.validate()
+}
+
+// ================= 行情数据 =================
+
+// hotTicker 24h 行情中的一个 USDT 交易对
+type hotTicker struct {
+	Symbol      string // 币种，不含 USDT
+	LastPrice   float64
+	Percent     float64
+	QuoteVolume float64
+	FundingRate *float64 // 最近一次资金费率（%），获取失败时为 nil
+}
+
+// fetchHotUniverse 获取全部近期有成交的 USDT 交易对行情和资金费率，资金费率失败只记录日志
+func fetchHotUniverse(ctx context.Context) ([]hotTicker, error) {
+	body, err := binanceGet(ctx, "/fapi/v1/ticker/24hr")
+	if err != nil {
+		return nil, fmt.Errorf("ticker/24hr: %w", err)
+	}
+	value := gjson.ParseBytes(body)
+	if !value.IsArray() {
+		return nil, fmt.Errorf("ticker/24hr: unexpected response %.200s", body)
+	}
+
+	funding := map[string]float64{}
+	if body, err := binanceGet(ctx, "/fapi/v1/premiumIndex"); err != nil {
+		log.Printf("获取资金费率失败: %v", err)
+	} else {
+		for _, v := range gjson.ParseBytes(body).Array() {
+			funding[v.Get("symbol").String()] = v.Get("lastFundingRate").Float() * 100
+		}
+	}
+
+	var tickers []hotTicker
+	for _, symbol := range value.Array() {
+		symbolCoin := symbol.Get("symbol").String()
+		closeTime := symbol.Get("closeTime").Int()
+		if closeTime <= time.Now().Add(-1*time.Hour).UnixMilli() || !strings.HasSuffix(symbolCoin, "USDT") {
+			continue
+		}
+		baseAsset := strings.TrimSuffix(symbolCoin, "USDT")
+		if strings.HasSuffix(baseAsset, "DOWN") || strings.HasSuffix(baseAsset, "UP") {
+			continue
+		}
+		t := hotTicker{
+			Symbol:      baseAsset,
+			LastPrice:   symbol.Get("lastPrice").Float(),
+			Percent:     symbol.Get("priceChangePercent").Float(),
+			QuoteVolume: symbol.Get("quoteVolume").Float(),
+		}
+		if rate, ok := funding[symbolCoin]; ok {
+			t.FundingRate = &rate
+		}
+		tickers = append(tickers, t)
+	}
+	return tickers, nil
+}
+
+// hotMetricCache 逐个请求的指标（成交量放大、ATR%、持仓变化）和走势图缓存
+var hotMetricCache = NewMemoryCache(4096)
+
+// hotMetricTTL 逐个请求的指标的缓存时间
+const hotMetricTTL = 10 * time.Minute
+
+// cachedMetric 读取缓存的指标，没有时调用 fetch 并缓存
+func cachedMetric[T any](key string, ttl time.Duration, fetch func() (T, error)) (T, error) {
+	c := NewTypedCache[T](hotMetricCache, JSONCodec[T]{})
+	if v, ok, _ := c.Get(key); ok {
+		return v, nil
+	}
+	v, err := fetch()
+	if err == nil {
+		c.Set(key, v, ttl)
+	}
+	return v, err
+}
+
+// binanceRows 请求返回二维数组的K线接口
+func binanceRows(ctx context.Context, path string) ([][]gjson.Result, error) {
+	body, err := binanceGet(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]gjson.Result
+	for _, v := range gjson.ParseBytes(body).Array() {
+		if row := v.Array(); len(row) >= 8 {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// volumeSurge 24h 成交额相对前 7 个完整日平均成交额的倍数
+func volumeSurge(ctx context.Context, t hotTicker) (float64, error) {
+	rows, err := binanceRows(ctx, fmt.Sprintf("/fapi/v1/klines?symbol=%sUSDT&interval=1d&limit=8", t.Symbol))
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) < 2 {
+		return 0, errors.New("not enough daily klines")
+	}
+	var sum float64
+	days := rows[:len(rows)-1] // 最后一根为当天未收盘
+	for _, row := range days {
+		sum += row[7].Float()
+	}
+	if sum <= 0 {
+		return 0, errors.New("no volume history")
+	}
+	return t.QuoteVolume / (sum / float64(len(days))), nil
+}
+
+// atrPercent 1h ATR(14) 占最新价格的百分比
+func atrPercent(ctx context.Context, t hotTicker) (float64, error) {
+	rows, err := binanceRows(ctx, fmt.Sprintf("/fapi/v1/klines?symbol=%sUSDT&interval=1h&limit=30", t.Symbol))
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) < 15 {
+		return 0, errors.New("not enough hourly klines")
+	}
+	high, low, closes := make([]float64, len(rows)), make([]float64, len(rows)), make([]float64, len(rows))
+	for i, row := range rows {
+		high[i], low[i], closes[i] = row[2].Float(), row[3].Float(), row[4].Float()
+	}
+	atr := talib.Atr(high, low, closes, 14)
+	last := closes[len(closes)-1]
+	if last <= 0 || math.IsNaN(atr[len(atr)-1]) {
+		return 0, errors.New("invalid atr")
+	}
+	return atr[len(atr)-1] / last * 100, nil
+}
+
+// openInterestChange 最近 24h 持仓量变化百分比
+func openInterestChange(ctx context.Context, t hotTicker) (float64, error) {
+	body, err := binanceGet(ctx, fmt.Sprintf("/futures/data/openInterestHist?symbol=%sUSDT&period=1h&limit=25", t.Symbol))
+	if err != nil {
+		return 0, err
+	}
+	points := gjson.ParseBytes(body).Array()
+	if len(points) < 2 {
+		return 0, errors.New("not enough open interest history")
+	}
+	first := points[0].Get("sumOpenInterest").Float()
+	if first <= 0 {
+		return 0, errors.New("no open interest")
+	}
+	return (points[len(points)-1].Get("sumOpenInterest").Float()/first - 1) * 100, nil
+}
+
+// hotMetrics 需要逐个请求的排序方式
+var hotMetrics = map[string]func(ctx context.Context, t hotTicker) (float64, error){
+	"surge": volumeSurge,
+	"atr":   atrPercent,
+	"oi":    openInterestChange,
+}
+
+// rankHotList 按参数过滤、排序并截取涨幅榜，再为结果获取走势图，db 为 nil 时走势只请求币安；
+// 单个代币的指标或走势获取失败时只记录日志，该代币不参与排序或走势为空
+func rankHotList(ctx context.Context, db *gorm.DB, universe []hotTicker, q hotQuery) HotPairList {
+	var pairs HotPairList
+	for _, t := range universe {
+		if t.QuoteVolume < q.MinVolume || slices.Contains(q.Exclude, t.Symbol) {
+			continue
+		}
+		if len(q.Include) > 0 && !slices.Contains(q.Include, t.Symbol) {
+			continue
+		}
+		p := &HotPair{Symbol: t.Symbol, LastPrice: t.LastPrice, Percent: t.Percent, QuoteVolume: t.QuoteVolume, FundingRate: t.FundingRate}
+		switch q.Sort {
+		case "percent":
+			p.Score = t.Percent
+		case "volume":
+			p.Score = t.QuoteVolume
+		case "funding":
+			if t.FundingRate == nil {
+				continue
+			}
+			p.Score = *t.FundingRate
+		}
+		pairs = append(pairs, p)
+	}
+
+	if fetch, ok := hotMetrics[q.Sort]; ok {
+		// 逐个请求的指标只计算成交额最大的候选代币
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].QuoteVolume > pairs[j].QuoteVolume })
+		if len(pairs) > q.Candidates {
+			pairs = pairs[:q.Candidates]
+		}
+		ok := make([]bool, len(pairs))
+		swg := sizedwaitgroup.New(4)
+		for i, p := range pairs {
+			swg.Add()
+			go func() {
+				defer swg.Done()
+				t := hotTicker{Symbol: p.Symbol, QuoteVolume: p.QuoteVolume}
+				v, err := cachedMetric(q.Sort+":"+p.Symbol, hotMetricTTL, func() (float64, error) { return fetch(ctx, t) })
+				if err != nil {
+					log.Printf("获取 %s 的 %s 失败: %v", p.Symbol, q.Sort, err)
+					return
+				}
+				p.Score, ok[i] = v, true
+			}()
+		}
+		swg.Wait()
+		kept := pairs[:0]
+		for i, p := range pairs {
+			if ok[i] {
+				kept = append(kept, p)
+			}
+		}
+		pairs = kept
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if q.Order == "asc" {
+			return pairs[i].Score < pairs[j].Score
+		}
+		return pairs[i].Score > pairs[j].Score
+	})
+	if len(pairs) > q.Limit {
+		pairs = pairs[:q.Limit]
+	}
+
+	if q.SparkLength > 0 {
+		now := time.Now()
+		swg := sizedwaitgroup.New(4)
+		for _, p := range pairs {
+			if db != nil && hotSettings.SparkSource != "binance" {
+				if list, ok := localSpark(db, p.Symbol, q.SparkInterval, q.SparkLength, now); ok {
+					p.Klines, p.SparkSource = list, "local"
+					continue
+				}
+			}
+			p.SparkSource = "binance"
+			swg.Add()
+			go func() {
+				defer swg.Done()
+				key := fmt.Sprintf("spark:%s:%s:%d", p.Symbol, q.SparkInterval, q.SparkLength)
+				list, err := cachedMetric(key, hotSparkTTL(q.SparkInterval), func() ([]KLine, error) {
+					return CollectTrendWithSymbol(ctx, p.Symbol, q.SparkInterval, q.SparkLength)
+				})
+				if err != nil {
+					log.Printf("获取 %s 走势失败: %v", p.Symbol, err)
+				}
+				p.Klines = list
+			}()
+		}
+		swg.Wait()
+	}
+	return pairs
+}
+
+// localSpark 从本地K线表读取已监控代币的走势，周期不能由15m K线聚合、K线不足 length 根
+// 或最新一根15m K线早于两个15m周期（K线更新停滞）时返回 false，由调用方改为请求币安
+func localSpark(db *gorm.DB, symbol, interval string, length int, now time.Time) ([]KLine, bool) {
+	table := symbol + "USDT"
+	if intervalMillis(interval) == 0 || !isTrackedSymbol(table) {
+		return nil, false
+	}
+	latest := getAggKline(db, table, "15m", 1)
+	if len(latest) == 0 || latest[0].OpenTime < now.Add(-2*15*time.Minute).UnixMilli() {
+		return nil, false
+	}
+	klines := getAggKlineAsc(db, table, interval, length, false)
+	if len(klines) < length {
+		return nil, false
+	}
+	list := make([]KLine, len(klines))
+	for i, k := range klines {
+		list[i] = KLine{Symbol: symbol, Price: k.Close, TimeStamp: k.CloseTime}
+	}
+	return list, true
+}
+
+// hotSparkTTL 走势图的缓存时间，不超过一根K线和一个刷新周期
+func hotSparkTTL(interval string) time.Duration {
+	ttl := time.Duration(binanceIntervalMillis(interval)) * time.Millisecond
+	return max(min(ttl, hotRefreshInterval())-time.Second, time.Second)
+}
+
+// binanceIntervalMillis 返回币安K线周期的毫秒数
+func binanceIntervalMillis(interval string) int64 {
+	if strings.HasSuffix(interval, "d") {
+		n, _ := strconv.Atoi(strings.TrimSuffix(interval, "d"))
+		return int64(n) * 24 * time.Hour.Milliseconds()
+	}
+	d, _ := time.ParseDuration(interval)
+	return d.Milliseconds()
+}
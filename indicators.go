@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/markcheno/go-talib"
+	"gorm.io/gorm"
+)
+
+// indicatorSpec 描述一个可按名称调用的技术指标
+type indicatorSpec struct {
+	Defaults []float64                                // 默认参数
+	Outputs  []string                                 // 输出序列名称，第一个为主输出
+	Lookback func(p []float64) int                    // 预热期长度，之前的值无效
+	Compute  func(k []Kline, p []float64) [][]float64 // 按 Outputs 顺序返回与K线对齐的序列
+}
+
+// indicatorRegistry 所有支持的指标，K线需按时间升序传入
+var indicatorRegistry = map[string]indicatorSpec{
+	"ema": {
+		Defaults: []float64{20},
+		Outputs:  []string{"ema"},
+		Lookback: func(p []float64) int { return int(p[0]) - 1 },
+		Compute: func(k []Kline, p []float64) [][]float64 {
+			return [][]float64{talib.Ema(klineCloses(k), int(p[0]))}
+		},
+	},
+	"sma": {
+		Defaults: []float64{20},
+		Outputs:  []string{"sma"},
+		Lookback: func(p []float64) int { return int(p[0]) - 1 },
+		Compute: func(k []Kline, p []float64) [][]float64 {
+			return [][]float64{talib.Sma(klineCloses(k), int(p[0]))}
+		},
+	},
+	"rsi": {
+		Defaults: []float64{14},
+		Outputs:  []string{"rsi"},
+		Lookback: func(p []float64) int { return int(p[0]) },
+		Compute: func(k []Kline, p []float64) [][]float64 {
+			return [][]float64{talib.Rsi(klineCloses(k), int(p[0]))}
+		},
+	},
+	"macd": {
+		Defaults: []float64{12, 26, 9},
+		Outputs:  []string{"macd", "signal", "hist"},
+		Lookback: func(p []float64) int { return int(math.Max(p[0], p[1])) + int(p[2]) - 2 },
+		Compute: func(k []Kline, p []float64) [][]float64 {
+			macd, signal, hist := talib.Macd(klineCloses(k), int(p[0]), int(p[1]), int(p[2]))
+			return [][]float64{macd, signal, hist}
+		},
+	},
+	"bbands": {
+		Defaults: []float64{20, 2, 2},
+		Outputs:  []string{"upper", "middle", "lower"},
+		Lookback: func(p []float64) int { return int(p[0]) - 1 },
+		Compute: func(k []Kline, p []float64) [][]float64 {
+			upper, middle, lower := talib.BBands(klineCloses(k), int(p[0]), p[1], p[2], talib.SMA)
+			return [][]float64{upper, middle, lower}
+		},
+	},
+	"atr": {
+		Defaults: []float64{14},
+		Outputs:  []string{"atr"},
+		Lookback: func(p []float64) int { return int(p[0]) },
+		Compute: func(k []Kline, p []float64) [][]float64 {
+			high, low, closes := klineHLC(k)
+			return [][]float64{talib.Atr(high, low, closes, int(p[0]))}
+		},
+	},
+	"stoch": {
+		Defaults: []float64{14, 3, 3},
+		Outputs:  []string{"k", "d"},
+		Lookback: func(p []float64) int { return int(p[0]) + int(p[1]) + int(p[2]) - 3 },
+		Compute: func(k []Kline, p []float64) [][]float64 {
+			high, low, closes := klineHLC(k)
+			slowK, slowD := talib.Stoch(high, low, closes, int(p[0]), int(p[1]), talib.SMA, int(p[2]), talib.SMA)
+			return [][]float64{slowK, slowD}
+		},
+	},
+	"obv": {
+		Outputs:  []string{"obv"},
+		Lookback: func(p []float64) int { return 0 },
+		Compute: func(k []Kline, p []float64) [][]float64 {
+			volumes := make([]float64, len(k))
+			for i, kline := range k {
+				volumes[i] = kline.Volume
+			}
+			return [][]float64{talib.Obv(klineCloses(k), volumes)}
+		},
+	},
+	"roc": {
+		// 相对 N 根之前收盘价的涨跌幅（%）
+		Defaults: []float64{1},
+		Outputs:  []string{"roc"},
+		Lookback: func(p []float64) int { return int(p[0]) },
+		Compute: func(k []Kline, p []float64) [][]float64 {
+			return [][]float64{talib.Roc(klineCloses(k), int(p[0]))}
+		},
+	},
+	"qvol": {
+		// 最近 N 根K线的成交额（收盘价 × 成交量）之和，15m 周期下 96 根为 24h
+		Defaults: []float64{96},
+		Outputs:  []string{"qvol"},
+		Lookback: func(p []float64) int { return int(p[0]) - 1 },
+		Compute: func(k []Kline, p []float64) [][]float64 {
+			return [][]float64{rollingQuoteVolume(k, int(p[0]))}
+		},
+	},
+	"vwap": {
+		// 参数为滚动窗口长度，0 表示从序列起点累计
+		Defaults: []float64{0},
+		Outputs:  []string{"vwap"},
+		Lookback: func(p []float64) int { return int(math.Max(p[0]-1, 0)) },
+		Compute: func(k []Kline, p []float64) [][]float64 {
+			return [][]float64{rollingVWAP(k, int(p[0]))}
+		},
+	},
+}
+
+// klineCloses 提取收盘价序列
+func klineCloses(klines []Kline) []float64 {
+	closes := make([]float64, len(klines))
+	for i, k := range klines {
+		closes[i] = k.Close
+	}
+	return closes
+}
+
+// klineHLC 提取最高价、最低价和收盘价序列
+func klineHLC(klines []Kline) (high, low, closes []float64) {
+	high = make([]float64, len(klines))
+	low = make([]float64, len(klines))
+	closes = make([]float64, len(klines))
+	for i, k := range klines {
+		high[i], low[i], closes[i] = k.High, k.Low, k.Close
+	}
+	return
+}
+
+// rollingQuoteVolume 滚动窗口内的成交额之和
+func rollingQuoteVolume(klines []Kline, period int) []float64 {
+	out := make([]float64, len(klines))
+	var sum float64
+	for i, k := range klines {
+		sum += k.Close * k.Volume
+		if i >= period {
+			sum -= klines[i-period].Close * klines[i-period].Volume
+		}
+		out[i] = sum
+	}
+	return out
+}
+
+// rollingVWAP 以典型价 (H+L+C)/3 计算成交量加权均价，period 为 0 时从起点累计
+func rollingVWAP(klines []Kline, period int) []float64 {
+	out := make([]float64, len(klines))
+	var pv, vol float64
+	for i, k := range klines {
+		typical := (k.High + k.Low + k.Close) / 3
+		pv += typical * k.Volume
+		vol += k.Volume
+		if period > 0 && i >= period {
+			old := klines[i-period]
+			pv -= (old.High + old.Low + old.Close) / 3 * old.Volume
+			vol -= old.Volume
+		}
+		if vol > 0 {
+			out[i] = pv / vol
+		} else {
+			out[i] = typical
+		}
+	}
+	return out
+}
+
+// computeIndicator 计算指定指标，返回按输出名称索引的序列和实际使用的参数
+// 预热期以及数据不足时的值为 NaN
+func computeIndicator(name string, klines []Kline, params []float64) (map[string][]float64, []float64, error) {
+	name = strings.ToLower(name)
+	spec, ok := indicatorRegistry[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown indicator: %s", name)
+	}
+	if len(params) > len(spec.Defaults) {
+		return nil, nil, fmt.Errorf("%s accepts at most %d params", name, len(spec.Defaults))
+	}
+	used := append([]float64{}, spec.Defaults...)
+	copy(used, params)
+	for i, v := range used {
+		// bbands 的标准差倍数可以是小数，其余参数均为周期
+		if name == "bbands" && i > 0 {
+			if v <= 0 {
+				return nil, nil, fmt.Errorf("%s param %d must be positive", name, i+1)
+			}
+			continue
+		}
+		if v != math.Trunc(v) || v < 0 || (v == 0 && name != "vwap") {
+			return nil, nil, fmt.Errorf("%s param %d must be a positive integer", name, i+1)
+		}
+	}
+
+	result := make(map[string][]float64, len(spec.Outputs))
+	lookback := spec.Lookback(used)
+	if len(klines) <= lookback || len(klines) == 0 {
+		for _, out := range spec.Outputs {
+			result[out] = nanSeries(len(klines))
+		}
+		return result, used, nil
+	}
+	for i, series := range spec.Compute(klines, used) {
+		for j := 0; j < lookback && j < len(series); j++ {
+			series[j] = math.NaN()
+		}
+		result[spec.Outputs[i]] = series
+	}
+	return result, used, nil
+}
+
+// nanSeries 返回长度为 n 且全部为 NaN 的序列
+func nanSeries(n int) []float64 {
+	s := make([]float64, n)
+	for i := range s {
+		s[i] = math.NaN()
+	}
+	return s
+}
+
+// parseIndicatorParams 解析形如 "12,26,9" 的参数列表
+func parseIndicatorParams(raw string) ([]float64, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	parts := strings.Split(raw, ",")
+	params := make([]float64, 0, len(parts))
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid param %q", p)
+		}
+		params = append(params, v)
+	}
+	return params, nil
+}
+
+// jsonSeries 序列化时将 NaN 输出为 null
+type jsonSeries []float64
+
+func (s jsonSeries) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, v := range s {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			b.WriteString("null")
+		} else {
+			b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+	b.WriteByte(']')
+	return []byte(b.String()), nil
+}
+
+type indicatorResult struct {
+	Name   string                `json:"name"`
+	Params []float64             `json:"params"`
+	Values map[string]jsonSeries `json:"values"`
+}
+
+type indicatorResponse struct {
+	Symbol     string            `json:"symbol"`
+	Interval   string            `json:"interval"`
+	Time       []int64           `json:"time"`
+	Indicators []indicatorResult `json:"indicators"`
+}
+
+// indicatorMaxLimit /indicators 单次返回的K线数量上限
+const indicatorMaxLimit = 1000
+
+// handleIndicators 返回与K线开盘时间对齐的指标序列
+// 多个指标通过重复的 name/params 参数传入，params 按出现顺序与 name 对应
+// 例如 /indicators?symbol=BTCUSDT&interval=15m&name=macd&params=12,26,9&name=ema&params=144
+func handleIndicators(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		q := r.URL.Query()
+		symbol := q.Get("symbol")
+		interval := q.Get("interval")
+		names := q["name"]
+		if symbol == "" || interval == "" || len(names) == 0 {
+			http.Error(w, "missing symbol, interval or name", http.StatusBadRequest)
+			return
+		}
+		if !isTrackedSymbol(symbol) {
+			http.Error(w, "unknown symbol", http.StatusNotFound)
+			return
+		}
+		if intervalMillis(interval) == 0 {
+			http.Error(w, "unsupported interval", http.StatusBadRequest)
+			return
+		}
+		limit, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 300
+		}
+		if limit > indicatorMaxLimit {
+			http.Error(w, fmt.Sprintf("limit must be at most %d", indicatorMaxLimit), http.StatusBadRequest)
+			return
+		}
+		closedOnly := q.Get("closed") == "1" || q.Get("closed") == "true"
+
+		klines := getAggKlineAsc(db, symbol, interval, limit, closedOnly)
+		resp := indicatorResponse{
+			Symbol:     symbol,
+			Interval:   interval,
+			Time:       make([]int64, len(klines)),
+			Indicators: make([]indicatorResult, 0, len(names)),
+		}
+		for i, k := range klines {
+			resp.Time[i] = k.OpenTime
+		}
+
+		rawParams := q["params"]
+		for i, name := range names {
+			var raw string
+			if i < len(rawParams) {
+				raw = rawParams[i]
+			}
+			params, err := parseIndicatorParams(raw)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			values, used, err := computeIndicator(name, klines, params)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			item := indicatorResult{Name: strings.ToLower(name), Params: used, Values: make(map[string]jsonSeries, len(values))}
+			for out, series := range values {
+				item.Values[out] = series
+			}
+			resp.Indicators = append(resp.Indicators, item)
+		}
+
+		writeJSON(w, r, resp)
+	}
+}
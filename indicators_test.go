@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fixtureKlines 生成确定性的15m K线：上行趋势叠加两组周期波动和随机噪声
+func fixtureKlines(n int, seed int64) []Kline {
+	rng := rand.New(rand.NewSource(seed))
+	klines := make([]Kline, n)
+	start := int64(1_700_000_000_000)
+	prev := 100.0
+	for i := 0; i < n; i++ {
+		x := float64(i)
+		price := 100 * math.Exp(0.0006*x) * (1 + 0.03*math.Sin(x/40) + 0.012*math.Sin(x/9)) * (1 + 0.002*rng.NormFloat64())
+		openTime := start + int64(i)*15*60*1000
+		klines[i] = Kline{
+			Symbol:    "TESTUSDT",
+			OpenTime:  openTime,
+			Open:      prev,
+			High:      math.Max(prev, price) * 1.001,
+			Low:       math.Min(prev, price) * 0.999,
+			Close:     price,
+			Volume:    1000 + 100*rng.Float64(),
+			CloseTime: openTime + 15*60*1000 - 1,
+			IsClosed:  true,
+		}
+		prev = price
+	}
+	return klines
+}
+
+func TestComputeIndicatorOutputs(t *testing.T) {
+	klines := fixtureKlines(200, 3)
+	for name, spec := range indicatorRegistry {
+		values, used, err := computeIndicator(name, klines, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !slices.Equal(used, spec.Defaults) {
+			t.Errorf("%s: expected default params %v, got %v", name, spec.Defaults, used)
+		}
+		if len(values) != len(spec.Outputs) {
+			t.Errorf("%s: expected outputs %v, got %d series", name, spec.Outputs, len(values))
+		}
+		// 预热期为 NaN，之后为有效值
+		lookback := spec.Lookback(used)
+		for _, out := range spec.Outputs {
+			series, ok := values[out]
+			if !ok || len(series) != len(klines) {
+				t.Errorf("%s: missing or misaligned output %s", name, out)
+				continue
+			}
+			for i := 0; i < lookback; i++ {
+				if !math.IsNaN(series[i]) {
+					t.Errorf("%s.%s[%d] should be NaN inside the lookback, got %v", name, out, i, series[i])
+					break
+				}
+			}
+			if math.IsNaN(series[lookback]) || math.IsNaN(series[len(series)-1]) {
+				t.Errorf("%s.%s should be valid after the lookback", name, out)
+			}
+		}
+	}
+
+	want := map[string][]string{
+		"macd":   {"macd", "signal", "hist"},
+		"bbands": {"upper", "middle", "lower"},
+		"stoch":  {"k", "d"},
+		"vwap":   {"vwap"},
+	}
+	for name, outputs := range want {
+		if !slices.Equal(indicatorRegistry[name].Outputs, outputs) {
+			t.Errorf("%s outputs = %v, want %v", name, indicatorRegistry[name].Outputs, outputs)
+		}
+	}
+
+	// 数据不足预热期时全部为 NaN
+	values, _, err := computeIndicator("MACD", klines[:30], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for out, series := range values {
+		if len(series) != 30 || slices.ContainsFunc(series, func(v float64) bool { return !math.IsNaN(v) }) {
+			t.Fatalf("macd.%s should be all NaN with too few bars: %v", out, series)
+		}
+	}
+}
+
+func TestComputeIndicatorParams(t *testing.T) {
+	klines := fixtureKlines(50, 3)
+	tests := []struct {
+		name   string
+		params []float64
+		used   []float64
+		err    string
+	}{
+		{"ema", []float64{5}, []float64{5}, ""},
+		{"macd", []float64{8}, []float64{8, 26, 9}, ""},
+		{"bbands", []float64{20, 1.5}, []float64{20, 1.5, 2}, ""},
+		{"vwap", []float64{0}, []float64{0}, ""},
+		{"obv", nil, []float64{}, ""},
+		{"ema", []float64{5, 10}, nil, "accepts at most 1 params"},
+		{"obv", []float64{1}, nil, "accepts at most 0 params"},
+		{"ema", []float64{2.5}, nil, "param 1 must be a positive integer"},
+		{"sma", []float64{0}, nil, "param 1 must be a positive integer"},
+		{"rsi", []float64{-3}, nil, "param 1 must be a positive integer"},
+		{"bbands", []float64{20, 0}, nil, "param 2 must be positive"},
+		{"bbands", []float64{20.5}, nil, "param 1 must be a positive integer"},
+		{"kdj", nil, nil, "unknown indicator"},
+	}
+	for _, tt := range tests {
+		_, used, err := computeIndicator(tt.name, klines, tt.params)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s %v: expected error %q, got %v", tt.name, tt.params, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(used, tt.used) {
+			t.Errorf("%s %v: got %v %v, want %v", tt.name, tt.params, used, err, tt.used)
+		}
+	}
+
+	if params, err := parseIndicatorParams(" 12, 26 ,9"); err != nil || !slices.Equal(params, []float64{12, 26, 9}) {
+		t.Fatalf("unexpected params: %v %v", params, err)
+	}
+	if params, err := parseIndicatorParams(""); err != nil || params != nil {
+		t.Fatalf("empty params should use defaults: %v %v", params, err)
+	}
+	if _, err := parseIndicatorParams("12,,9"); err == nil {
+		t.Fatal("expected error for an empty param")
+	}
+}
+
+func TestRollingVWAP(t *testing.T) {
+	// 典型价依次为 10、13、7、30，最后一根没有成交
+	klines := []Kline{
+		{High: 12, Low: 9, Close: 9, Volume: 1},
+		{High: 15, Low: 12, Close: 12, Volume: 2},
+		{High: 9, Low: 6, Close: 6, Volume: 1},
+		{High: 30, Low: 30, Close: 30, Volume: 0},
+	}
+	tests := []struct {
+		period int
+		want   []float64
+	}{
+		{0, []float64{10, 12, 10.75, 10.75}},
+		{2, []float64{10, 12, 11, 7}},
+		{1, []float64{10, 13, 7, 30}},
+	}
+	for _, tt := range tests {
+		got := rollingVWAP(klines, tt.period)
+		for i := range got {
+			if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+				t.Errorf("period %d: got %v, want %v", tt.period, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestHandleIndicators(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSymbols := symbols
+	t.Cleanup(func() { symbols = oldSymbols })
+	symbols = []string{"TESTUSDT"}
+	klines := fixtureKlines(100, 4)
+	table := Kline{Symbol: "TESTUSDT"}.TableName()
+	if err := db.Table(table).AutoMigrate(&Kline{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Table(table).Create(klines)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleIndicators(db)(rec, httptest.NewRequest(http.MethodGet, "/indicators?"+query, nil))
+		return rec
+	}
+	rec := get("symbol=TESTUSDT&interval=15m&limit=60&name=MACD&params=12,26,9&name=ema&params=5&name=vwap")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Time       []int64
+		Indicators []struct {
+			Name   string
+			Params []float64
+			Values map[string][]*float64
+		}
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Time) != 60 || resp.Time[59] != klines[99].OpenTime || len(resp.Indicators) != 3 {
+		t.Fatalf("unexpected response: %s", rec.Body)
+	}
+	// 预热期输出为 null，vwap 未传参数时使用默认值
+	lookbacks := []int{33, 4, 0}
+	for i, ind := range resp.Indicators {
+		spec := indicatorRegistry[ind.Name]
+		if len(ind.Values) != len(spec.Outputs) {
+			t.Fatalf("%s: unexpected outputs %v", ind.Name, ind.Values)
+		}
+		for out, series := range ind.Values {
+			if len(series) != 60 {
+				t.Fatalf("%s.%s should align with time", ind.Name, out)
+			}
+			for j, v := range series {
+				if (v == nil) != (j < lookbacks[i]) {
+					t.Fatalf("%s.%s[%d] = %v, lookback %d", ind.Name, out, j, v, lookbacks[i])
+				}
+			}
+		}
+	}
+	if resp.Indicators[0].Name != "macd" || !slices.Equal(resp.Indicators[2].Params, []float64{0}) {
+		t.Fatalf("unexpected names or params: %+v", resp.Indicators)
+	}
+
+	for query, code := range map[string]int{
+		"interval=15m&name=ema":                                  http.StatusBadRequest,
+		"symbol=TESTUSDT&interval=15m":                           http.StatusBadRequest,
+		"symbol=ETHUSDT&interval=15m&name=ema":                   http.StatusNotFound,
+		"symbol=TESTUSDT&interval=2m&name=ema":                   http.StatusBadRequest,
+		"symbol=TESTUSDT&interval=15m&name=kdj":                  http.StatusBadRequest,
+		"symbol=TESTUSDT&interval=15m&name=ema&params=5,10":      http.StatusBadRequest,
+		"symbol=TESTUSDT&interval=15m&name=ema&limit=1001":       http.StatusBadRequest,
+		"symbol=TESTUSDT&interval=15m&name=ema&params=x":         http.StatusBadRequest,
+		"symbol=TESTUSDT&interval=15m&name=ema&params=2.5":       http.StatusBadRequest,
+		"symbol=TESTUSDT&interval=15m&name=ema&name=sma&params=": http.StatusOK,
+	} {
+		if rec := get(query); rec.Code != code {
+			t.Errorf("%s: expected %d, got %d %s", query, code, rec.Code, rec.Body)
+		}
+	}
+}
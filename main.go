@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -40,7 +42,8 @@ func updateKlines(db *gorm.DB, symbol string) error {
 	res := db.Table(kline.TableName()).Where("open_time > ?", last_open_time).Order("open_time DESC").Limit(1).Find(&last)
 
 	var startTime int64
-	var limitCount int = 49
+	// 一天内的K线最多 96 根，足以覆盖最早一根未确认收盘的K线
+	var limitCount int = 99
 	if res.RowsAffected == 0 {
 		limitCount = 999
 		// 没有数据，从当前时间回溯10天
@@ -57,6 +60,11 @@ func updateKlines(db *gorm.DB, symbol string) error {
 		// } else {
 		// return nil // 最新数据足够
 		// }
+		// 从最早一根尚未确认收盘的K线开始，写入时仍在进行中的K线收盘后会被覆盖
+		var pending Kline
+		if db.Table(kline.TableName()).Where("open_time > ? AND is_closed = ?", last_open_time, false).Order("open_time ASC").Limit(1).Find(&pending).RowsAffected > 0 {
+			startTime = min(startTime, pending.OpenTime)
+		}
 	}
 
 	klines, err := fetchBinanceKlines(symbol, "15m", startTime, 0, limitCount)
@@ -64,20 +72,11 @@ func updateKlines(db *gorm.DB, symbol string) error {
 		return err
 	}
 
-	for _, k := range klines {
-		k.Symbol = symbol // 确保kline记录包含symbol信息
-		var existing Kline
-		if err := db.Table(kline.TableName()).Where("open_time = ?", k.OpenTime).First(&existing).Error; err == nil {
-			// 如果未收盘，则更新
-			if existing.CloseTime > time.Now().UnixMilli() {
-				db.Table(kline.TableName()).Model(&existing).Updates(k)
-			}
-		} else {
-			// 新增
-			db.Table(kline.TableName()).Create(&k)
-		}
+	changed, err := saveKlines(db, symbol, klines, time.Now().UnixMilli())
+	if changed > 0 {
+		bumpDataVersion(symbol)
 	}
-	return nil
+	return err
 }
 
 var botToken, chatID string
@@ -89,6 +88,7 @@ func init() {
 	}
 	botToken = os.Getenv("TELEGRAM_BOT_TOKEN")
 	chatID = os.Getenv("TELEGRAM_CHAT_ID")
+	paper = loadPaperConfig()
 }
 
 // ================= 主程序 =================
@@ -119,11 +119,33 @@ func main() {
 	}
 	sqlDB.SetMaxIdleConns(10)
 	sqlDB.SetMaxOpenConns(100)
+	// 按 CACHE_BACKEND 打开缓存，默认使用 ./cache_data 下的 ledisdb
+	cache, err = newCache(cacheConfigFromEnv())
+	if err != nil {
+		log.Fatal("打开缓存失败: ", err)
+	}
+	defer cache.Close()
 	// 从 symbols.json 读取 symbols
-	symbols, err = loadSymbolsFromFile("symbols.json")
+	symbols, err = loadSymbolsFromFile(symbolsFile)
 	if err != nil {
 		return
 	}
+	// 从 rules.json 读取信号规则，文件不存在时使用默认规则
+	signalRules, err = loadRulesFromFile("rules.json")
+	if err != nil {
+		log.Fatal("读取 rules.json 失败: ", err)
+	}
+	// 从 hot.json 读取涨幅榜的默认排序和过滤，文件不存在时按 24h 涨幅排序
+	hotSettings, err = loadHotConfig("hot.json")
+	if err != nil {
+		log.Fatal("读取 hot.json 失败: ", err)
+	}
+	hotPairs.db, hotPairs.track = db, time.Duration(hotSettings.AutoTrack)
+	// 从 notify.json 读取通知渠道和路由，文件不存在时使用环境变量
+	notifier, err = loadNotifyConfig("notify.json")
+	if err != nil {
+		log.Fatal("读取 notify.json 失败: ", err)
+	}
 	// for _, symbol := range symbols {
 	// 	db.Exec(fmt.Sprintf("DROP INDEX idx_kline_%s_symbol_open_time", symbol))
 	// 	// db.Migrator().DropIndex(fmt.Sprintf("idx_kline_%s_symbol_open_time", symbol), symbol)
@@ -134,25 +156,36 @@ func main() {
 	// return
 	// 遍历所有代币
 	for _, symbol := range symbols {
-		// 创建一个带有symbol的Kline实例，用于获取表名
-		kline := Kline{Symbol: symbol}
-
-		// 确保表存在
-		if err := db.Table(kline.TableName()).AutoMigrate(&Kline{}); err != nil {
-			log.Printf("自动迁移表 %s 失败: %v", kline.TableName(), err)
-			continue
-		}
-
-		// 动态创建联合索引
-		if err := createIndexForKlineTable(db, kline.TableName()); err != nil {
-			log.Printf("为表 %s 创建索引失败: %v", kline.TableName(), err)
-			// 不中断流程，继续处理下一个symbol
+		if err := ensureKlineTable(db, symbol); err != nil {
+			log.Println(err)
 		}
 	}
+	if err := migratePaperTables(db); err != nil {
+		log.Fatal("创建模拟交易表失败:", err)
+	}
+	if err := migrateAlertTables(db); err != nil {
+		log.Fatal("创建提醒记录表失败:", err)
+	}
+	if err := migrateThrottleTables(db); err != nil {
+		log.Fatal("创建提醒策略表失败:", err)
+	}
+	if err := migrateDataQualityTables(db); err != nil {
+		log.Fatal("创建数据问题表失败:", err)
+	}
 	// 检查命令行参数
 	// if err := migrateFromUnifiedTable(db); err != nil {
 	// 	log.Fatal("数据迁移失败:", err)
 	// }
+	if len(os.Args) > 1 {
+		if err := runCommand(db, os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// 信号检查调度：在每个K线收盘边界之后执行
+	scheduler := NewSignalScheduler(db, signalRules)
+	dataQuality := &dataQualityMonitor{db: db, cfg: loadDataQualityConfig()}
 
 	// 启动 HTTP 服务
 	go func() {
@@ -168,7 +201,24 @@ func main() {
 		}
 		http.HandleFunc("/klines", handleKlineQuery(db))
 		http.HandleFunc("/symbols", handleSymbols())
-		http.HandleFunc("/hot", handleHotSymbols())
+		http.HandleFunc("/hot", handleHotSymbols(hotPairs))
+		http.HandleFunc("/indicators", handleIndicators(db))
+		http.HandleFunc("/screener", handleScreener(db))
+		http.HandleFunc("/analytics/correlation", handleCorrelation(db))
+		http.HandleFunc("/analytics/strength", handleStrength(db))
+		http.HandleFunc("/analytics/profile", handleVolumeProfile(db))
+		http.HandleFunc("/analytics/vwap", handleVWAP(db))
+		http.HandleFunc("/signals/status", handleSignalStatus(scheduler))
+		http.HandleFunc("/health/data", handleDataHealth(db, dataQuality))
+		http.HandleFunc("/health/data/repair", handleDataRepair(db, dataQuality))
+		http.HandleFunc("/divergences", handleDivergences(db))
+		http.HandleFunc("/backtest", handleBacktest(db))
+		http.HandleFunc("/chart.png", handleChartPNG(db))
+		http.HandleFunc("/alerts", handleAlerts(db))
+		http.HandleFunc("/alerts/stats", handleAlertStats(db))
+		http.HandleFunc("/paper/positions", handlePaperPositions(db))
+		http.HandleFunc("/paper/trades", handlePaperTrades(db))
+		http.HandleFunc("/paper/equity", handlePaperEquity(db))
 		http.HandleFunc("/stream", wp.Proxy) //proxy.ServeHTTP
 		log.Println("HTTP server started on :3000")
 		if err := http.ListenAndServe(":3000", nil); err != nil {
@@ -186,28 +236,77 @@ func main() {
 		// 	}
 		// }
 		for {
-			if err := processSymbols(symbols, db); err != nil {
+			if err := processSymbols(trackedSymbols(), db); err != nil {
 				log.Println("部分任务失败:", err)
 			}
 		}
 	}()
 
-	// 定时任务：每5分钟检查一次MACD水上金叉
-	// go func() {
-	// 	ticker := time.NewTicker(5 * time.Minute)
-	// 	defer ticker.Stop()
-
-	// 	for range ticker.C {
-
-	// 		// if err := CheckAllSymbolsMACDBullishCross(db); err != nil {
-	// 		// 	log.Printf("检查MACD水上金叉失败: %v", err)
-	// 		// }
-	// 	}
-	// }()
+	scheduler.Start()
+	startPaperDailySummary(db, loc)
+	startAlertEnricher(db)
+	startNotifyPolicy(db)
+	dataQuality.start()
+	hotPairs.start(context.Background())
+	if bot := NewTelegramBotFromEnv(db); bot != nil {
+		go bot.Run(context.Background())
+	}
 	clean(db)
 	select {}
 }
 
+// runCommand 执行子命令，执行完毕后程序退出
+func runCommand(db *gorm.DB, name string, args []string) error {
+	switch name {
+	case "backtest":
+		return runBacktestCommand(db, args)
+	case "optimize":
+		return runOptimizeCommand(db, args)
+	}
+	return fmt.Errorf("unknown command: %s", name)
+}
+
+// ensureKlineTable 创建代币的K线表和联合索引
+func ensureKlineTable(db *gorm.DB, symbol string) error {
+	// 创建一个带有symbol的Kline实例，用于获取表名
+	kline := Kline{Symbol: symbol}
+
+	// 已有 is_closed 列但仍为 NULL 的K线先补齐，之后该列不允许为空
+	dayAgo := time.Now().Add(-24 * time.Hour).UnixMilli()
+	hadColumn := db.Table(kline.TableName()).Migrator().HasColumn(&Kline{}, "is_closed")
+	if hadColumn {
+		if err := backfillKlineClosed(db, kline.TableName(), "is_closed IS NULL", dayAgo); err != nil {
+			return err
+		}
+	}
+
+	// 确保表存在
+	if err := db.Table(kline.TableName()).AutoMigrate(&Kline{}); err != nil {
+		return fmt.Errorf("自动迁移表 %s 失败: %w", kline.TableName(), err)
+	}
+
+	// 动态创建联合索引
+	if err := createIndexForKlineTable(db, kline.TableName()); err != nil {
+		return fmt.Errorf("为表 %s 创建索引失败: %w", kline.TableName(), err)
+	}
+
+	// 刚新增 is_closed 列的表，已有K线均取了默认值
+	if !hadColumn {
+		return backfillKlineClosed(db, kline.TableName(), "1 = 1", dayAgo)
+	}
+	return nil
+}
+
+// backfillKlineClosed 为新增 is_closed 列之前写入的K线设置收盘状态：
+// 一天前的视为已收盘，最近一天的由 updateKlines 重新拉取确认
+func backfillKlineClosed(db *gorm.DB, tableName, where string, dayAgo int64) error {
+	err := db.Exec(fmt.Sprintf("UPDATE %s SET is_closed = (close_time < ?) WHERE %s", tableName, where), dayAgo).Error
+	if err != nil {
+		return fmt.Errorf("更新表 %s 的收盘状态失败: %w", tableName, err)
+	}
+	return nil
+}
+
 func processSymbols(symbols []string, db *gorm.DB) error {
 	var g errgroup.Group
 	sem := make(chan struct{}, 3) // 限制并行 4 个
@@ -224,6 +323,9 @@ func processSymbols(symbols []string, db *gorm.DB) error {
 				return err
 			}
 			log.Println("updated", sym)
+			if err := updatePaperPositions(db, sym); err != nil {
+				log.Println("paper update error:", sym, err)
+			}
 			time.Sleep(time.Millisecond * 400)
 			return nil
 		})
@@ -241,7 +343,7 @@ func clean(db *gorm.DB) {
 		for {
 			<-ticker.C
 			// 从 symbols.json 读取 symbols
-			symbols, err := loadSymbolsFromFile("symbols.json")
+			symbols, err := loadSymbolsFromFile(symbolsFile)
 			if err != nil {
 				log.Printf("读取 symbols.json 失败: %v", err)
 				continue
@@ -261,9 +363,9 @@ func clean(db *gorm.DB) {
 				continue
 			}
 
-			// 3️⃣ 删除不在 symbols.json 列表中的表
+			// 3️⃣ 删除不在 symbols.json 列表中的K线表，其他业务表保留
 			for _, table := range tables {
-				if _, ok := symbolSet[table]; !ok && table != "sqlite_sequence" {
+				if _, ok := symbolSet[table]; !ok && strings.HasPrefix(table, "kline_") {
 					log.Printf("删除表: %s", table)
 					if err := db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS `%s`", table)).Error; err != nil {
 						log.Printf("删除表 %s 失败: %v", table, err)
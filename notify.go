@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net/http"
+	"net/smtp"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ================= 通知渠道 =================
+
+// Notification 一条待发送的通知
+type Notification struct {
+	Rule    string   `json:"rule"`              // 触发的规则名称，用于路由，系统消息为空
+	Title   string   `json:"title"`             // 标题，邮件主题
+	Text    string   `json:"text"`              // 完整正文
+	Symbols []string `json:"symbols,omitempty"` // 涉及的代币
+	Time    int64    `json:"time"`              // 生成时间（毫秒）
+	// 规则提醒的模板数据，路由按渠道渲染后写入 Text
+	Message *AlertMessage `json:"message,omitempty"`
+	// Text 的格式，见 formatPlain/formatHTML/formatMarkdownV2
+	Format string `json:"-"`
+	// 附带的图片，目前只有 Telegram 渠道发送
+	Images []NotificationImage `json:"-"`
+}
+
+// NotificationImage 通知附带的 PNG 图片
+type NotificationImage struct {
+	Name    string
+	Caption string
+	Data    []byte
+}
+
+// Notifier 通知渠道
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, n Notification) error
+}
+
+// formattedNotifier 支持富文本的渠道，Format 返回模板应输出的格式
+type formattedNotifier interface {
+	Format() string
+}
+
+// channelFormat 返回渠道接受的正文格式，不支持富文本的渠道为纯文本
+func channelFormat(ch Notifier) string {
+	if f, ok := ch.(formattedNotifier); ok {
+		return f.Format()
+	}
+	return formatPlain
+}
+
+// notifyHTTPClient 所有 HTTP 渠道共用的客户端
+var notifyHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// httpStatusError 渠道返回了非 2xx 状态码
+type httpStatusError struct {
+	Status int
+	Body   string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("状态码: %d %s", e.Status, e.Body)
+}
+
+// retryable 网络错误、429 和 5xx 可以重试，其余 4xx 重试也不会成功
+func retryable(err error) bool {
+	var se *httpStatusError
+	if errors.As(err, &se) {
+		return se.Status == http.StatusTooManyRequests || se.Status >= 500
+	}
+	return true
+}
+
+// postJSON 发送 JSON 请求，非 2xx 时返回 httpStatusError
+func postJSON(ctx context.Context, url string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := notifyHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &httpStatusError{Status: resp.StatusCode, Body: string(msg)}
+	}
+	return nil
+}
+
+// DiscordNotifier 通过 Discord webhook 发送消息
+type DiscordNotifier struct {
+	URL string `json:"url"`
+}
+
+func (d *DiscordNotifier) Name() string { return "discord" }
+
+func (d *DiscordNotifier) Notify(ctx context.Context, n Notification) error {
+	// Discord 单条消息最多 2000 字符
+	text := []rune(n.Text)
+	if len(text) > 2000 {
+		text = text[:2000]
+	}
+	body, err := json.Marshal(map[string]string{"content": string(text)})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, d.URL, body, nil)
+}
+
+// SlackNotifier 通过 Slack incoming webhook 发送消息
+type SlackNotifier struct {
+	URL string `json:"url"`
+}
+
+func (s *SlackNotifier) Name() string { return "slack" }
+
+func (s *SlackNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(map[string]string{"text": n.Text})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, s.URL, body, nil)
+}
+
+// WebhookNotifier 把通知以 JSON 发送到任意地址，设置 Secret 时附带 HMAC-SHA256 签名
+type WebhookNotifier struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+}
+
+func (w *WebhookNotifier) Name() string { return "webhook" }
+
+// signWebhook 对 "时间戳.请求体" 计算 HMAC-SHA256，接收方应同时校验时间戳避免重放
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if w.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		header.Set("X-Autokline-Timestamp", timestamp)
+		header.Set("X-Autokline-Signature", signWebhook(w.Secret, timestamp, body))
+	}
+	return postJSON(ctx, w.URL, body, header)
+}
+
+// EmailNotifier 通过 SMTP 发送邮件，服务器支持时自动使用 STARTTLS
+type EmailNotifier struct {
+	Addr     string   `json:"addr"` // host:port
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+func (e *EmailNotifier) Name() string { return "email" }
+
+func (e *EmailNotifier) Notify(ctx context.Context, n Notification) error {
+	subject := n.Title
+	if subject == "" {
+		subject, _, _ = strings.Cut(n.Text, "\n")
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	qp.Write([]byte(strings.ReplaceAll(n.Text, "\n", "\r\n")))
+	qp.Close()
+	msg.WriteString("\r\n")
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, _ := strings.Cut(e.Addr, ":")
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	// smtp.SendMail 不支持 context，放到协程中以便超时返回
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(e.Addr, auth, e.From, e.To, msg.Bytes()) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ================= 通知路由 =================
+
+// retryPolicy 发送失败后的重试策略，间隔按指数增长
+type retryPolicy struct {
+	Attempts int      `json:"attempts"` // 总尝试次数，默认 3
+	Backoff  Duration `json:"backoff"`  // 首次重试前的等待时间，默认 2s
+}
+
+var defaultRetryPolicy = retryPolicy{Attempts: 3, Backoff: Duration(2 * time.Second)}
+
+// NotifyRouter 按规则名称把通知分发到不同渠道
+type NotifyRouter struct {
+	channels  map[string]Notifier
+	routes    map[string][]string // 规则名称 -> 渠道名称，"*" 为默认路由
+	retry     retryPolicy
+	templates *messageTemplates // 为 nil 时使用内置模板
+	policy    notifyPolicy
+}
+
+// notifier 当前生效的通知路由，未配置任何渠道时发送为空操作
+var notifier = &NotifyRouter{}
+
+// channelsFor 返回规则对应的渠道名称，没有匹配的路由时发送到全部渠道
+func (r *NotifyRouter) channelsFor(rule string) []string {
+	if names, ok := r.routes[rule]; ok {
+		return names
+	}
+	if names, ok := r.routes["*"]; ok {
+		return names
+	}
+	names := make([]string, 0, len(r.channels))
+	for name := range r.channels {
+		names = append(names, name)
+	}
+	return names
+}
+
+// sendWithRetry 向单个渠道发送，可重试的错误按策略重试
+func (r *NotifyRouter) sendWithRetry(ctx context.Context, ch Notifier, n Notification) error {
+	attempts, backoff := r.retry.Attempts, time.Duration(r.retry.Backoff)
+	if attempts <= 0 {
+		attempts = 1
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff *= 2
+		}
+		if err = ch.Notify(ctx, n); err == nil || !retryable(err) {
+			return err
+		}
+		log.Printf("%s 通知发送失败（第 %d 次）: %v", ch.Name(), i+1, err)
+	}
+	return err
+}
+
+// muteCacheKey 静音截止时间（毫秒）的缓存键，写入缓存以便重启后保持
+const muteCacheKey = "notify_mute_until"
+
+// muteNotifications 在 until 之前不发送规则提醒，until 不晚于当前时间时取消静音
+func muteNotifications(until time.Time) {
+	var err error
+	if ttl := time.Until(until); ttl > 0 {
+		err = NewTypedCache[int64](cache, Int64Codec{}).Set(muteCacheKey, until.UnixMilli(), ttl)
+	} else {
+		err = cache.Delete(muteCacheKey)
+	}
+	if err != nil {
+		log.Printf("保存静音状态失败: %v", err)
+	}
+}
+
+// mutedUntil 返回静音截止时间，未静音时返回 false
+func mutedUntil(now time.Time) (time.Time, bool) {
+	ms, ok, err := NewTypedCache[int64](cache, Int64Codec{}).Get(muteCacheKey)
+	if err != nil || !ok {
+		return time.Time{}, false
+	}
+	until := time.UnixMilli(ms)
+	return until, until.After(now)
+}
+
+// Send 把通知发送到规则对应的所有渠道，返回所有失败渠道的错误，静音期间跳过规则提醒
+func (r *NotifyRouter) Send(n Notification) error {
+	_, err := r.deliver(n)
+	return err
+}
+
+// deliver 与 Send 相同，同时返回发送成功的渠道数量，静音期间返回 0
+func (r *NotifyRouter) deliver(n Notification) (int, error) {
+	if n.Time == 0 {
+		n.Time = time.Now().UnixMilli()
+	}
+	if until, muted := mutedUntil(time.Now()); muted && n.Rule != "" {
+		log.Printf("通知已静音至 %s，跳过 %s", until.Format(time.DateTime), n.Rule)
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var errs []error
+	delivered := 0
+	names := slices.Clone(r.channelsFor(n.Rule))
+	if n.Message != nil && n.Message.Escalated {
+		for _, name := range r.policy.EscalateTo {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	for _, name := range names {
+		ch, ok := r.channels[name]
+		if !ok {
+			continue
+		}
+		m := n
+		if n.Message != nil {
+			format := channelFormat(ch)
+			if text, err := r.templates.render(name, format, *n.Message); err != nil {
+				log.Printf("渲染 %s 的消息模板失败: %v", name, err)
+			} else {
+				m.Text, m.Format = text, format
+			}
+		}
+		if err := r.sendWithRetry(ctx, ch, m); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		delivered++
+	}
+	return delivered, errors.Join(errs...)
+}
+
+// channelConfig notify.json 中的一个渠道，Type 决定使用哪些字段
+type channelConfig struct {
+	Type string `json:"type"` // telegram/discord/slack/webhook/email
+	TelegramNotifier
+	URL    string `json:"url,omitempty"`
+	Secret string `json:"secret,omitempty"`
+	EmailNotifier
+}
+
+// notifyConfig notify.json 的结构
+type notifyConfig struct {
+	Channels map[string]channelConfig `json:"channels"`
+	Routes   map[string][]string      `json:"routes"`
+	Retry    *retryPolicy             `json:"retry,omitempty"`
+	// 提醒语言 zh/en，为空时使用 ALERT_LOCALE
+	Locale string `json:"locale,omitempty"`
+	// 提醒消息模板，规则名称 -> 渠道名称 -> text/template 文本，"*" 匹配任意规则或渠道
+	Templates map[string]map[string]string `json:"templates,omitempty"`
+	// 冷却、摘要、频率上限、静默时段和升级策略
+	Policy notifyPolicy `json:"policy"`
+}
+
+func (c channelConfig) notifier() (Notifier, error) {
+	switch c.Type {
+	case "telegram":
+		t := c.TelegramNotifier
+		return &t, nil
+	case "discord":
+		return &DiscordNotifier{URL: c.URL}, nil
+	case "slack":
+		return &SlackNotifier{URL: c.URL}, nil
+	case "webhook":
+		return &WebhookNotifier{URL: c.URL, Secret: c.Secret}, nil
+	case "email":
+		e := c.EmailNotifier
+		if e.Addr == "" || e.From == "" || len(e.To) == 0 {
+			return nil, errors.New("email requires addr, from and to")
+		}
+		return &e, nil
+	}
+	return nil, fmt.Errorf("unknown channel type %q", c.Type)
+}
+
+// newNotifyRouter 根据配置创建通知路由并校验路由引用的渠道
+func newNotifyRouter(cfg notifyConfig) (*NotifyRouter, error) {
+	r := &NotifyRouter{channels: map[string]Notifier{}, routes: cfg.Routes, retry: defaultRetryPolicy}
+	if cfg.Retry != nil {
+		r.retry = *cfg.Retry
+	}
+	templates, err := newMessageTemplates(cfg.Locale, cfg.Templates)
+	if err != nil {
+		return nil, err
+	}
+	r.templates = templates
+	r.policy = cfg.Policy
+	if err := r.policy.normalize(); err != nil {
+		return nil, fmt.Errorf("policy: %w", err)
+	}
+	for _, name := range r.policy.EscalateTo {
+		if _, ok := cfg.Channels[name]; !ok {
+			return nil, fmt.Errorf("escalate_to: unknown channel %s", name)
+		}
+	}
+	for name, c := range cfg.Channels {
+		ch, err := c.notifier()
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", name, err)
+		}
+		r.channels[name] = ch
+	}
+	for rule, names := range cfg.Routes {
+		for _, name := range names {
+			if _, ok := r.channels[name]; !ok {
+				return nil, fmt.Errorf("route %s: unknown channel %s", rule, name)
+			}
+		}
+	}
+	return r, nil
+}
+
+// notifyConfigFromEnv 没有 notify.json 时从环境变量配置渠道，所有规则发送到全部渠道
+func notifyConfigFromEnv() notifyConfig {
+	cfg := notifyConfig{Channels: map[string]channelConfig{}}
+	if botToken != "" && chatID != "" {
+		cfg.Channels["telegram"] = channelConfig{Type: "telegram", TelegramNotifier: TelegramNotifier{
+			APIBase: os.Getenv("TELEGRAM_API_BASE"), Token: botToken, ChatID: chatID, ParseMode: os.Getenv("TELEGRAM_PARSE_MODE"),
+		}}
+	}
+	if v := os.Getenv("DISCORD_WEBHOOK_URL"); v != "" {
+		cfg.Channels["discord"] = channelConfig{Type: "discord", URL: v}
+	}
+	if v := os.Getenv("SLACK_WEBHOOK_URL"); v != "" {
+		cfg.Channels["slack"] = channelConfig{Type: "slack", URL: v}
+	}
+	if v := os.Getenv("WEBHOOK_URL"); v != "" {
+		cfg.Channels["webhook"] = channelConfig{Type: "webhook", URL: v, Secret: os.Getenv("WEBHOOK_SECRET")}
+	}
+	if v := os.Getenv("SMTP_ADDR"); v != "" {
+		cfg.Channels["email"] = channelConfig{Type: "email", EmailNotifier: EmailNotifier{
+			Addr:     v,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+			To:       strings.FieldsFunc(os.Getenv("SMTP_TO"), func(r rune) bool { return r == ',' }),
+		}}
+	}
+	return cfg
+}
+
+// loadNotifyConfig 从文件读取通知配置，文件不存在时使用环境变量
+func loadNotifyConfig(filename string) (*NotifyRouter, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return newNotifyRouter(notifyConfigFromEnv())
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cfg notifyConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	return newNotifyRouter(cfg)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recordingServer 记录收到的请求体，前 failures 次返回 status
+func recordingServer(t *testing.T, failures int32, status int) (*httptest.Server, *[][]byte, *atomic.Int32) {
+	t.Helper()
+	var mu sync.Mutex
+	var bodies [][]byte
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+		if r.Header.Get("X-Autokline-Signature") != "" {
+			ts := r.Header.Get("X-Autokline-Timestamp")
+			if r.Header.Get("X-Autokline-Signature") != signWebhook("secret", ts, body) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		// Telegram 需要解析响应体，其他渠道只看状态码
+		w.Write([]byte(`{"ok":true,"result":{}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &bodies, &calls
+}
+
+func TestNotifyChannels(t *testing.T) {
+	n := Notification{Rule: "bullish_cross", Title: "标题", Text: "标题\n- BTCUSDT\n", Symbols: []string{"BTCUSDT"}}
+
+	tg, tgBodies, _ := recordingServer(t, 0, 0)
+	discord, discordBodies, _ := recordingServer(t, 0, 0)
+	slack, slackBodies, _ := recordingServer(t, 0, 0)
+	hook, hookBodies, _ := recordingServer(t, 0, 0)
+	cases := []struct {
+		notifier Notifier
+		bodies   *[][]byte
+		field    string
+	}{
+		{&TelegramNotifier{APIBase: tg.URL, Token: "t", ChatID: "1"}, tgBodies, "text"},
+		{&DiscordNotifier{URL: discord.URL}, discordBodies, "content"},
+		{&SlackNotifier{URL: slack.URL}, slackBodies, "text"},
+		{&WebhookNotifier{URL: hook.URL, Secret: "secret"}, hookBodies, "text"},
+	}
+	for _, c := range cases {
+		if err := c.notifier.Notify(t.Context(), n); err != nil {
+			t.Fatalf("%s: %v", c.notifier.Name(), err)
+		}
+		if len(*c.bodies) != 1 {
+			t.Fatalf("%s: expected 1 request, got %d", c.notifier.Name(), len(*c.bodies))
+		}
+		var payload map[string]any
+		if err := json.Unmarshal((*c.bodies)[0], &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload[c.field] != n.Text {
+			t.Fatalf("%s: unexpected payload %s", c.notifier.Name(), (*c.bodies)[0])
+		}
+	}
+
+	// 签名错误时接收方拒绝
+	bad := &WebhookNotifier{URL: hook.URL, Secret: "wrong"}
+	if err := bad.Notify(t.Context(), n); err == nil || retryable(err) {
+		t.Fatalf("expected non-retryable signature error, got %v", err)
+	}
+}
+
+func TestNotifyRetryAndRouting(t *testing.T) {
+	flaky, flakyBodies, flakyCalls := recordingServer(t, 2, http.StatusBadGateway)
+	broken, _, brokenCalls := recordingServer(t, 100, http.StatusBadRequest)
+	other, otherBodies, _ := recordingServer(t, 0, 0)
+
+	router, err := newNotifyRouter(notifyConfig{
+		Channels: map[string]channelConfig{
+			"flaky":  {Type: "slack", URL: flaky.URL},
+			"broken": {Type: "discord", URL: broken.URL},
+			"other":  {Type: "webhook", URL: other.URL},
+		},
+		Routes: map[string][]string{"bullish_cross": {"flaky", "broken"}, "*": {"other"}},
+		Retry:  &retryPolicy{Attempts: 3, Backoff: Duration(time.Millisecond)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Send(Notification{Rule: "bullish_cross", Text: "hi"})
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("expected error from broken channel, got %v", err)
+	}
+	if flakyCalls.Load() != 3 || len(*flakyBodies) != 1 {
+		t.Fatalf("flaky channel should succeed on third attempt, calls=%d", flakyCalls.Load())
+	}
+	if brokenCalls.Load() != 1 {
+		t.Fatalf("4xx should not be retried, calls=%d", brokenCalls.Load())
+	}
+	if len(*otherBodies) != 0 {
+		t.Fatal("rule with explicit route should not use default route")
+	}
+
+	if err := router.Send(Notification{Rule: "above_ema", Text: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*otherBodies) != 1 {
+		t.Fatal("unrouted rule should use default route")
+	}
+
+	if _, err := newNotifyRouter(notifyConfig{Routes: map[string][]string{"*": {"missing"}}}); err == nil {
+		t.Fatal("expected unknown channel error")
+	}
+}
+
+// fakeSMTP 最小的 SMTP 服务器，返回收到的邮件内容
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "DATA"):
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				messages <- data.String()
+				reply("250 ok")
+			case strings.HasPrefix(cmd, "QUIT"):
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func TestEmailNotifier(t *testing.T) {
+	addr, messages := fakeSMTP(t)
+	e := &EmailNotifier{Addr: addr, From: "bot@example.com", To: []string{"me@example.com"}}
+	if err := e.Notify(t.Context(), Notification{Title: "以下代币出现MACD水上金叉：", Text: "- BTCUSDT\n"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-messages:
+		if !strings.Contains(msg, "Subject: =?UTF-8?b?") || !strings.Contains(msg, "- BTCUSDT") {
+			t.Fatalf("unexpected message:\n%s", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+func TestNotifyConfigFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "notify.json")
+	data := `{
+		"channels": {"tg": {"type": "telegram", "token": "t", "chat_id": "1"}, "mail": {"type": "email", "addr": "localhost:25", "from": "a@b", "to": ["c@d"]}},
+		"routes": {"*": ["tg", "mail"]},
+		"retry": {"attempts": 5, "backoff": "1s"}
+	}`
+	if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	router, err := loadNotifyConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(router.channels) != 2 || router.retry.Attempts != 5 || time.Duration(router.retry.Backoff) != time.Second {
+		t.Fatalf("unexpected router: %+v", router)
+	}
+	if tg, ok := router.channels["tg"].(*TelegramNotifier); !ok || tg.ChatID != "1" {
+		t.Fatalf("unexpected telegram channel: %+v", router.channels["tg"])
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+)
+
+// ================= 参数搜索与滚动验证 =================
+
+// OptimizeResult 一组参数在某个区间上的回测结果
+type OptimizeResult struct {
+	ID           uint   `gorm:"primaryKey"`
+	RunID        string `gorm:"index"`
+	Fold         int    // 滚动窗口序号，-1 表示全区间
+	Phase        string // full/train/test
+	Fast         int
+	Slow         int
+	Signal       int
+	EMAPeriod    int
+	NegativeBars int
+	Trades       int
+	WinRate      float64
+	Expectancy   float64
+	TotalReturn  float64
+	MaxDrawdown  float64
+	Sharpe       float64
+	Selected     bool // 该窗口训练集上得分最高的参数
+	CreatedAt    time.Time
+}
+
+func (OptimizeResult) TableName() string {
+	return "optimize_results"
+}
+
+// walkForwardFold 一个滚动窗口，训练区间之后紧接测试区间
+type walkForwardFold struct {
+	TrainFrom, TrainTo int64
+	TestFrom, TestTo   int64
+}
+
+// walkForwardFolds 从 start 开始按测试区间长度滚动切分，直到测试区间超出 end
+func walkForwardFolds(start, end int64, train, test time.Duration) []walkForwardFold {
+	var folds []walkForwardFold
+	trainMs, testMs := train.Milliseconds(), test.Milliseconds()
+	if trainMs <= 0 || testMs <= 0 {
+		return nil
+	}
+	for from := start; from+trainMs+testMs <= end; from += testMs {
+		folds = append(folds, walkForwardFold{
+			TrainFrom: from,
+			TrainTo:   from + trainMs,
+			TestFrom:  from + trainMs,
+			TestTo:    from + trainMs + testMs,
+		})
+	}
+	return folds
+}
+
+// parseIntList 解析逗号分隔的整数列表
+func parseIntList(v string) ([]int, error) {
+	var list []int
+	for _, part := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid value %q", part)
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+// parameterGrid 生成全部参数组合，跳过快线不小于慢线的组合
+func parameterGrid(fast, slow, signal, ema, negative []int) []MACDRuleParams {
+	var grid []MACDRuleParams
+	for _, f := range fast {
+		for _, s := range slow {
+			if f >= s {
+				continue
+			}
+			for _, sig := range signal {
+				for _, e := range ema {
+					for _, n := range negative {
+						p := defaultMACDRuleParams
+						p.Fast, p.Slow, p.Signal, p.EMAPeriod, p.NegativeBars = f, s, sig, e, n
+						grid = append(grid, p)
+					}
+				}
+			}
+		}
+	}
+	return grid
+}
+
+// sampleGrid 随机搜索：用 seed 打乱后取前 n 个组合，n 为 0 或不小于网格大小时返回全部
+func sampleGrid(grid []MACDRuleParams, n int, seed int64) []MACDRuleParams {
+	if n <= 0 || n >= len(grid) {
+		return grid
+	}
+	rng := rand.New(rand.NewSource(seed))
+	rng.Shuffle(len(grid), func(i, j int) { grid[i], grid[j] = grid[j], grid[i] })
+	return grid[:n]
+}
+
+// objectiveValue 返回用于排序的得分，交易数不足时返回负无穷
+func objectiveValue(stats BacktestStats, objective string, minTrades int) float64 {
+	if stats.Trades < minTrades {
+		return math.Inf(-1)
+	}
+	switch objective {
+	case "sharpe":
+		return stats.Sharpe
+	case "total_return":
+		return stats.TotalReturn
+	case "win_rate":
+		return stats.WinRate
+	}
+	return stats.Expectancy
+}
+
+// tradesBetween 返回开仓时间落在 [from, to) 的交易
+func tradesBetween(trades []BacktestTrade, from, to int64) []BacktestTrade {
+	var out []BacktestTrade
+	for _, t := range trades {
+		if t.EntryTime >= from && t.EntryTime < to {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func newOptimizeResult(runID string, fold int, phase string, p MACDRuleParams, stats BacktestStats) OptimizeResult {
+	return OptimizeResult{
+		RunID:        runID,
+		Fold:         fold,
+		Phase:        phase,
+		Fast:         p.Fast,
+		Slow:         p.Slow,
+		Signal:       p.Signal,
+		EMAPeriod:    p.EMAPeriod,
+		NegativeBars: p.NegativeBars,
+		Trades:       stats.Trades,
+		WinRate:      stats.WinRate,
+		Expectancy:   stats.Expectancy,
+		TotalReturn:  stats.TotalReturn,
+		MaxDrawdown:  stats.MaxDrawdown,
+		Sharpe:       stats.Sharpe,
+	}
+}
+
+// sweepParams 对每组参数在所有代币上回测，返回每组参数的全部交易
+// 与 processSymbols 一样使用 errgroup 加信号量限制并发
+func sweepParams(db *gorm.DB, grid []MACDRuleParams, symbolList []string, cfg BacktestConfig, parallel int) ([][]BacktestTrade, int64, int64) {
+	// 每个代币的历史只读取一次
+	interval := macdBullishCrossRule(defaultMACDRuleParams).Interval
+	history := make(map[string][]Kline, len(symbolList))
+	start, end := int64(math.MaxInt64), int64(0)
+	for _, sym := range symbolList {
+		klines := getAggKlineAsc(db, sym, interval, backtestMaxBars, true)
+		history[sym] = klines
+		if len(klines) > 0 {
+			start = min(start, klines[0].OpenTime)
+			end = max(end, klines[len(klines)-1].CloseTime)
+		}
+	}
+
+	results := make([][]BacktestTrade, len(grid))
+	var mu sync.Mutex
+	var g errgroup.Group
+	sem := make(chan struct{}, parallel)
+	for i, params := range grid {
+		rule := macdBullishCrossRule(params)
+		for _, sym := range symbolList {
+			i, sym := i, sym
+			g.Go(func() error {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				load := dbKlineLoader(db, sym)
+				trades := simulateRule(rule, history[sym], load, cfg)
+				mu.Lock()
+				results[i] = append(results[i], trades...)
+				mu.Unlock()
+				return nil
+			})
+		}
+	}
+	g.Wait()
+	return results, start, end
+}
+
+// runOptimizeCommand 命令行入口：
+// kline optimize -fast 8,12 -slow 21,26 -signal 9 -ema 100,144,200 -neg 3,5 -train-days 60 -test-days 15 -out optimize.csv
+func runOptimizeCommand(db *gorm.DB, args []string) error {
+	fs := flag.NewFlagSet("optimize", flag.ContinueOnError)
+	cfg := defaultBacktestConfig
+	bindBacktestFlags(fs, &cfg)
+	symbolArg := fs.String("symbols", "", "逗号分隔的代币，默认全部")
+	fastArg := fs.String("fast", "12", "MACD 快线周期列表")
+	slowArg := fs.String("slow", "26", "MACD 慢线周期列表")
+	signalArg := fs.String("signal", "9", "MACD 信号线周期列表")
+	emaArg := fs.String("ema", "144", "趋势均线周期列表")
+	negArg := fs.String("neg", "5", "负柱数量阈值列表")
+	random := fs.Int("random", 0, "随机抽取的组合数量，0 表示遍历全部网格")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "随机搜索的种子")
+	trainDays := fs.Int("train-days", 60, "滚动验证训练区间天数")
+	testDays := fs.Int("test-days", 15, "滚动验证测试区间天数，0 表示不做滚动验证")
+	objective := fs.String("objective", "expectancy", "排序指标：expectancy/sharpe/total_return/win_rate")
+	minTrades := fs.Int("min-trades", 5, "参与排序的最少交易数")
+	parallel := fs.Int("parallel", 4, "并发数")
+	out := fs.String("out", "", "结果 CSV 文件路径")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	lists := make([][]int, 5)
+	for i, arg := range []string{*fastArg, *slowArg, *signalArg, *emaArg, *negArg} {
+		list, err := parseIntList(arg)
+		if err != nil {
+			return err
+		}
+		lists[i] = list
+	}
+	grid := sampleGrid(parameterGrid(lists[0], lists[1], lists[2], lists[3], lists[4]), *random, *seed)
+	if len(grid) == 0 {
+		return errors.New("empty parameter grid")
+	}
+	if *parallel <= 0 {
+		*parallel = 1
+	}
+	symbolList, err := resolveSymbols(*symbolArg)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("参数组合 %d 个，代币 %d 个", len(grid), len(symbolList))
+	began := time.Now()
+	tradesByParams, start, end := sweepParams(db, grid, symbolList, cfg, *parallel)
+	log.Printf("回测完成，耗时 %s", time.Since(began))
+
+	runID := time.Now().Format("20060102150405")
+	var rows []OptimizeResult
+	fullScores := make([]float64, len(grid))
+	for i, p := range grid {
+		stats := computeBacktestStats(tradesByParams[i])
+		fullScores[i] = objectiveValue(stats, *objective, *minTrades)
+		rows = append(rows, newOptimizeResult(runID, -1, "full", p, stats))
+	}
+
+	// 滚动验证：每个窗口在训练区间上选出得分最高的参数，再看其在紧随其后的测试区间上的表现
+	var folds []walkForwardFold
+	if *testDays > 0 {
+		folds = walkForwardFolds(start, end, time.Duration(*trainDays)*24*time.Hour, time.Duration(*testDays)*24*time.Hour)
+	}
+	var outOfSample []BacktestTrade
+	for n, fold := range folds {
+		best, bestScore := -1, math.Inf(-1)
+		// 每组参数在 rows 中依次写入训练行和测试行，记录训练行下标
+		trainRows := make([]int, len(grid))
+		for i, p := range grid {
+			train := computeBacktestStats(tradesBetween(tradesByParams[i], fold.TrainFrom, fold.TrainTo))
+			test := computeBacktestStats(tradesBetween(tradesByParams[i], fold.TestFrom, fold.TestTo))
+			if score := objectiveValue(train, *objective, *minTrades); score > bestScore {
+				best, bestScore = i, score
+			}
+			trainRows[i] = len(rows)
+			rows = append(rows, newOptimizeResult(runID, n, "train", p, train))
+			rows = append(rows, newOptimizeResult(runID, n, "test", p, test))
+		}
+		if best < 0 {
+			log.Printf("窗口 %d 训练区间交易数不足，跳过", n)
+			continue
+		}
+		rows[trainRows[best]].Selected = true
+		rows[trainRows[best]+1].Selected = true
+		outOfSample = append(outOfSample, tradesBetween(tradesByParams[best], fold.TestFrom, fold.TestTo)...)
+		test := rows[trainRows[best]+1]
+		fmt.Printf("窗口 %d 训练 %s ~ %s 选中 %+v，测试 %s ~ %s 交易 %d 期望 %.4f 胜率 %.2f\n", n,
+			time.UnixMilli(fold.TrainFrom).Format("2006-01-02"), time.UnixMilli(fold.TrainTo).Format("2006-01-02"), grid[best],
+			time.UnixMilli(fold.TestFrom).Format("2006-01-02"), time.UnixMilli(fold.TestTo).Format("2006-01-02"),
+			test.Trades, test.Expectancy, test.WinRate)
+	}
+	if len(folds) > 0 {
+		oos := computeBacktestStats(outOfSample)
+		fmt.Printf("滚动验证样本外汇总：交易 %d 期望 %.4f 胜率 %.2f 最大回撤 %.4f 夏普 %.2f\n",
+			oos.Trades, oos.Expectancy, oos.WinRate, oos.MaxDrawdown, oos.Sharpe)
+	}
+
+	// 全区间排名前 10
+	order := make([]int, len(grid))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return fullScores[order[a]] > fullScores[order[b]] })
+	fmt.Println("全区间排名：")
+	for rank, i := range order[:min(10, len(order))] {
+		r := rows[i]
+		fmt.Printf("%2d. fast=%d slow=%d signal=%d ema=%d neg=%d 交易 %d 期望 %.4f 胜率 %.2f 回撤 %.4f 夏普 %.2f\n", rank+1,
+			r.Fast, r.Slow, r.Signal, r.EMAPeriod, r.NegativeBars, r.Trades, r.Expectancy, r.WinRate, r.MaxDrawdown, r.Sharpe)
+	}
+
+	if err := db.AutoMigrate(&OptimizeResult{}); err != nil {
+		return fmt.Errorf("创建结果表失败: %w", err)
+	}
+	if err := db.CreateInBatches(rows, 200).Error; err != nil {
+		return fmt.Errorf("保存结果失败: %w", err)
+	}
+	log.Printf("结果已保存到 optimize_results，run_id=%s，共 %d 行", runID, len(rows))
+	if *out != "" {
+		if err := writeOptimizeCSV(*out, rows); err != nil {
+			return err
+		}
+		log.Printf("结果已写入 %s", *out)
+	}
+	return nil
+}
+
+// writeOptimizeCSV 将结果写入 CSV 文件
+func writeOptimizeCSV(filename string, rows []OptimizeResult) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"run_id", "fold", "phase", "fast", "slow", "signal", "ema", "neg", "trades", "win_rate", "expectancy", "total_return", "max_drawdown", "sharpe", "selected"})
+	for _, r := range rows {
+		w.Write([]string{
+			r.RunID, strconv.Itoa(r.Fold), r.Phase,
+			strconv.Itoa(r.Fast), strconv.Itoa(r.Slow), strconv.Itoa(r.Signal), strconv.Itoa(r.EMAPeriod), strconv.Itoa(r.NegativeBars),
+			strconv.Itoa(r.Trades),
+			strconv.FormatFloat(r.WinRate, 'f', 6, 64),
+			strconv.FormatFloat(r.Expectancy, 'f', 6, 64),
+			strconv.FormatFloat(r.TotalReturn, 'f', 6, 64),
+			strconv.FormatFloat(r.MaxDrawdown, 'f', 6, 64),
+			strconv.FormatFloat(r.Sharpe, 'f', 6, 64),
+			strconv.FormatBool(r.Selected),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
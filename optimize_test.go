@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestWalkForwardFolds(t *testing.T) {
+	day := 24 * time.Hour
+	dayMs := day.Milliseconds()
+	tests := []struct {
+		name        string
+		end         int64
+		train, test time.Duration
+		folds       int
+	}{
+		{"partial last fold dropped", 100 * dayMs, 60 * day, 15 * day, 2},
+		{"exact fit", 105 * dayMs, 60 * day, 15 * day, 3},
+		{"shorter than one fold", 74 * dayMs, 60 * day, 15 * day, 0},
+		{"no test window", 100 * dayMs, 60 * day, 0, 0},
+	}
+	for _, tt := range tests {
+		folds := walkForwardFolds(0, tt.end, tt.train, tt.test)
+		if len(folds) != tt.folds {
+			t.Errorf("%s: expected %d folds, got %+v", tt.name, tt.folds, folds)
+			continue
+		}
+		for i, f := range folds {
+			// 训练区间与测试区间首尾相接不重叠，测试区间依次滚动且不超出数据范围
+			if f.TrainTo-f.TrainFrom != tt.train.Milliseconds() || f.TestTo-f.TestFrom != tt.test.Milliseconds() {
+				t.Errorf("%s: fold %d has wrong lengths: %+v", tt.name, i, f)
+			}
+			if f.TestFrom != f.TrainTo || f.TestTo > tt.end {
+				t.Errorf("%s: fold %d overlaps or overruns: %+v", tt.name, i, f)
+			}
+			if i > 0 && f.TestFrom != folds[i-1].TestTo {
+				t.Errorf("%s: fold %d test window should follow the previous one: %+v", tt.name, i, f)
+			}
+		}
+	}
+
+	// 交易按开仓时间落入 [from, to)，边界上的交易只属于后一个区间
+	trades := []BacktestTrade{{EntryTime: 0}, {EntryTime: 5}, {EntryTime: 10}, {EntryTime: 15}}
+	if got := tradesBetween(trades, 5, 15); len(got) != 2 || got[0].EntryTime != 5 || got[1].EntryTime != 10 {
+		t.Fatalf("unexpected trades between: %+v", got)
+	}
+}
+
+func TestParameterGrid(t *testing.T) {
+	tests := []struct {
+		fast, slow, signal, ema, negative []int
+		size                              int
+	}{
+		{[]int{12}, []int{26}, []int{9}, []int{144}, []int{5}, 1},
+		{[]int{8, 12}, []int{21, 26}, []int{9}, []int{100, 144}, []int{3, 5}, 16},
+		// 快线不小于慢线的组合被跳过，只剩 (12,20) (12,26) (20,26)，再乘以两个信号线周期
+		{[]int{12, 20, 26}, []int{12, 20, 26}, []int{9, 7}, []int{144}, []int{5}, 6},
+		{[]int{26}, []int{12}, []int{9}, []int{144}, []int{5}, 0},
+	}
+	for _, tt := range tests {
+		grid := parameterGrid(tt.fast, tt.slow, tt.signal, tt.ema, tt.negative)
+		if len(grid) != tt.size {
+			t.Errorf("grid %v/%v: expected %d combinations, got %d", tt.fast, tt.slow, tt.size, len(grid))
+		}
+		for _, p := range grid {
+			if p.Fast >= p.Slow {
+				t.Errorf("fast >= slow should be skipped: %+v", p)
+			}
+			if p.SlopeBars != defaultMACDRuleParams.SlopeBars || p.HistLookback != defaultMACDRuleParams.HistLookback {
+				t.Errorf("unswept params should keep defaults: %+v", p)
+			}
+		}
+	}
+
+	// 随机搜索：相同种子结果相同，抽取的组合互不重复且来自网格
+	full := func() []MACDRuleParams {
+		return parameterGrid([]int{8, 10, 12}, []int{21, 26}, []int{9}, []int{100, 144}, []int{3, 5})
+	}
+	all := full()
+	a, b := sampleGrid(full(), 5, 42), sampleGrid(full(), 5, 42)
+	if len(a) != 5 || !slices.Equal(a, b) {
+		t.Fatalf("same seed should pick the same combinations: %v %v", a, b)
+	}
+	for i, p := range a {
+		if !slices.Contains(all, p) || slices.Contains(a[:i], p) {
+			t.Fatalf("sampled combination should be unique and from the grid: %+v", p)
+		}
+	}
+	if got := sampleGrid(full(), 0, 42); !slices.Equal(got, all) {
+		t.Fatal("random 0 should keep the whole grid")
+	}
+	if got := sampleGrid(full(), 100, 42); !slices.Equal(got, all) {
+		t.Fatal("random larger than the grid should keep the whole grid")
+	}
+
+	if list, err := parseIntList("12, 26,9"); err != nil || !slices.Equal(list, []int{12, 26, 9}) {
+		t.Fatalf("unexpected list: %v %v", list, err)
+	}
+	for _, v := range []string{"", "12,x", "0", "-3"} {
+		if _, err := parseIntList(v); err == nil {
+			t.Errorf("expected error for %q", v)
+		}
+	}
+}
+
+func TestObjectiveValue(t *testing.T) {
+	candidates := map[string]BacktestStats{
+		"steady": {Trades: 20, WinRate: 0.7, Expectancy: 0.004, TotalReturn: 0.08, Sharpe: 0.9},
+		"big":    {Trades: 10, WinRate: 0.4, Expectancy: 0.012, TotalReturn: 0.12, Sharpe: 0.5},
+		"lucky":  {Trades: 2, WinRate: 1, Expectancy: 0.05, TotalReturn: 0.1, Sharpe: 3},
+	}
+	tests := []struct {
+		objective string
+		minTrades int
+		order     []string
+	}{
+		{"expectancy", 5, []string{"big", "steady", "lucky"}},
+		{"", 5, []string{"big", "steady", "lucky"}},
+		{"sharpe", 5, []string{"steady", "big", "lucky"}},
+		{"total_return", 5, []string{"big", "steady", "lucky"}},
+		{"win_rate", 5, []string{"steady", "big", "lucky"}},
+		{"sharpe", 1, []string{"lucky", "steady", "big"}},
+	}
+	for _, tt := range tests {
+		names := []string{"lucky", "steady", "big"}
+		score := func(name string) float64 { return objectiveValue(candidates[name], tt.objective, tt.minTrades) }
+		sort.SliceStable(names, func(a, b int) bool { return score(names[a]) > score(names[b]) })
+		if !slices.Equal(names, tt.order) {
+			t.Errorf("%s/%d: expected %v, got %v", tt.objective, tt.minTrades, tt.order, names)
+		}
+	}
+	if v := objectiveValue(candidates["lucky"], "expectancy", 5); !math.IsInf(v, -1) {
+		t.Fatalf("too few trades should score -Inf, got %v", v)
+	}
+}
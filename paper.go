@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ================= 模拟交易 =================
+
+// PaperPosition 一笔由信号触发的模拟持仓，只做多
+type PaperPosition struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Symbol      string    `gorm:"index" json:"symbol"`
+	Rule        string    `json:"rule"`
+	Status      string    `gorm:"index" json:"status"` // open/closed
+	EntryTime   int64     `json:"entry_time"`
+	EntryPrice  float64   `json:"entry_price"`
+	Quantity    float64   `json:"quantity"`
+	StopPrice   float64   `json:"stop_price"`    // 0 表示不止损
+	TargetPrice float64   `json:"target_price"`  // 0 表示不止盈
+	ExpireAt    int64     `json:"expire_at"`     // 超过该时间按收盘价平仓，0 表示不限
+	LastBarTime int64     `json:"last_bar_time"` // 已检查过的最后一根15m K线开盘时间
+	ExitTime    int64     `json:"exit_time,omitempty"`
+	ExitPrice   float64   `json:"exit_price,omitempty"`
+	ExitReason  string    `json:"exit_reason,omitempty"` // tp/sl/time
+	Fees        float64   `json:"fees"`
+	PnL         float64   `json:"pnl"` // 已扣除手续费的盈亏
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// PaperFill 模拟成交记录
+type PaperFill struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	PositionID uint      `gorm:"index" json:"position_id"`
+	Symbol     string    `json:"symbol"`
+	Side       string    `json:"side"` // buy/sell
+	Price      float64   `json:"price"`
+	Quantity   float64   `json:"quantity"`
+	Fee        float64   `json:"fee"`
+	Time       int64     `json:"time"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// paperConfig 模拟交易配置，止盈止损和成本沿用回测参数
+type paperConfig struct {
+	Enabled  bool
+	Notional float64 // 每笔名义金额 (USDT)
+	Equity   float64 // 初始资金
+	BacktestConfig
+}
+
+// loadPaperConfig 从环境变量读取模拟交易配置
+func loadPaperConfig() paperConfig {
+	cfg := paperConfig{Enabled: os.Getenv("PAPER_TRADING") != "off", Notional: 100, Equity: 10000, BacktestConfig: defaultBacktestConfig}
+	floats := map[string]*float64{
+		"PAPER_NOTIONAL": &cfg.Notional,
+		"PAPER_EQUITY":   &cfg.Equity,
+		"PAPER_TP":       &cfg.TakeProfit,
+		"PAPER_SL":       &cfg.StopLoss,
+		"PAPER_FEE":      &cfg.Fee,
+		"PAPER_SLIPPAGE": &cfg.Slippage,
+	}
+	for name, dst := range floats {
+		if v := os.Getenv(name); v != "" {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+				*dst = f
+			} else {
+				log.Printf("%s 配置无效: %s", name, v)
+			}
+		}
+	}
+	if v := os.Getenv("PAPER_MAX_BARS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.MaxBars = n
+		}
+	}
+	return cfg
+}
+
+var paper paperConfig
+
+// migratePaperTables 创建模拟交易相关的表
+func migratePaperTables(db *gorm.DB) error {
+	return db.AutoMigrate(&PaperPosition{}, &PaperFill{})
+}
+
+// openPaperPositions 按最新一根15m K线的收盘价为信号代币开仓，同一规则同一代币已有持仓时跳过
+func openPaperPositions(db *gorm.DB, rule string, symbolList []string) {
+	if !paper.Enabled {
+		return
+	}
+	for _, symbol := range symbolList {
+		var count int64
+		db.Model(&PaperPosition{}).Where("symbol = ? AND rule = ? AND status = ?", symbol, rule, "open").Count(&count)
+		if count > 0 {
+			continue
+		}
+		latest := getAggKline(db, symbol, "15m", 1)
+		if len(latest) == 0 || latest[0].Close <= 0 {
+			continue
+		}
+		bar := latest[0]
+		// 从最后一根已收盘K线之后开始检查，开仓所在的K线收盘后也参与止盈止损
+		lastBar := bar.OpenTime
+		if !bar.IsClosed {
+			lastBar -= intervalMillis("15m")
+		}
+		price := bar.Close * (1 + paper.Slippage)
+		qty := paper.Notional / price
+		fee := paper.Notional * paper.Fee
+		now := time.Now().UnixMilli()
+		pos := PaperPosition{
+			Symbol:      symbol,
+			Rule:        rule,
+			Status:      "open",
+			EntryTime:   now,
+			EntryPrice:  price,
+			Quantity:    qty,
+			StopPrice:   paper.stopPrice(price),
+			TargetPrice: paper.targetPrice(price),
+			LastBarTime: lastBar,
+			Fees:        fee,
+		}
+		if paper.MaxBars > 0 {
+			pos.ExpireAt = now + int64(paper.MaxBars)*intervalMillis("15m")
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&pos).Error; err != nil {
+				return err
+			}
+			return tx.Create(&PaperFill{PositionID: pos.ID, Symbol: symbol, Side: "buy", Price: price, Quantity: qty, Fee: fee, Time: now, Reason: rule}).Error
+		})
+		if err != nil {
+			log.Printf("模拟开仓 %s 失败: %v", symbol, err)
+			continue
+		}
+		log.Printf("模拟开仓 %s 价格 %.8f 数量 %.6f", symbol, price, qty)
+	}
+}
+
+// updatePaperPositions 用开仓之后新收盘的15m K线检查持仓的止盈止损和持仓时间
+func updatePaperPositions(db *gorm.DB, symbol string) error {
+	var positions []PaperPosition
+	if err := db.Where("symbol = ? AND status = ?", symbol, "open").Find(&positions).Error; err != nil {
+		return err
+	}
+	if len(positions) == 0 {
+		return nil
+	}
+
+	tableName := Kline{Symbol: symbol}.TableName()
+	for _, pos := range positions {
+		var bars []Kline
+		db.Table(tableName).Where("open_time > ? AND is_closed = ?", pos.LastBarTime, true).Order("open_time ASC").Find(&bars)
+		for _, k := range bars {
+			pos.LastBarTime = k.OpenTime
+			price, reason, ok := exitOnBar(k, pos.StopPrice, pos.TargetPrice, pos.ExpireAt > 0 && k.CloseTime >= pos.ExpireAt)
+			if !ok {
+				continue
+			}
+			price *= 1 - paper.Slippage
+			fee := price * pos.Quantity * paper.Fee
+			pos.Status = "closed"
+			pos.ExitTime = k.CloseTime
+			pos.ExitPrice = price
+			pos.ExitReason = reason
+			pos.Fees += fee
+			pos.PnL = (price-pos.EntryPrice)*pos.Quantity - pos.Fees
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Create(&PaperFill{PositionID: pos.ID, Symbol: symbol, Side: "sell", Price: price, Quantity: pos.Quantity, Fee: fee, Time: k.CloseTime, Reason: reason}).Error; err != nil {
+					return err
+				}
+				return tx.Save(&pos).Error
+			})
+			if err != nil {
+				return err
+			}
+			log.Printf("模拟平仓 %s 原因 %s 盈亏 %.4f", symbol, reason, pos.PnL)
+			break
+		}
+		if pos.Status == "open" {
+			db.Model(&pos).Update("last_bar_time", pos.LastBarTime)
+		}
+	}
+	return nil
+}
+
+// paperEquityPoint 权益曲线上的一个点，每笔平仓产生一个点
+type paperEquityPoint struct {
+	Time   int64   `json:"time"`
+	Equity float64 `json:"equity"`
+}
+
+// paperEquityCurve 按平仓时间累计已实现盈亏
+func paperEquityCurve(db *gorm.DB) ([]paperEquityPoint, error) {
+	var closed []PaperPosition
+	if err := db.Where("status = ?", "closed").Order("exit_time ASC").Find(&closed).Error; err != nil {
+		return nil, err
+	}
+	equity := paper.Equity
+	curve := make([]paperEquityPoint, 0, len(closed)+1)
+	curve = append(curve, paperEquityPoint{Equity: equity})
+	for _, p := range closed {
+		equity += p.PnL
+		curve = append(curve, paperEquityPoint{Time: p.ExitTime, Equity: equity})
+	}
+	return curve, nil
+}
+
+type paperPositionView struct {
+	PaperPosition
+	MarkPrice  float64 `json:"mark_price"`
+	Unrealized float64 `json:"unrealized"`
+}
+
+// handlePaperPositions 返回当前持仓及按最新价计算的浮动盈亏
+func handlePaperPositions(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		var positions []PaperPosition
+		if err := db.Where("status = ?", "open").Order("entry_time DESC").Find(&positions).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		views := make([]paperPositionView, 0, len(positions))
+		for _, p := range positions {
+			view := paperPositionView{PaperPosition: p}
+			if latest := getAggKline(db, p.Symbol, "15m", 1); len(latest) > 0 {
+				view.MarkPrice = latest[0].Close
+				view.Unrealized = (view.MarkPrice-p.EntryPrice)*p.Quantity - p.Fees
+			}
+			views = append(views, view)
+		}
+		writeJSON(w, r, views)
+	}
+}
+
+// handlePaperTrades 返回已平仓的交易，limit 默认 100
+func handlePaperTrades(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 100
+		}
+		query := db.Where("status = ?", "closed")
+		if symbol := r.URL.Query().Get("symbol"); symbol != "" {
+			query = query.Where("symbol = ?", symbol)
+		}
+		var trades []PaperPosition
+		if err := query.Order("exit_time DESC").Limit(limit).Find(&trades).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, trades)
+	}
+}
+
+// handlePaperEquity 返回已实现盈亏的权益曲线
+func handlePaperEquity(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		curve, err := paperEquityCurve(db)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, curve)
+	}
+}
+
+// paperDailySummary 生成 since 之后的模拟交易日报
+func paperDailySummary(db *gorm.DB, since time.Time) (string, error) {
+	var closed []PaperPosition
+	if err := db.Where("status = ? AND exit_time >= ?", "closed", since.UnixMilli()).Find(&closed).Error; err != nil {
+		return "", err
+	}
+	var openCount int64
+	db.Model(&PaperPosition{}).Where("status = ?", "open").Count(&openCount)
+	curve, err := paperEquityCurve(db)
+	if err != nil {
+		return "", err
+	}
+
+	wins, pnl := 0, 0.0
+	for _, p := range closed {
+		if p.PnL > 0 {
+			wins++
+		}
+		pnl += p.PnL
+	}
+	message := fmt.Sprintf("模拟交易日报 %s\n", since.Format("2006-01-02"))
+	message += fmt.Sprintf("平仓 %d 笔，盈利 %d 笔，盈亏 %.2f USDT\n", len(closed), wins, pnl)
+	message += fmt.Sprintf("当前持仓 %d 笔，权益 %.2f USDT\n", openCount, curve[len(curve)-1].Equity)
+	return message, nil
+}
+
+// startPaperDailySummary 每天 loc 时区零点发送前一天的模拟交易日报
+func startPaperDailySummary(db *gorm.DB, loc *time.Location) {
+	if !paper.Enabled {
+		return
+	}
+	go func() {
+		for {
+			now := time.Now().In(loc)
+			today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+			next := today.AddDate(0, 0, 1)
+			time.Sleep(time.Until(next))
+
+			message, err := paperDailySummary(db, today)
+			if err != nil {
+				log.Printf("生成模拟交易日报失败: %v", err)
+				continue
+			}
+			if err := notifier.Send(Notification{Title: "模拟交易日报", Text: message}); err != nil {
+				log.Printf("发送模拟交易日报失败: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestPaperPositionLifecycle(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migratePaperTables(db); err != nil {
+		t.Fatal(err)
+	}
+	klines := fixtureKlines(3, 1)
+	table := Kline{Symbol: "TESTUSDT"}.TableName()
+	if err := db.Table(table).AutoMigrate(&Kline{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Table(table).Create(&klines[0])
+
+	oldPaper := paper
+	t.Cleanup(func() { paper = oldPaper })
+	paper = paperConfig{Enabled: true, Notional: 100, Equity: 1000, BacktestConfig: BacktestConfig{TakeProfit: 0.03, StopLoss: 0.02}}
+	openPaperPositions(db, "bullish_cross", []string{"TESTUSDT"})
+	openPaperPositions(db, "bullish_cross", []string{"TESTUSDT"})
+	var positions []PaperPosition
+	db.Find(&positions)
+	if len(positions) != 1 {
+		t.Fatalf("expected one open position, got %d", len(positions))
+	}
+	entry := positions[0].EntryPrice
+	if entry != klines[0].Close {
+		t.Fatalf("entry %v != close %v", entry, klines[0].Close)
+	}
+
+	// 第二根K线未触及止盈止损，第三根触及止盈
+	klines[1].High, klines[1].Low = entry*1.01, entry*0.99
+	klines[2].High, klines[2].Low, klines[2].Close = entry*1.05, entry*0.995, entry*1.04
+	db.Table(table).Create(&klines[1])
+	db.Table(table).Create(&klines[2])
+	if err := updatePaperPositions(db, "TESTUSDT"); err != nil {
+		t.Fatal(err)
+	}
+
+	var pos PaperPosition
+	db.First(&pos, positions[0].ID)
+	if pos.Status != "closed" || pos.ExitReason != "tp" || pos.ExitTime != klines[2].CloseTime {
+		t.Fatalf("unexpected position after update: %+v", pos)
+	}
+	var fills int64
+	db.Model(&PaperFill{}).Where("position_id = ?", pos.ID).Count(&fills)
+	if fills != 2 {
+		t.Fatalf("expected 2 fills, got %d", fills)
+	}
+	curve, err := paperEquityCurve(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := curve[len(curve)-1].Equity; got <= 1000 {
+		t.Fatalf("equity should grow after take profit, got %v", got)
+	}
+}
+
+func TestPaperChecksEntryBar(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migratePaperTables(db); err != nil {
+		t.Fatal(err)
+	}
+	klines := fixtureKlines(2, 2)
+	klines[1].IsClosed = false
+	table := Kline{Symbol: "TESTUSDT"}.TableName()
+	if err := db.Table(table).AutoMigrate(&Kline{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Table(table).Create(klines)
+
+	oldPaper := paper
+	t.Cleanup(func() { paper = oldPaper })
+	paper = paperConfig{Enabled: true, Notional: 100, Equity: 1000, BacktestConfig: BacktestConfig{TakeProfit: 0.03, StopLoss: 0.02}}
+
+	// 在未收盘的K线上开仓，从上一根已收盘K线之后开始检查
+	openPaperPositions(db, "bullish_cross", []string{"TESTUSDT"})
+	var pos PaperPosition
+	db.First(&pos)
+	if pos.LastBarTime != klines[0].OpenTime || pos.EntryPrice != klines[1].Close {
+		t.Fatalf("unexpected position: %+v", pos)
+	}
+	if err := updatePaperPositions(db, "TESTUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	db.First(&pos, pos.ID)
+	if pos.Status != "open" || pos.LastBarTime != klines[0].OpenTime {
+		t.Fatalf("unclosed entry bar should not be checked: %+v", pos)
+	}
+
+	// 开仓所在的K线收盘后触及止损
+	db.Table(table).Where("open_time = ?", klines[1].OpenTime).Updates(map[string]any{"low": pos.EntryPrice * 0.97, "is_closed": true})
+	if err := updatePaperPositions(db, "TESTUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	db.First(&pos, pos.ID)
+	if pos.Status != "closed" || pos.ExitReason != "sl" || pos.ExitTime != klines[1].CloseTime {
+		t.Fatalf("entry bar should be checked once it closes: %+v", pos)
+	}
+}
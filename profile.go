@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ================= 成交量分布与 VWAP =================
+
+// volumeLevel 成交量分布中的一个价格区间
+type volumeLevel struct {
+	PriceLow  float64 `json:"price_low"`
+	PriceHigh float64 `json:"price_high"`
+	Volume    float64 `json:"volume"`
+}
+
+// volumeProfile 时间范围内的成交量分布
+type volumeProfile struct {
+	Symbol      string        `json:"symbol"`
+	From        int64         `json:"from"`
+	To          int64         `json:"to"`
+	Bars        int           `json:"bars"` // 参与计算的15m K线数量
+	BinSize     float64       `json:"bin_size"`
+	TotalVolume float64       `json:"total_volume"`
+	POC         jsonFloat     `json:"poc"`        // 成交量最大的价格区间的中间价
+	ValueArea   float64       `json:"value_area"` // 价值区域包含的成交量占比
+	VAH         jsonFloat     `json:"vah"`        // 价值区域上沿
+	VAL         jsonFloat     `json:"val"`        // 价值区域下沿
+	Levels      []volumeLevel `json:"levels"`     // 按价格升序
+}
+
+// buildVolumeProfile 把K线成交量分配到等宽的价格区间，每根K线的成交量在其最高价和最低价之间均匀分布
+// 价值区域从 POC 开始，每次向成交量较大的一侧扩展一个区间，直到达到 valueArea 占比
+func buildVolumeProfile(klines []Kline, bins int, valueArea float64) volumeProfile {
+	p := volumeProfile{Bars: len(klines), ValueArea: valueArea, POC: jsonFloat(math.NaN()), VAH: jsonFloat(math.NaN()), VAL: jsonFloat(math.NaN()), Levels: []volumeLevel{}}
+	if len(klines) == 0 {
+		return p
+	}
+	lo, hi := klines[0].Low, klines[0].High
+	for _, k := range klines {
+		lo, hi = math.Min(lo, k.Low), math.Max(hi, k.High)
+	}
+	if hi == lo {
+		bins = 1
+	}
+	size := (hi - lo) / float64(bins)
+	binOf := func(price float64) int {
+		if size == 0 {
+			return 0
+		}
+		return min(max(int((price-lo)/size), 0), bins-1)
+	}
+
+	volumes := make([]float64, bins)
+	for _, k := range klines {
+		p.TotalVolume += k.Volume
+		if k.High <= k.Low {
+			volumes[binOf(k.Close)] += k.Volume
+			continue
+		}
+		for i := binOf(k.Low); i <= binOf(k.High); i++ {
+			// 区间与K线价格范围重叠部分的占比
+			overlap := math.Min(k.High, lo+float64(i+1)*size) - math.Max(k.Low, lo+float64(i)*size)
+			if overlap > 0 {
+				volumes[i] += k.Volume * overlap / (k.High - k.Low)
+			}
+		}
+	}
+
+	poc := 0
+	for i, v := range volumes {
+		if v > volumes[poc] {
+			poc = i
+		}
+		p.Levels = append(p.Levels, volumeLevel{PriceLow: lo + float64(i)*size, PriceHigh: lo + float64(i+1)*size, Volume: v})
+	}
+	p.Levels[bins-1].PriceHigh = hi
+	p.BinSize = size
+
+	low, high, sum := poc, poc, volumes[poc]
+	for sum < p.TotalVolume*valueArea && (low > 0 || high < bins-1) {
+		down, up := -1.0, -1.0
+		if low > 0 {
+			down = volumes[low-1]
+		}
+		if high < bins-1 {
+			up = volumes[high+1]
+		}
+		if up >= down {
+			high++
+			sum += up
+		} else {
+			low--
+			sum += down
+		}
+	}
+	p.POC = jsonFloat((p.Levels[poc].PriceLow + p.Levels[poc].PriceHigh) / 2)
+	p.VAL, p.VAH = jsonFloat(p.Levels[low].PriceLow), jsonFloat(p.Levels[high].PriceHigh)
+	return p
+}
+
+// vwapSessions 时段 VWAP 的重置周期，返回K线开盘时间所属时段的起点（UTC）
+var vwapSessions = map[string]func(openTime int64) int64{
+	"day": func(t int64) int64 { return t / intervalMillis("1d") * intervalMillis("1d") },
+	// 1970-01-01 为周四，偏移 3 天使每周从周一开始
+	"week": func(t int64) int64 {
+		week, offset := 7*intervalMillis("1d"), 3*intervalMillis("1d")
+		return (t+offset)/week*week - offset
+	},
+}
+
+// vwapPoint VWAP 序列中的一个点，取值为该K线收盘时的累计值
+type vwapPoint struct {
+	Time  int64   `json:"time"` // 输出周期K线的开盘时间
+	VWAP  float64 `json:"vwap"`
+	Stdev float64 `json:"stdev"` // 成交量加权标准差，VWAP ± n×stdev 为 n 倍通道
+}
+
+// sessionVWAP 以典型价 (H+L+C)/3 计算累计 VWAP 和成交量加权标准差，session 返回的时段起点变化时重新累计
+func sessionVWAP(klines []Kline, session func(openTime int64) int64) (vwap, stdev []float64) {
+	vwap, stdev = make([]float64, len(klines)), make([]float64, len(klines))
+	var pv, pv2, vol float64
+	for i, k := range klines {
+		if i > 0 && session(k.OpenTime) != session(klines[i-1].OpenTime) {
+			pv, pv2, vol = 0, 0, 0
+		}
+		typical := (k.High + k.Low + k.Close) / 3
+		pv += typical * k.Volume
+		pv2 += typical * typical * k.Volume
+		vol += k.Volume
+		if vol > 0 {
+			vwap[i] = pv / vol
+			stdev[i] = math.Sqrt(math.Max(pv2/vol-vwap[i]*vwap[i], 0))
+		} else {
+			vwap[i] = typical
+		}
+	}
+	return
+}
+
+// vwapSeries 在15m K线上计算 VWAP，按输出周期取每个周期最后一根15m K线收盘时的值
+func vwapSeries(klines []Kline, interval string, session func(openTime int64) int64) []vwapPoint {
+	vwap, stdev := sessionVWAP(klines, session)
+	ms := intervalMillis(interval)
+	points := []vwapPoint{}
+	for i, k := range klines {
+		bucket := k.OpenTime / ms * ms
+		if i+1 < len(klines) && klines[i+1].OpenTime/ms*ms == bucket {
+			continue
+		}
+		points = append(points, vwapPoint{Time: bucket, VWAP: vwap[i], Stdev: stdev[i]})
+	}
+	return points
+}
+
+// vwapResponse /analytics/vwap 的响应，Anchor 和 Session 二选一
+type vwapResponse struct {
+	Symbol   string      `json:"symbol"`
+	Interval string      `json:"interval"`
+	Anchor   int64       `json:"anchor,omitempty"`
+	Session  string      `json:"session,omitempty"`
+	Points   []vwapPoint `json:"points"`
+}
+
+// analyticsMaxSpan /analytics 接口单次请求的最长时间范围，约 3000 根15m K线
+const analyticsMaxSpan = 31 * 24 * time.Hour
+
+// checkTimeSpan 检查 from 到 to 不超过 analyticsMaxSpan
+func checkTimeSpan(from, to int64, name string) error {
+	if to-from > analyticsMaxSpan.Milliseconds() {
+		return fmt.Errorf("%s to to must be within %d days", name, analyticsMaxSpan/(24*time.Hour))
+	}
+	return nil
+}
+
+// parseTimeRange 解析 from/to 参数，to 默认为当前时间，from 默认为 to 之前 span，范围不能超过 analyticsMaxSpan
+func parseTimeRange(r *http.Request, span time.Duration) (from, to int64, err error) {
+	q := r.URL.Query()
+	if to, err = parseTimeArg(q.Get("to")); err != nil {
+		return
+	}
+	if to == 0 {
+		to = time.Now().UnixMilli()
+	}
+	if from, err = parseTimeArg(q.Get("from")); err != nil {
+		return
+	}
+	if from == 0 {
+		from = to - span.Milliseconds()
+	}
+	if from >= to {
+		err = errors.New("from must be before to")
+		return
+	}
+	err = checkTimeSpan(from, to, "from")
+	return
+}
+
+// serveKlineAnalytics 按代币数据版本缓存响应，默认时间范围随当前时间滑动，因此最多缓存一根15m K线
+func serveKlineAnalytics(w http.ResponseWriter, r *http.Request, key string, symbol string, compute func() any) {
+	key = fmt.Sprintf("%s:%d:%d", key, dataVersion(symbol), time.Now().UnixMilli()/intervalMillis("15m"))
+	if resp, ok, _ := responseCache.Get(key); ok {
+		writeCached(w, r, resp, true)
+		return
+	}
+	resp, err := newCachedResponse(compute())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	responseCache.Set(key, resp, time.Duration(intervalMillis("15m"))*time.Millisecond)
+	writeCached(w, r, resp, false)
+}
+
+// handleVolumeProfile 返回时间范围内的成交量分布、POC 和价值区域
+// 例如 /analytics/profile?symbol=BTCUSDT&from=2025-01-01&to=2025-01-08&bins=100&value_area=0.7
+func handleVolumeProfile(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		q := r.URL.Query()
+		symbol := q.Get("symbol")
+		if !isTrackedSymbol(symbol) {
+			http.Error(w, fmt.Sprintf("unknown symbol: %s", symbol), http.StatusBadRequest)
+			return
+		}
+		from, to, err := parseTimeRange(r, 24*time.Hour)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bins, valueArea := 50, 0.7
+		if s := q.Get("bins"); s != "" {
+			if bins, err = strconv.Atoi(s); err != nil || bins < 1 || bins > 1000 {
+				http.Error(w, "bins must be between 1 and 1000", http.StatusBadRequest)
+				return
+			}
+		}
+		if s := q.Get("value_area"); s != "" {
+			if valueArea, err = strconv.ParseFloat(s, 64); err != nil || valueArea <= 0 || valueArea > 1 {
+				http.Error(w, "value_area must be in (0, 1]", http.StatusBadRequest)
+				return
+			}
+		}
+		key := fmt.Sprintf("profile:%s:%s:%s:%d:%g", symbol, q.Get("from"), q.Get("to"), bins, valueArea)
+		serveKlineAnalytics(w, r, key, symbol, func() any {
+			p := buildVolumeProfile(getKlineRange(db, symbol, "15m", from, to), bins, valueArea)
+			p.Symbol, p.From, p.To = symbol, from, to
+			return p
+		})
+	}
+}
+
+// handleVWAP 返回从锚点开始累计的 VWAP，或按日/周重置的时段 VWAP
+// 例如 /analytics/vwap?symbol=BTCUSDT&anchor=1735689600000&interval=1h、/analytics/vwap?symbol=BTCUSDT&session=week
+func handleVWAP(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		q := r.URL.Query()
+		symbol := q.Get("symbol")
+		if !isTrackedSymbol(symbol) {
+			http.Error(w, fmt.Sprintf("unknown symbol: %s", symbol), http.StatusBadRequest)
+			return
+		}
+		resp := vwapResponse{Symbol: symbol, Interval: q.Get("interval"), Session: q.Get("session")}
+		if resp.Interval == "" {
+			resp.Interval = "15m"
+		}
+		if intervalMillis(resp.Interval) == 0 {
+			http.Error(w, fmt.Sprintf("unsupported interval %s", resp.Interval), http.StatusBadRequest)
+			return
+		}
+
+		var from, to int64
+		var err error
+		session := func(int64) int64 { return 0 }
+		if s := q.Get("anchor"); s != "" {
+			if resp.Session != "" {
+				http.Error(w, "anchor and session are mutually exclusive", http.StatusBadRequest)
+				return
+			}
+			if resp.Anchor, err = parseTimeArg(s); err == nil {
+				from = resp.Anchor
+				if to, err = parseTimeArg(q.Get("to")); err == nil && to == 0 {
+					to = time.Now().UnixMilli()
+				}
+				if err == nil && from >= to {
+					err = errors.New("anchor must be before to")
+				}
+				if err == nil {
+					err = checkTimeSpan(from, to, "anchor")
+				}
+			}
+		} else {
+			if resp.Session == "" {
+				resp.Session = "day"
+			}
+			var ok bool
+			if session, ok = vwapSessions[resp.Session]; !ok {
+				http.Error(w, "session must be day or week", http.StatusBadRequest)
+				return
+			}
+			if from, to, err = parseTimeRange(r, 7*24*time.Hour); err == nil {
+				// 从第一个时段的起点开始累计
+				from = session(from)
+			}
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		key := fmt.Sprintf("vwap:%s:%s:%s:%s:%s:%s", symbol, resp.Interval, q.Get("anchor"), resp.Session, q.Get("from"), q.Get("to"))
+		serveKlineAnalytics(w, r, key, symbol, func() any {
+			resp.Points = vwapSeries(getKlineRange(db, symbol, "15m", from, to), resp.Interval, session)
+			return resp
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestBuildVolumeProfile(t *testing.T) {
+	klines := []Kline{
+		{Low: 100, High: 110, Close: 105, Volume: 10}, // 每个区间 5
+		{Low: 100, High: 105, Close: 102, Volume: 30}, // 全部落在第一个区间
+		{Low: 108, High: 108, Close: 108, Volume: 4},  // 无波动时计入收盘价所在区间
+		{Low: 115, High: 120, Close: 118, Volume: 6},
+	}
+	p := buildVolumeProfile(klines, 4, 0.7)
+	want := []float64{35, 9, 0, 6}
+	if len(p.Levels) != 4 || p.BinSize != 5 || p.TotalVolume != 50 {
+		t.Fatalf("unexpected profile: %+v", p)
+	}
+	for i, v := range want {
+		if math.Abs(p.Levels[i].Volume-v) > 1e-9 {
+			t.Fatalf("level %d volume = %v, want %v", i, p.Levels[i].Volume, v)
+		}
+	}
+	// POC 所在的第一个区间正好占 70%，80% 时需要向上扩展一个区间
+	if p.POC != 102.5 || p.VAL != 100 || p.VAH != 105 || p.Levels[3].PriceHigh != 120 {
+		t.Fatalf("poc %v val %v vah %v", p.POC, p.VAL, p.VAH)
+	}
+	if p := buildVolumeProfile(klines, 4, 0.8); p.VAL != 100 || p.VAH != 110 {
+		t.Fatalf("value area should extend upwards: %v %v", p.VAL, p.VAH)
+	}
+	if p := buildVolumeProfile(klines, 4, 1); p.VAL != 100 || p.VAH != 120 {
+		t.Fatalf("full value area should cover all levels: %v %v", p.VAL, p.VAH)
+	}
+	if p := buildVolumeProfile(nil, 4, 0.7); !math.IsNaN(float64(p.POC)) || len(p.Levels) != 0 {
+		t.Fatalf("empty profile: %+v", p)
+	}
+}
+
+func TestSessionVWAP(t *testing.T) {
+	day := intervalMillis("1d")
+	quarter := intervalMillis("15m")
+	klines := []Kline{
+		{OpenTime: day - 2*quarter, High: 10, Low: 10, Close: 10, Volume: 1},
+		{OpenTime: day - quarter, High: 20, Low: 20, Close: 20, Volume: 3},
+		{OpenTime: day, High: 30, Low: 30, Close: 30, Volume: 2},
+	}
+	vwap, stdev := sessionVWAP(klines, vwapSessions["day"])
+	if vwap[1] != 17.5 || math.Abs(stdev[1]-math.Sqrt(18.75)) > 1e-9 || vwap[2] != 30 || stdev[2] != 0 {
+		t.Fatalf("vwap %v stdev %v", vwap, stdev)
+	}
+	anchored, _ := sessionVWAP(klines, func(int64) int64 { return 0 })
+	if anchored[2] != (10+60+60)/6.0 {
+		t.Fatalf("anchored vwap should not reset: %v", anchored)
+	}
+	// 2024-01-01 为周一
+	monday := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	if vwapSessions["week"](monday+5*day) != monday || vwapSessions["week"](monday-1) != monday-7*day {
+		t.Fatal("weekly sessions should start on Monday")
+	}
+
+	points := vwapSeries(klines, "1h", vwapSessions["day"])
+	if len(points) != 2 || points[0].Time != day-intervalMillis("1h") || points[0].VWAP != 17.5 || points[1].Time != day {
+		t.Fatalf("unexpected hourly points: %+v", points)
+	}
+}
+
+func TestVolumeProfileEndpoints(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSymbols := symbols
+	t.Cleanup(func() { symbols = oldSymbols })
+	symbols = []string{"BTCUSDT"}
+	klines := fixtureKlines(200, 3)
+	for i := range klines {
+		klines[i].Symbol = "BTCUSDT"
+	}
+	if err := ensureKlineTable(db, "BTCUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	db.Table(Kline{Symbol: "BTCUSDT"}.TableName()).CreateInBatches(klines, 100)
+
+	// 时间范围查询与按数量查询的聚合结果一致，起点向下取整到周期边界
+	from, to := klines[10].OpenTime, klines[150].OpenTime
+	hourly := getKlineRange(db, "BTCUSDT", "1h", from+1, to)
+	all := getAggKlineAsc(db, "BTCUSDT", "1h", 100, false)
+	if len(hourly) == 0 || hourly[0].OpenTime != from/intervalMillis("1h")*intervalMillis("1h") || hourly[len(hourly)-1].OpenTime >= to {
+		t.Fatalf("unexpected range: %d bars", len(hourly))
+	}
+	for _, k := range hourly[:len(hourly)-1] {
+		i := 0
+		for all[i].OpenTime != k.OpenTime {
+			i++
+		}
+		if all[i] != k {
+			t.Fatalf("range bar %+v differs from %+v", k, all[i])
+		}
+	}
+
+	get := func(handler http.HandlerFunc, query string, v any) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, query, nil))
+		json.Unmarshal(rec.Body.Bytes(), v)
+		return rec
+	}
+
+	var profile struct {
+		Bars        int     `json:"bars"`
+		TotalVolume float64 `json:"total_volume"`
+		POC         float64 `json:"poc"`
+		VAL         float64 `json:"val"`
+		VAH         float64 `json:"vah"`
+		Levels      []struct {
+			Volume float64 `json:"volume"`
+		} `json:"levels"`
+	}
+	query := "/analytics/profile?symbol=BTCUSDT&bins=20&from=" + strconv.FormatInt(from, 10) + "&to=" + strconv.FormatInt(to, 10)
+	rec := get(handleVolumeProfile(db), query, &profile)
+	if rec.Code != http.StatusOK || profile.Bars != 140 || len(profile.Levels) != 20 {
+		t.Fatalf("unexpected profile response: %d %s", rec.Code, rec.Body)
+	}
+	var sum float64
+	for _, level := range profile.Levels {
+		sum += level.Volume
+	}
+	if math.Abs(sum-profile.TotalVolume) > 1e-6 || profile.VAL > profile.POC || profile.POC > profile.VAH {
+		t.Fatalf("inconsistent profile: sum %v total %v val %v poc %v vah %v", sum, profile.TotalVolume, profile.VAL, profile.POC, profile.VAH)
+	}
+	if rec := get(handleVolumeProfile(db), query, &profile); rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatal("repeated request should be served from cache")
+	}
+
+	var vwap vwapResponse
+	rec = get(handleVWAP(db), "/analytics/vwap?symbol=BTCUSDT&interval=1h&anchor="+strconv.FormatInt(from, 10)+"&to="+strconv.FormatInt(to, 10), &vwap)
+	want, _ := sessionVWAP(klines[10:150], func(int64) int64 { return 0 })
+	if rec.Code != http.StatusOK || len(vwap.Points) != len(hourly) || vwap.Anchor != from {
+		t.Fatalf("unexpected vwap response: %d %s", rec.Code, rec.Body)
+	}
+	if last := vwap.Points[len(vwap.Points)-1]; math.Abs(last.VWAP-want[len(want)-1]) > 1e-9 {
+		t.Fatalf("anchored vwap = %v, want %v", last.VWAP, want[len(want)-1])
+	}
+
+	for _, bad := range []string{"?symbol=NOPE", "?symbol=BTCUSDT&from=2025-02-01&to=2025-01-01", "?symbol=BTCUSDT&bins=0", "?symbol=BTCUSDT&value_area=2", "?symbol=BTCUSDT&from=2025-01-01&to=2025-03-01"} {
+		if rec := get(handleVolumeProfile(db), "/analytics/profile"+bad, &profile); rec.Code != http.StatusBadRequest {
+			t.Errorf("profile %s: expected 400, got %d", bad, rec.Code)
+		}
+	}
+	for _, bad := range []string{"?symbol=BTCUSDT&session=month", "?symbol=BTCUSDT&anchor=1&session=day", "?symbol=BTCUSDT&interval=5m", "?symbol=BTCUSDT&anchor=bad", "?symbol=BTCUSDT&anchor=2025-01-01&to=2025-03-01", "?symbol=BTCUSDT&from=2025-01-01&to=2025-02-15"} {
+		if rec := get(handleVWAP(db), "/analytics/vwap"+bad, &vwap); rec.Code != http.StatusBadRequest {
+			t.Errorf("vwap %s: expected 400, got %d", bad, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+)
+
+// ================= 收盘K线核对 =================
+
+// reconcileBarsFromEnv 每根15m K线收盘后重新核对的最近K线数量，KLINE_RECONCILE_BARS，默认 4，0 表示关闭
+func reconcileBarsFromEnv() int {
+	bars := 4
+	if v := os.Getenv("KLINE_RECONCILE_BARS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 && n < 1000 {
+			bars = n
+		} else {
+			log.Printf("KLINE_RECONCILE_BARS 配置无效: %s，使用默认值 %d", v, bars)
+		}
+	}
+	return bars
+}
+
+// saveKlines 写入币安返回的15m K线，返回写入的行数
+// 收盘时间早于 now 的K线标记为已收盘；已有的K线在尚未确认收盘或与币安不一致时覆盖
+func saveKlines(db *gorm.DB, symbol string, klines []Kline, now int64) (int, error) {
+	table := Kline{Symbol: symbol}.TableName()
+	changed := 0
+	for _, k := range klines {
+		k.ID, k.Symbol = 0, symbol
+		k.IsClosed = k.CloseTime < now
+		var existing Kline
+		res := db.Table(table).Where("open_time = ?", k.OpenTime).Limit(1).Find(&existing)
+		if res.Error != nil {
+			return changed, res.Error
+		}
+		if res.RowsAffected == 0 {
+			if err := db.Table(table).Create(&k).Error; err != nil {
+				return changed, err
+			}
+			changed++
+			continue
+		}
+		if existing.IsClosed && sameKline(existing, k) && existing.CloseTime == k.CloseTime {
+			continue
+		}
+		err := db.Table(table).Where("id = ?", existing.ID).Updates(map[string]any{
+			"open": k.Open, "high": k.High, "low": k.Low, "close": k.Close, "volume": k.Volume,
+			"close_time": k.CloseTime, "is_closed": k.IsClosed,
+		}).Error
+		if err != nil {
+			return changed, err
+		}
+		if existing.IsClosed {
+			log.Printf("%s %s 的K线与币安不一致，已覆盖", symbol, time.UnixMilli(k.OpenTime).UTC().Format(time.RFC3339))
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// reconcileKlines 重新拉取每个代币最近 bars 根已收盘的15m K线，确认收盘并覆盖与币安不一致的数据
+func reconcileKlines(db *gorm.DB, symbols []string, bars int, now time.Time) error {
+	var g errgroup.Group
+	g.SetLimit(3)
+	for _, sym := range symbols {
+		g.Go(func() error {
+			// 多取一根当前未收盘的K线
+			klines, err := fetchBinanceKlines(sym, "15m", 0, 0, bars+1)
+			if err != nil {
+				log.Println("reconcile error:", sym, err)
+				return err
+			}
+			n, err := saveKlines(db, sym, klines, now.UnixMilli())
+			if err != nil {
+				log.Println("reconcile error:", sym, err)
+				return err
+			}
+			if n > 0 {
+				bumpDataVersion(sym)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestReconcileKlines(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+
+	ms := intervalMillis("15m")
+	now := time.Now()
+	current := now.Truncate(15 * time.Minute).UnixMilli()
+	truth := alignedKlines("BTCUSDT", current-20*ms, 21)
+	truth[20].IsClosed = false
+
+	var mu sync.Mutex
+	var starts []int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		from, _ := strconv.ParseInt(q.Get("startTime"), 10, 64)
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		mu.Lock()
+		starts = append(starts, from)
+		mu.Unlock()
+		var out [][]any
+		for _, k := range truth {
+			if k.OpenTime >= from {
+				out = append(out, []any{k.OpenTime, fmt.Sprint(k.Open), fmt.Sprint(k.High), fmt.Sprint(k.Low), fmt.Sprint(k.Close), fmt.Sprint(k.Volume), k.CloseTime})
+			}
+		}
+		if len(out) > limit {
+			out = out[len(out)-limit:]
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	defer srv.Close()
+	old := binanceFuturesAPI
+	binanceFuturesAPI = srv.URL
+	t.Cleanup(func() { binanceFuturesAPI = old })
+
+	// 第 18 根在收盘前写入，之后只拉取了更新的K线；第 17 根收盘后被修正
+	local := append([]Kline(nil), truth...)
+	local[18].Close, local[18].Volume, local[18].IsClosed = local[18].Open, 1, false
+	local[17].High += 5
+	if err := ensureKlineTable(db, "BTCUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	db.Table(Kline{Symbol: "BTCUSDT"}.TableName()).CreateInBatches(local, 100)
+
+	// 未确认收盘的K线不参与信号和指标，所在的1h也不算收盘
+	closed := getAggKlineAsc(db, "BTCUSDT", "15m", 30, true)
+	if len(closed) != 19 || closed[len(closed)-1].OpenTime != truth[19].OpenTime {
+		t.Fatalf("expected 19 closed bars, got %d", len(closed))
+	}
+	bucket := truth[18].OpenTime / intervalMillis("1h") * intervalMillis("1h")
+	for _, k := range getAggKlineAsc(db, "BTCUSDT", "1h", 10, false) {
+		if complete := k.OpenTime+intervalMillis("1h") <= current; k.IsClosed != (complete && k.OpenTime != bucket) {
+			t.Fatalf("1h bar at %d closed = %v", k.OpenTime, k.IsClosed)
+		}
+	}
+
+	// updateKlines 从最早一根未确认收盘的K线开始拉取
+	if err := updateKlines(db, "BTCUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	if len(starts) != 1 || starts[0] != truth[18].OpenTime {
+		t.Fatalf("expected fetch from the pending bar, got %v", starts)
+	}
+	rows, _ := loadKlineRows(db, "BTCUSDT")
+	if rows[18].Close != truth[18].Close || !rows[18].IsClosed || rows[17].High == truth[17].High || rows[20].IsClosed {
+		t.Fatalf("unexpected rows after update: %+v %+v %+v", rows[17], rows[18], rows[20])
+	}
+
+	// 收盘后的核对覆盖与币安不一致的已收盘K线
+	if err := reconcileKlines(db, []string{"BTCUSDT"}, 4, now); err != nil {
+		t.Fatal(err)
+	}
+	rows, _ = loadKlineRows(db, "BTCUSDT")
+	for i, k := range rows {
+		k.ID = 0
+		if k != truth[i] {
+			t.Fatalf("bar %d = %+v, want %+v", i, k, truth[i])
+		}
+	}
+	if n, _ := saveKlines(db, "BTCUSDT", truth[:20], now.UnixMilli()); n != 0 {
+		t.Fatalf("confirmed bars should not be rewritten, %d changed", n)
+	}
+
+	rec := httptest.NewRecorder()
+	handleKlineQuery(db)(rec, httptest.NewRequest(http.MethodGet, "/klines?symbol=BTCUSDT&interval=15m&limit=5&closed=1", nil))
+	var out [][]any
+	json.Unmarshal(rec.Body.Bytes(), &out)
+	if len(out) != 5 || out[4][0] != float64(truth[19].OpenTime) {
+		t.Fatalf("closed=1 should end at the last closed bar: %s", rec.Body)
+	}
+}
+
+func TestKlineTableMigration(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+
+	// 新增 is_closed 列之前的表结构，以及早先迁移后该列为 NULL 的表
+	hour := intervalMillis("1h")
+	old := alignedKlines("", (time.Now().UnixMilli()-2*24*hour)/hour*hour, 4)
+	recent := alignedKlines("", time.Now().UnixMilli()/hour*hour-hour, 4)
+	for _, symbol := range []string{"LEGACYUSDT", "NULLUSDT"} {
+		table := Kline{Symbol: symbol}.TableName()
+		db.Exec(fmt.Sprintf(`CREATE TABLE %s (id integer PRIMARY KEY AUTOINCREMENT, symbol text, open_time integer, open real, high real, low real, close real, volume real, close_time integer)`, table))
+		for _, k := range append(old, recent...) {
+			db.Exec(fmt.Sprintf(`INSERT INTO %s (symbol, open_time, open, high, low, close, volume, close_time) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, table),
+				symbol, k.OpenTime, k.Open, k.High, k.Low, k.Close, k.Volume, k.CloseTime)
+		}
+	}
+	db.Exec(`ALTER TABLE kline_NULLUSDT ADD COLUMN is_closed numeric`)
+
+	for _, symbol := range []string{"LEGACYUSDT", "NULLUSDT"} {
+		// 重复迁移不改变已设置的收盘状态
+		for range 2 {
+			if err := ensureKlineTable(db, symbol); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rows, _ := loadKlineRows(db, symbol)
+		if len(rows) != 8 {
+			t.Fatalf("%s: expected 8 rows, got %d", symbol, len(rows))
+		}
+		for i, k := range rows {
+			if k.IsClosed != (i < 4) {
+				t.Fatalf("%s: bar %d closed = %v, bars older than a day should be closed", symbol, i, k.IsClosed)
+			}
+		}
+		if bars := getAggKline(db, symbol, "15m", 10); len(bars) != 8 {
+			t.Fatalf("%s: expected 8 15m bars, got %d", symbol, len(bars))
+		}
+		if bars := getAggKlineAsc(db, symbol, "1h", 10, false); len(bars) != 2 || !bars[0].IsClosed || bars[1].IsClosed {
+			t.Fatalf("%s: unexpected 1h bars: %+v", symbol, bars)
+		}
+		var pending Kline
+		db.Table(Kline{Symbol: symbol}.TableName()).Where("is_closed = ?", false).Order("open_time ASC").Limit(1).Find(&pending)
+		if pending.OpenTime != recent[0].OpenTime {
+			t.Fatalf("%s: recent bars should be fetched again, got pending %+v", symbol, pending)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ================= 响应缓存 =================
+
+// cachedResponse 缓存的 JSON 响应体及其 ETag
+type cachedResponse struct {
+	Body []byte `json:"body"`
+	ETag string `json:"etag"`
+}
+
+// responseCache 接口响应缓存，与全局缓存分开以免占用持久化存储
+var responseCache = NewTypedCache[cachedResponse](NewMemoryCache(512), JSONCodec[cachedResponse]{})
+
+// newCachedResponse 序列化数据并以内容摘要作为 ETag
+func newCachedResponse(data any) (cachedResponse, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return cachedResponse{}, err
+	}
+	sum := sha1.Sum(body)
+	return cachedResponse{Body: body, ETag: `"` + hex.EncodeToString(sum[:]) + `"`}, nil
+}
+
+// etagMatch 判断 If-None-Match 是否包含 etag
+func etagMatch(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == etag || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCached 返回缓存的响应，If-None-Match 匹配时返回 304，hit 写入 X-Cache 头
+func writeCached(w http.ResponseWriter, r *http.Request, resp cachedResponse, hit bool) {
+	w.Header().Set("ETag", resp.ETag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if hit {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+	}
+	if etagMatch(r.Header.Get("If-None-Match"), resp.ETag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		gz.Write(resp.Body)
+		return
+	}
+	w.Write(resp.Body)
+}
+
+// ================= 数据版本 =================
+
+// dataVersions 每个代币K线数据的版本号，写入新K线时递增，用作缓存键的一部分
+var dataVersions = struct {
+	sync.Mutex
+	m map[string]uint64
+}{m: map[string]uint64{}}
+
+// bumpDataVersion 代币的K线有变化时调用，使该代币的缓存响应失效
+func bumpDataVersion(symbol string) {
+	dataVersions.Lock()
+	dataVersions.m[symbol]++
+	dataVersions.Unlock()
+}
+
+// dataVersion 返回代币当前的数据版本
+func dataVersion(symbol string) uint64 {
+	dataVersions.Lock()
+	defer dataVersions.Unlock()
+	return dataVersions.m[symbol]
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestKlineResponseCache(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	klines := fixtureKlines(50, 4)
+	if err := ensureKlineTable(db, "TESTUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	table := Kline{Symbol: "TESTUSDT"}.TableName()
+	db.Table(table).Create(klines[:40])
+
+	handler := handleKlineQuery(db)
+	get := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/klines?symbol=TESTUSDT&interval=1h&limit=5", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	first := get("")
+	if first.Code != http.StatusOK || first.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("first request: %d %v", first.Code, first.Header())
+	}
+	second := get("")
+	if second.Header().Get("X-Cache") != "HIT" || second.Body.String() != first.Body.String() {
+		t.Fatal("second request should be served from cache")
+	}
+	if rec := get(first.Header().Get("ETag")); rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", rec.Code)
+	}
+
+	// 写入新K线后数据版本变化，缓存失效
+	db.Table(table).Create(klines[40:])
+	bumpDataVersion("TESTUSDT")
+	third := get(first.Header().Get("ETag"))
+	if third.Code != http.StatusOK || third.Header().Get("X-Cache") != "MISS" || third.Header().Get("ETag") == first.Header().Get("ETag") {
+		t.Fatalf("new bars should invalidate the cache: %d %v", third.Code, third.Header())
+	}
+}
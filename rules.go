@@ -0,0 +1,696 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ================= 信号规则 =================
+
+// Duration 支持在 JSON 中以 "4h"、"30m" 形式书写的时间间隔
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Operand 规则中的取值，指标、价格和常量三选一
+type Operand struct {
+	Indicator string    `json:"indicator,omitempty"` // 指标名称，见 indicatorRegistry
+	Params    []float64 `json:"params,omitempty"`    // 指标参数，省略时使用默认值
+	Output    string    `json:"output,omitempty"`    // 多输出指标的序列名，默认取主输出
+	Price     string    `json:"price,omitempty"`     // open/high/low/close/volume
+	Value     *float64  `json:"value,omitempty"`     // 常量
+	Offset    int       `json:"offset,omitempty"`    // 向前偏移的K线数量，1 表示上一根
+	Interval  string    `json:"interval,omitempty"`  // 取值所在周期，默认为规则周期，只使用已收盘的K线
+	Benchmark string    `json:"benchmark,omitempty"` // rs/beta/corr 的对比代币，默认 BTCUSDT，需在监控列表中
+}
+
+// Condition 规则条件，每个节点只能设置一种类型
+type Condition struct {
+	All     []Condition  `json:"all,omitempty"`     // 全部满足
+	Any     []Condition  `json:"any,omitempty"`     // 任一满足
+	Not     *Condition   `json:"not,omitempty"`     // 取反
+	Compare *CompareCond `json:"compare,omitempty"` // 比较两个取值
+	Cross   *CrossCond   `json:"cross,omitempty"`   // 交叉
+	Count   *CountCond   `json:"count,omitempty"`   // 回看窗口内满足条件的K线数量
+	// 最近确认的价格与指标背离
+	Divergence *DivergenceCond `json:"divergence,omitempty"`
+}
+
+// CompareCond 比较 Left 与 Right
+type CompareCond struct {
+	Left  Operand `json:"left"`
+	Op    string  `json:"op"` // > >= < <= == !=
+	Right Operand `json:"right"`
+}
+
+// CrossCond Fast 在当前K线上穿(up)或下穿(down) Slow
+type CrossCond struct {
+	Fast      Operand `json:"fast"`
+	Slow      Operand `json:"slow"`
+	Direction string  `json:"direction"` // up/down，默认 up
+}
+
+// CountCond 统计最近 Lookback 根K线中 When 成立的数量，并与 Value 比较
+type CountCond struct {
+	When     Condition `json:"when"`
+	Lookback int       `json:"lookback"`
+	Op       string    `json:"op"`
+	Value    int       `json:"value"`
+}
+
+// SignalRule 一条信号规则，对每个代币在 Interval 周期最后一根已收盘K线上求值
+type SignalRule struct {
+	Name     string     `json:"name"`            // 唯一名称，同时作为去重缓存键前缀
+	Title    string     `json:"title"`           // 通知标题
+	Interval string     `json:"interval"`        // 15m/1h/4h/1d
+	Limit    int        `json:"limit"`           // 参与计算的K线数量，设置 Chart 时为派生K线数量，源K线与 /klines 相同
+	MinBars  int        `json:"min_bars"`        // 已收盘K线少于该数量时跳过
+	Cooldown Duration   `json:"cooldown"`        // 同一代币重复提醒的间隔
+	Every    Duration   `json:"every"`           // 检查周期，默认与 Interval 相同，在整点边界后执行
+	Chart    *ChartType `json:"chart,omitempty"` // 在平均K线、砖形图或等幅K线上求值，为空时使用普通K线
+	When     Condition  `json:"when"`            // 触发条件
+}
+
+// signalRules 当前生效的规则集合
+var signalRules []SignalRule
+
+// 构造规则的辅助函数
+func indicatorOperand(name, output string, params ...float64) Operand {
+	return Operand{Indicator: name, Output: output, Params: params}
+}
+
+func priceOperand(field string) Operand {
+	return Operand{Price: field}
+}
+
+func constOperand(v float64) Operand {
+	return Operand{Value: &v}
+}
+
+func (o Operand) shift(n int) Operand {
+	o.Offset += n
+	return o
+}
+
+// MACDRuleParams MACD水上金叉规则的可调参数
+type MACDRuleParams struct {
+	Fast, Slow, Signal int // MACD 参数
+	EMAPeriod          int // 趋势均线周期
+	SlopeBars          int // 均线需连续上行的K线数
+	NegativeBars       int // 回看窗口内柱状图为负的K线数需小于该值
+	HistLookback       int // 统计负柱的回看窗口
+}
+
+var defaultMACDRuleParams = MACDRuleParams{Fast: 12, Slow: 26, Signal: 9, EMAPeriod: 144, SlopeBars: 4, NegativeBars: 5, HistLookback: 6}
+
+// macdBullishCrossRule 按参数构造MACD水上金叉规则：
+// 均线连续上行且价格在均线之上，MACD 在零轴上方金叉，且之前水下柱不超过阈值
+func macdBullishCrossRule(p MACDRuleParams) SignalRule {
+	ema := indicatorOperand("ema", "", float64(p.EMAPeriod))
+	macdParams := []float64{float64(p.Fast), float64(p.Slow), float64(p.Signal)}
+	macd := indicatorOperand("macd", "macd", macdParams...)
+	signal := indicatorOperand("macd", "signal", macdParams...)
+	hist := indicatorOperand("macd", "hist", macdParams...)
+	return SignalRule{
+		Name:     "bullish_cross",
+		Title:    "以下代币出现MACD水上金叉：",
+		Interval: "15m",
+		Limit:    300,
+		MinBars:  26,
+		Cooldown: Duration(4 * time.Hour),
+		When: Condition{All: []Condition{
+			{Count: &CountCond{When: Condition{Compare: &CompareCond{Left: ema, Op: ">", Right: ema.shift(1)}}, Lookback: p.SlopeBars, Op: ">=", Value: p.SlopeBars}},
+			{Compare: &CompareCond{Left: priceOperand("close"), Op: ">", Right: ema}},
+			{Compare: &CompareCond{Left: macd, Op: ">", Right: constOperand(0)}},
+			{Compare: &CompareCond{Left: signal, Op: ">", Right: constOperand(0)}},
+			{Cross: &CrossCond{Fast: macd, Slow: signal, Direction: "up"}},
+			{Count: &CountCond{When: Condition{Compare: &CompareCond{Left: hist, Op: "<", Right: constOperand(0)}}, Lookback: p.HistLookback, Op: "<", Value: p.NegativeBars}},
+		}},
+	}
+}
+
+// defaultSignalRules 未提供规则文件时使用的默认规则集
+func defaultSignalRules() []SignalRule {
+	return []SignalRule{macdBullishCrossRule(defaultMACDRuleParams)}
+}
+
+// loadRulesFromFile 从 JSON 文件读取规则数组，文件不存在时返回默认规则集
+func loadRulesFromFile(filename string) ([]SignalRule, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return defaultSignalRules(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []SignalRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	names := make(map[string]struct{}, len(rules))
+	for i := range rules {
+		if err := rules[i].normalize(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		if _, dup := names[rules[i].Name]; dup {
+			return nil, fmt.Errorf("duplicate rule name: %s", rules[i].Name)
+		}
+		names[rules[i].Name] = struct{}{}
+	}
+	return rules, nil
+}
+
+// normalize 填充默认值并校验规则
+func (r *SignalRule) normalize() error {
+	if r.Name == "" {
+		return errors.New("missing name")
+	}
+	if r.Interval == "" {
+		r.Interval = "15m"
+	}
+	if intervalMillis(r.Interval) == 0 {
+		return fmt.Errorf("%s: unsupported interval %s", r.Name, r.Interval)
+	}
+	if r.Limit <= 0 {
+		r.Limit = 300
+	}
+	if r.Title == "" {
+		r.Title = r.Name + ":"
+	}
+	if r.Cooldown <= 0 {
+		r.Cooldown = Duration(4 * time.Hour)
+	}
+	if r.Every < 0 || (r.Every > 0 && (24*time.Hour)%time.Duration(r.Every) != 0) {
+		return fmt.Errorf("%s: every must divide 24h evenly", r.Name)
+	}
+	if r.Chart != nil {
+		if err := r.Chart.normalize(); err != nil {
+			return fmt.Errorf("%s: %w", r.Name, err)
+		}
+	}
+	if err := r.When.validate(); err != nil {
+		return fmt.Errorf("%s: %w", r.Name, err)
+	}
+	return nil
+}
+
+func validOp(op string) bool {
+	switch op {
+	case ">", ">=", "<", "<=", "==", "!=":
+		return true
+	}
+	return false
+}
+
+func (c Condition) validate() error {
+	kinds := 0
+	if len(c.All) > 0 {
+		kinds++
+		for _, sub := range c.All {
+			if err := sub.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(c.Any) > 0 {
+		kinds++
+		for _, sub := range c.Any {
+			if err := sub.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	if c.Not != nil {
+		kinds++
+		if err := c.Not.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Compare != nil {
+		kinds++
+		if !validOp(c.Compare.Op) {
+			return fmt.Errorf("invalid compare op %q", c.Compare.Op)
+		}
+		if err := c.Compare.Left.validate(); err != nil {
+			return err
+		}
+		if err := c.Compare.Right.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Cross != nil {
+		kinds++
+		if d := c.Cross.Direction; d != "" && d != "up" && d != "down" {
+			return fmt.Errorf("invalid cross direction %q", d)
+		}
+		if err := c.Cross.Fast.validate(); err != nil {
+			return err
+		}
+		if err := c.Cross.Slow.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Count != nil {
+		kinds++
+		if c.Count.Lookback <= 0 {
+			return errors.New("count lookback must be positive")
+		}
+		if !validOp(c.Count.Op) {
+			return fmt.Errorf("invalid count op %q", c.Count.Op)
+		}
+		if err := c.Count.When.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Divergence != nil {
+		kinds++
+		if err := c.Divergence.validate(); err != nil {
+			return err
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("condition must set exactly one of all/any/not/compare/cross/count/divergence, got %d", kinds)
+	}
+	return nil
+}
+
+func (o Operand) validate() error {
+	kinds := 0
+	if o.Indicator != "" {
+		kinds++
+		if isPairIndicator(o.Indicator) {
+			if _, err := computePairIndicator(o.Indicator, nil, nil, o.Params); err != nil {
+				return err
+			}
+			if o.Output != "" {
+				return fmt.Errorf("%s has no output %q", o.Indicator, o.Output)
+			}
+		} else {
+			if _, _, err := computeIndicator(o.Indicator, nil, o.Params); err != nil {
+				return err
+			}
+			if o.Output != "" && !slices.Contains(indicatorRegistry[strings.ToLower(o.Indicator)].Outputs, o.Output) {
+				return fmt.Errorf("%s has no output %q", o.Indicator, o.Output)
+			}
+		}
+	}
+	if o.Benchmark != "" && !isPairIndicator(o.Indicator) {
+		return errors.New("benchmark is only supported by rs/beta/corr")
+	}
+	if o.Price != "" {
+		kinds++
+		switch o.Price {
+		case "open", "high", "low", "close", "volume":
+		default:
+			return fmt.Errorf("invalid price field %q", o.Price)
+		}
+	}
+	if o.Value != nil {
+		kinds++
+	}
+	if kinds != 1 {
+		return errors.New("operand must set exactly one of indicator/price/value")
+	}
+	if o.Offset < 0 {
+		return errors.New("operand offset must not be negative")
+	}
+	if o.Interval != "" && intervalMillis(o.Interval) == 0 {
+		return fmt.Errorf("unsupported operand interval %s", o.Interval)
+	}
+	return nil
+}
+
+// operands 返回条件树中引用的全部取值，按出现顺序
+func (c Condition) operands() []Operand {
+	var result []Operand
+	for _, sub := range c.All {
+		result = append(result, sub.operands()...)
+	}
+	for _, sub := range c.Any {
+		result = append(result, sub.operands()...)
+	}
+	if c.Not != nil {
+		result = append(result, c.Not.operands()...)
+	}
+	if c.Compare != nil {
+		result = append(result, c.Compare.Left, c.Compare.Right)
+	}
+	if c.Cross != nil {
+		result = append(result, c.Cross.Fast, c.Cross.Slow)
+	}
+	if c.Count != nil {
+		result = append(result, c.Count.When.operands()...)
+	}
+	return result
+}
+
+// ================= 规则求值 =================
+
+// klineLoader 按周期加载按时间升序排列的K线，symbol 为空时加载当前代币，否则加载对比代币
+type klineLoader func(symbol, interval string, limit int) []Kline
+
+// dbKlineLoader 从数据库加载已收盘K线，对比代币不在监控列表中时返回空
+func dbKlineLoader(db *gorm.DB, symbol string) klineLoader {
+	return func(sym, interval string, limit int) []Kline {
+		if sym == "" {
+			sym = symbol
+		} else if !isTrackedSymbol(sym) {
+			return nil
+		}
+		return getAggKlineAsc(db, sym, interval, limit, true)
+	}
+}
+
+// ruleFrame 规则求值的数据上下文，K线按时间升序，已计算的序列会被缓存
+type ruleFrame struct {
+	interval    string
+	klines      []Kline
+	series      map[string][]float64
+	benchmarks  map[string][]Kline // 对比代币在本周期的K线
+	divergences map[string][]Divergence
+	limit       int
+	load        klineLoader
+	others      map[string]*alignedFrame // 其他周期的数据，按需加载
+}
+
+// alignedFrame 其他周期的数据及其与基准周期的对齐关系
+type alignedFrame struct {
+	frame *ruleFrame
+	index []int // 基准K线下标 -> 在基准K线收盘时已收盘的最近一根K线下标，-1 表示没有
+}
+
+func newRuleFrame(interval string, klines []Kline, limit int, load klineLoader) *ruleFrame {
+	return &ruleFrame{
+		interval:    interval,
+		klines:      klines,
+		series:      make(map[string][]float64),
+		benchmarks:  make(map[string][]Kline),
+		divergences: make(map[string][]Divergence),
+		limit:       limit,
+		load:        load,
+		others:      make(map[string]*alignedFrame),
+	}
+}
+
+// alignKlines 计算 base 每根K线收盘时 other 中最近一根已收盘K线的下标，保证不会读到未来数据
+func alignKlines(base []Kline, baseInterval string, other []Kline, otherInterval string) []int {
+	baseMs, otherMs := intervalMillis(baseInterval), intervalMillis(otherInterval)
+	index := make([]int, len(base))
+	j := -1
+	for i, k := range base {
+		closeAt := k.OpenTime + baseMs
+		for j+1 < len(other) && other[j+1].OpenTime+otherMs <= closeAt {
+			j++
+		}
+		index[i] = j
+	}
+	return index
+}
+
+// frameFor 返回指定周期的数据，首次访问时通过 load 加载并与基准周期对齐
+func (f *ruleFrame) frameFor(interval string) *alignedFrame {
+	if af, ok := f.others[interval]; ok {
+		return af
+	}
+	var klines []Kline
+	if f.load != nil {
+		klines = f.load("", interval, f.limit)
+	}
+	// 保留 load 以便在该周期上加载对比代币
+	af := &alignedFrame{
+		frame: newRuleFrame(interval, klines, f.limit, f.load),
+		index: alignKlines(f.klines, f.interval, klines, interval),
+	}
+	f.others[interval] = af
+	return af
+}
+
+// key 返回取值对应序列的缓存键，不含偏移
+func (o Operand) key() string {
+	if o.Price != "" {
+		return o.Price
+	}
+	parts := make([]string, len(o.Params))
+	for i, p := range o.Params {
+		parts[i] = strconv.FormatFloat(p, 'f', -1, 64)
+	}
+	key := strings.ToLower(o.Indicator) + "(" + strings.Join(parts, ",") + ")." + o.Output
+	if isPairIndicator(o.Indicator) {
+		key += "@" + o.benchmark()
+	}
+	return key
+}
+
+// benchmark 返回 rs/beta/corr 的对比代币
+func (o Operand) benchmark() string {
+	if o.Benchmark != "" {
+		return o.Benchmark
+	}
+	return defaultBenchmark
+}
+
+// benchmarkKlines 返回对比代币在本周期的K线，首次访问时加载
+func (f *ruleFrame) benchmarkKlines(symbol string) []Kline {
+	if klines, ok := f.benchmarks[symbol]; ok {
+		return klines
+	}
+	var klines []Kline
+	if f.load != nil {
+		klines = f.load(symbol, f.interval, f.limit)
+	}
+	f.benchmarks[symbol] = klines
+	return klines
+}
+
+// seriesOf 返回取值对应的完整序列
+func (f *ruleFrame) seriesOf(o Operand) []float64 {
+	key := o.key()
+	if s, ok := f.series[key]; ok {
+		return s
+	}
+	var s []float64
+	if o.Price != "" {
+		s = make([]float64, len(f.klines))
+		for i, k := range f.klines {
+			switch o.Price {
+			case "open":
+				s[i] = k.Open
+			case "high":
+				s[i] = k.High
+			case "low":
+				s[i] = k.Low
+			case "close":
+				s[i] = k.Close
+			case "volume":
+				s[i] = k.Volume
+			}
+		}
+	} else if isPairIndicator(o.Indicator) {
+		var err error
+		if s, err = computePairIndicator(o.Indicator, f.klines, f.benchmarkKlines(o.benchmark()), o.Params); err != nil {
+			s = nanSeries(len(f.klines))
+		}
+	} else {
+		values, _, err := computeIndicator(o.Indicator, f.klines, o.Params)
+		if err != nil {
+			s = nanSeries(len(f.klines))
+		} else {
+			output := o.Output
+			if output == "" {
+				output = indicatorRegistry[strings.ToLower(o.Indicator)].Outputs[0]
+			}
+			s = values[output]
+		}
+	}
+	f.series[key] = s
+	return s
+}
+
+// value 返回第 i 根K线上的取值，越界或预热期返回 NaN
+func (f *ruleFrame) value(o Operand, i int) float64 {
+	if o.Value != nil {
+		return *o.Value
+	}
+	if o.Interval != "" && o.Interval != f.interval {
+		af := f.frameFor(o.Interval)
+		if i < 0 || i >= len(af.index) || af.index[i] < 0 {
+			return math.NaN()
+		}
+		o.Interval = ""
+		return af.frame.value(o, af.index[i])
+	}
+	idx := i - o.Offset
+	if idx < 0 || idx >= len(f.klines) {
+		return math.NaN()
+	}
+	return f.seriesOf(o)[idx]
+}
+
+// compareValues 按运算符比较，任一值为 NaN 时不成立
+func compareValues(a float64, op string, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return false
+	}
+	switch op {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	}
+	return false
+}
+
+// eval 在第 i 根K线上求值条件，只会读取 i 及之前的数据
+func (c Condition) eval(f *ruleFrame, i int) bool {
+	switch {
+	case len(c.All) > 0:
+		for _, sub := range c.All {
+			if !sub.eval(f, i) {
+				return false
+			}
+		}
+		return true
+	case len(c.Any) > 0:
+		for _, sub := range c.Any {
+			if sub.eval(f, i) {
+				return true
+			}
+		}
+		return false
+	case c.Not != nil:
+		return !c.Not.eval(f, i)
+	case c.Compare != nil:
+		return compareValues(f.value(c.Compare.Left, i), c.Compare.Op, f.value(c.Compare.Right, i))
+	case c.Cross != nil:
+		fastNow, slowNow := f.value(c.Cross.Fast, i), f.value(c.Cross.Slow, i)
+		fastPrev, slowPrev := f.value(c.Cross.Fast, i-1), f.value(c.Cross.Slow, i-1)
+		if c.Cross.Direction == "down" {
+			return compareValues(fastPrev, ">", slowPrev) && compareValues(fastNow, "<", slowNow)
+		}
+		return compareValues(fastPrev, "<", slowPrev) && compareValues(fastNow, ">", slowNow)
+	case c.Count != nil:
+		count := 0
+		for j := i - c.Count.Lookback + 1; j <= i; j++ {
+			if j >= 0 && c.Count.When.eval(f, j) {
+				count++
+			}
+		}
+		return compareValues(float64(count), c.Count.Op, float64(c.Count.Value))
+	case c.Divergence != nil:
+		return c.Divergence.eval(f, i)
+	}
+	return false
+}
+
+// Match 判断规则在最后一根K线上是否成立，klines 需为规则周期按时间升序的已收盘K线，设置了 Chart 时为派生K线，
+// load 用于加载条件中引用的其他周期（普通K线），可为 nil
+func (r SignalRule) Match(klines []Kline, load klineLoader) bool {
+	if len(klines) == 0 || len(klines) < r.MinBars {
+		return false
+	}
+	return r.When.eval(newRuleFrame(r.Interval, klines, r.Limit, load), len(klines)-1)
+}
+
+// schedule 返回规则的检查周期
+func (r SignalRule) schedule() time.Duration {
+	if r.Every > 0 {
+		return time.Duration(r.Every)
+	}
+	return time.Duration(intervalMillis(r.Interval)) * time.Millisecond
+}
+
+// signalResult 一条规则一次检查的结果
+type signalResult struct {
+	Rule     string   `json:"rule"`
+	Matched  []string `json:"matched"`  // 条件成立的代币
+	Notified []string `json:"notified"` // 通过提醒策略、已发送或暂存通知的代币
+}
+
+// CheckSignals 对所有代币依次求值规则，命中的代币经提醒策略过滤后汇总，按路由发送或暂存通知
+func CheckSignals(db *gorm.DB, rules []SignalRule) ([]signalResult, error) {
+	results := make([]signalResult, 0, len(rules))
+	now := time.Now()
+	for _, rule := range rules {
+		result := signalResult{Rule: rule.Name, Matched: []string{}, Notified: []string{}}
+		msg := AlertMessage{Rule: rule.Name, Title: rule.Title, Interval: rule.Interval, Time: now}
+		escalated := msg
+		escalated.Escalated = true
+		for _, symbol := range trackedSymbols() {
+			// 派生K线与 /klines 加载相同数量的源K线，平均K线的开盘价有足够的预热
+			klines := rule.Chart.apply(getAggKlineAsc(db, symbol, rule.Interval, rule.Chart.sourceBars(rule.Limit), true))
+			if len(klines) > rule.Limit {
+				klines = klines[len(klines)-rule.Limit:]
+			}
+			load := dbKlineLoader(db, symbol)
+			if !rule.Match(klines, load) {
+				continue
+			}
+			result.Matched = append(result.Matched, symbol)
+			d, err := notifier.policy.admit(db, rule, symbol, klines[len(klines)-1].OpenTime, now)
+			if err != nil {
+				log.Printf("更新 %s 的提醒状态失败: %v", symbol, err)
+				continue
+			}
+			if !d.Notify {
+				continue
+			}
+			result.Notified = append(result.Notified, symbol)
+			item := newAlertSymbol(db, rule, symbol, klines, load)
+			item.Triggers = d.Triggers
+			if d.Escalated {
+				escalated.Symbols = append(escalated.Symbols, item)
+			} else {
+				msg.Symbols = append(msg.Symbols, item)
+			}
+			if err := recordAlert(db, rule, item); err != nil {
+				log.Printf("保存提醒记录 %s 失败: %v", symbol, err)
+			}
+		}
+		results = append(results, result)
+		if len(result.Notified) == 0 {
+			continue
+		}
+
+		openPaperPositions(db, rule.Name, result.Notified)
+		for _, m := range []AlertMessage{escalated, msg} {
+			if err := notifier.deliverAlert(db, m, now); err != nil {
+				log.Printf("发送通知失败: %v", err)
+			}
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/markcheno/go-talib"
+	"github.com/samber/lo"
+)
+
+// legacyMACDBullishCross 规则引擎之前硬编码的判断逻辑，作为默认规则的对照
+func legacyMACDBullishCross(klines []Kline) bool {
+	closingPrices := make([]float64, len(klines))
+	for i, kline := range klines {
+		closingPrices[i] = kline.Close
+	}
+	emas := talib.Ema(closingPrices, 144)
+	emas = lo.Subset(emas, -5, 5)
+	result1 := lo.ReduceRight(emas, func(agg int, item float64, idx int) int {
+		if idx > 0 && item > emas[idx-1] {
+			return agg + 1
+		}
+		return agg
+	}, 0)
+	lastema, _ := lo.Last(emas)
+	lastprice, _ := lo.Last(closingPrices)
+	if result1 >= 4 && lastprice > lastema {
+		macdLine, signalLine, macdHint := talib.Macd(closingPrices, 12, 26, 9)
+		count := lo.CountBy(lo.Subset(macdHint, -6, 6), func(i float64) bool {
+			return i < 0
+		})
+		return IsBullishCross(macdLine, signalLine) && count < 5
+	}
+	return false
+}
+
+// TestDefaultRuleMatchesLegacy 默认规则与原硬编码逻辑在每个滑动窗口上的结果一致
+func TestDefaultRuleMatchesLegacy(t *testing.T) {
+	rule := defaultSignalRules()[0]
+	for _, seed := range []int64{1, 2, 3} {
+		klines := fixtureKlines(3000, seed)
+		hits := 0
+		for end := rule.Limit; end <= len(klines); end++ {
+			window := klines[end-rule.Limit : end]
+			want := legacyMACDBullishCross(window)
+			if got := rule.Match(window, nil); got != want {
+				t.Fatalf("seed %d bar %d: rule=%v legacy=%v", seed, end-1, got, want)
+			}
+			if want {
+				hits++
+			}
+		}
+		if hits == 0 {
+			t.Fatalf("seed %d: fixture produced no bullish cross, test is not meaningful", seed)
+		}
+	}
+}
+
+// TestRulesFileRoundTrip 默认规则写成 JSON 配置后加载，行为保持一致
+func TestRulesFileRoundTrip(t *testing.T) {
+	data, err := json.Marshal(defaultSignalRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := loadRulesFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Name != "bullish_cross" || time.Duration(rules[0].Cooldown) != 4*time.Hour {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	klines := fixtureKlines(1500, 7)
+	for end := 300; end <= len(klines); end++ {
+		window := klines[end-300 : end]
+		if rules[0].Match(window, nil) != legacyMACDBullishCross(window) {
+			t.Fatalf("bar %d: loaded rule differs from legacy", end-1)
+		}
+	}
+}
+
+func TestRulesFileValidation(t *testing.T) {
+	cases := map[string]string{
+		"unknown indicator": `[{"name":"x","when":{"compare":{"left":{"indicator":"foo"},"op":">","right":{"value":0}}}}]`,
+		"bad op":            `[{"name":"x","when":{"compare":{"left":{"price":"close"},"op":"=>","right":{"value":0}}}}]`,
+		"two kinds":         `[{"name":"x","when":{"not":{"compare":{"left":{"price":"close"},"op":">","right":{"value":0}}},"any":[{"compare":{"left":{"price":"close"},"op":">","right":{"value":0}}}]}}]`,
+		"bad output":        `[{"name":"x","when":{"compare":{"left":{"indicator":"macd","output":"foo"},"op":">","right":{"value":0}}}}]`,
+		"bad interval":      `[{"name":"x","interval":"3m","when":{"compare":{"left":{"price":"close"},"op":">","right":{"value":0}}}}]`,
+	}
+	for name, content := range cases {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadRulesFromFile(path); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+// aggregateKlines 按 getAggKline 的方式将15m K线聚合为更大周期
+func aggregateKlines(klines []Kline, interval string) []Kline {
+	bucketMs := intervalMillis(interval)
+	var out []Kline
+	for _, k := range klines {
+		bucket := k.OpenTime / bucketMs * bucketMs
+		if len(out) == 0 || out[len(out)-1].OpenTime != bucket {
+			k.OpenTime = bucket
+			out = append(out, k)
+			continue
+		}
+		last := &out[len(out)-1]
+		last.High = math.Max(last.High, k.High)
+		last.Low = math.Min(last.Low, k.Low)
+		last.Close = k.Close
+		last.Volume += k.Volume
+		last.CloseTime = k.CloseTime
+	}
+	return out
+}
+
+// TestMultiTimeframeNoLookAhead 高周期取值只能来自在基准K线收盘前已收盘的K线
+func TestMultiTimeframeNoLookAhead(t *testing.T) {
+	base := fixtureKlines(600, 11)
+	hourly := aggregateKlines(base, "1h")
+	load := func(symbol, interval string, limit int) []Kline {
+		if symbol == "" && interval == "1h" {
+			return hourly
+		}
+		return nil
+	}
+	frame := newRuleFrame("15m", base, 300, load)
+	op := Operand{Price: "close", Interval: "1h"}
+	for i, k := range base {
+		got := frame.value(op, i)
+		closeAt := k.OpenTime + intervalMillis("15m")
+		want := math.NaN()
+		for _, h := range hourly {
+			if h.OpenTime+intervalMillis("1h") <= closeAt {
+				want = h.Close
+			}
+		}
+		if !(got == want || (math.IsNaN(got) && math.IsNaN(want))) {
+			t.Fatalf("bar %d: got %v want %v", i, got, want)
+		}
+		// 整点收盘的15m K线恰好结束一根1h K线，此时两者收盘价一致
+		if closeAt%intervalMillis("1h") == 0 && i >= 4 && got != k.Close {
+			t.Fatalf("bar %d: hourly close %v should equal 15m close %v", i, got, k.Close)
+		}
+	}
+
+	// 偏移按高周期K线计算
+	prev := frame.value(Operand{Price: "close", Interval: "1h", Offset: 1}, len(base)-1)
+	idx := frame.frameFor("1h").index[len(base)-1]
+	if prev != hourly[idx-1].Close {
+		t.Fatalf("offset on 1h: got %v want %v", prev, hourly[idx-1].Close)
+	}
+
+	// 跨周期条件：15m 收盘价高于 1h EMA20
+	rule := SignalRule{Name: "mtf", Interval: "15m", Limit: 300, When: Condition{Compare: &CompareCond{
+		Left: priceOperand("close"), Op: ">", Right: Operand{Indicator: "ema", Params: []float64{20}, Interval: "1h"},
+	}}}
+	if err := rule.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	ema := talib.Ema(klineCloses(hourly), 20)
+	last := base[len(base)-1]
+	want := last.Close > ema[idx]
+	if got := rule.Match(base, load); got != want {
+		t.Fatalf("mtf rule: got %v want %v", got, want)
+	}
+}
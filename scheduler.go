@@ -0,0 +1,188 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ================= 信号检查调度 =================
+
+// signalJobStatus 调度任务的运行状态
+type signalJobStatus struct {
+	Every          string         `json:"every"`
+	Rules          []string       `json:"rules"`
+	Running        bool           `json:"running"`
+	NextRun        time.Time      `json:"next_run"`
+	LastStart      time.Time      `json:"last_start"`
+	LastDurationMs int64          `json:"last_duration_ms"`
+	LastError      string         `json:"last_error,omitempty"`
+	Runs           int            `json:"runs"`
+	Skipped        int            `json:"skipped"` // 上一次仍在执行而跳过的次数
+	Results        []signalResult `json:"results"`
+}
+
+// signalJob 同一检查周期的规则合并为一个任务
+type signalJob struct {
+	every   time.Duration
+	rules   []SignalRule
+	running atomic.Bool
+
+	mu     sync.Mutex
+	status signalJobStatus
+}
+
+// SignalScheduler 在K线收盘边界之后延迟 delay 执行信号检查，
+// 检查前重新拉取最近的K线确认收盘，留出时间让 processSymbols 拉取到刚收盘的K线
+type SignalScheduler struct {
+	db    *gorm.DB
+	delay time.Duration
+	jobs  []*signalJob
+
+	reconcileBars int        // 每个15m边界核对的最近K线数量，0 表示不核对
+	reconcileMu   sync.Mutex // 同一边界的任务等待第一次核对完成
+	reconciled    time.Time  // 最近一次核对的15m边界
+}
+
+// NewSignalScheduler 按规则的检查周期分组创建调度器，延迟默认 30 秒，可通过 SIGNAL_DELAY 配置
+func NewSignalScheduler(db *gorm.DB, rules []SignalRule) *SignalScheduler {
+	delay := 30 * time.Second
+	if v := os.Getenv("SIGNAL_DELAY"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			delay = d
+		} else {
+			log.Printf("SIGNAL_DELAY 配置无效: %s，使用默认值 %s", v, delay)
+		}
+	}
+
+	groups := make(map[time.Duration][]SignalRule)
+	for _, rule := range rules {
+		groups[rule.schedule()] = append(groups[rule.schedule()], rule)
+	}
+	s := &SignalScheduler{db: db, delay: delay, reconcileBars: reconcileBarsFromEnv()}
+	for every, group := range groups {
+		job := &signalJob{every: every, rules: group}
+		job.status.Every = every.String()
+		for _, rule := range group {
+			job.status.Rules = append(job.status.Rules, rule.Name)
+		}
+		s.jobs = append(s.jobs, job)
+	}
+	sort.Slice(s.jobs, func(i, j int) bool { return s.jobs[i].every < s.jobs[j].every })
+	return s
+}
+
+// nextRunAfter 返回 now 之后下一个周期边界加延迟的时间，周期边界按 UTC 对齐，与K线开盘时间一致
+func nextRunAfter(now time.Time, every, delay time.Duration) time.Time {
+	next := now.Truncate(every).Add(delay)
+	for !next.After(now) {
+		next = next.Add(every)
+	}
+	return next
+}
+
+// Start 为每个任务启动一个定时协程，并在每个15m边界后核对刚收盘的K线
+func (s *SignalScheduler) Start() {
+	for _, job := range s.jobs {
+		go s.loop(job)
+	}
+	if s.reconcileBars > 0 {
+		go func() {
+			for {
+				time.Sleep(time.Until(nextRunAfter(time.Now(), 15*time.Minute, s.delay/2)))
+				s.reconcile(time.Now())
+			}
+		}()
+	}
+}
+
+// reconcile 每个15m边界只核对一次，信号检查先于定时核对开始时由检查触发
+func (s *SignalScheduler) reconcile(now time.Time) {
+	if s.reconcileBars == 0 {
+		return
+	}
+	boundary := now.Truncate(15 * time.Minute)
+	s.reconcileMu.Lock()
+	defer s.reconcileMu.Unlock()
+	if !boundary.After(s.reconciled) {
+		return
+	}
+	start := time.Now()
+	if err := reconcileKlines(s.db, trackedSymbols(), s.reconcileBars, now); err != nil {
+		log.Printf("核对最近K线失败: %v", err)
+	}
+	s.reconciled = boundary
+	log.Printf("核对最近 %d 根K线完成，耗时 %s", s.reconcileBars, time.Since(start))
+}
+
+func (s *SignalScheduler) loop(job *signalJob) {
+	for {
+		next := nextRunAfter(time.Now(), job.every, s.delay)
+		job.mu.Lock()
+		job.status.NextRun = next
+		job.mu.Unlock()
+
+		time.Sleep(time.Until(next))
+		// 单独协程执行，避免执行过久时错过下一个边界
+		go s.run(job)
+	}
+}
+
+// run 执行一次检查，上一次尚未结束时直接跳过
+func (s *SignalScheduler) run(job *signalJob) {
+	if !job.running.CompareAndSwap(false, true) {
+		job.mu.Lock()
+		job.status.Skipped++
+		job.mu.Unlock()
+		log.Printf("信号检查 %s 上一次尚未结束，跳过本次", job.every)
+		return
+	}
+	defer job.running.Store(false)
+
+	s.reconcile(time.Now())
+	start := time.Now()
+	results, err := CheckSignals(s.db, job.rules)
+	duration := time.Since(start)
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.status.Runs++
+	job.status.LastStart = start
+	job.status.LastDurationMs = duration.Milliseconds()
+	job.status.LastError = ""
+	if err != nil {
+		job.status.LastError = err.Error()
+		log.Printf("信号检查 %s 失败: %v", job.every, err)
+	}
+	job.status.Results = results
+	log.Printf("信号检查 %s 完成，耗时 %s", job.every, duration)
+}
+
+// Status 返回所有任务的状态快照
+func (s *SignalScheduler) Status() []signalJobStatus {
+	statuses := make([]signalJobStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		job.mu.Lock()
+		status := job.status
+		job.mu.Unlock()
+		status.Running = job.running.Load()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// handleSignalStatus 返回调度任务的最近一次运行时间、耗时和结果
+func handleSignalStatus(s *SignalScheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		writeJSON(w, r, s.Status())
+	}
+}
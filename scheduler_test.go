@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestNextRunAfter(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04:05.000", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	delay := 30 * time.Second
+	tests := []struct {
+		now   string
+		every time.Duration
+		want  string
+	}{
+		{"2024-03-01 14:59:59.000", 15 * time.Minute, "2024-03-01 15:00:30.000"},
+		{"2024-03-01 14:59:59.000", time.Hour, "2024-03-01 15:00:30.000"},
+		// 正好在边界上时仍等待延迟，延迟结束的时刻本身不再执行
+		{"2024-03-01 15:00:00.000", 15 * time.Minute, "2024-03-01 15:00:30.000"},
+		{"2024-03-01 15:00:29.999", 15 * time.Minute, "2024-03-01 15:00:30.000"},
+		{"2024-03-01 15:00:30.000", 15 * time.Minute, "2024-03-01 15:15:30.000"},
+		{"2024-03-01 15:00:30.000", time.Hour, "2024-03-01 16:00:30.000"},
+		// 非整点周期按 UTC 零点对齐
+		{"2024-03-01 13:10:00.000", 4 * time.Hour, "2024-03-01 16:00:30.000"},
+		{"2024-03-01 14:59:59.000", 90 * time.Minute, "2024-03-01 15:00:30.000"},
+		{"2024-03-01 15:01:00.000", 90 * time.Minute, "2024-03-01 16:30:30.000"},
+		{"2024-03-01 23:59:59.000", 24 * time.Hour, "2024-03-02 00:00:30.000"},
+		{"2024-03-01 15:04:00.000", 5 * time.Minute, "2024-03-01 15:05:30.000"},
+	}
+	for _, tt := range tests {
+		if got := nextRunAfter(at(tt.now), tt.every, delay); !got.Equal(at(tt.want)) {
+			t.Errorf("nextRunAfter(%s, %s) = %s, want %s", tt.now, tt.every, got.Format(time.TimeOnly), tt.want)
+		}
+	}
+}
+
+func TestSignalSchedulerGroups(t *testing.T) {
+	t.Setenv("SIGNAL_DELAY", "5s")
+	s := NewSignalScheduler(nil, []SignalRule{
+		{Name: "a", Interval: "1h"},
+		{Name: "b", Interval: "15m"},
+		{Name: "c", Interval: "15m", Every: Duration(time.Hour)},
+	})
+	if s.delay != 5*time.Second || len(s.jobs) != 2 {
+		t.Fatalf("unexpected scheduler: delay %s, %d jobs", s.delay, len(s.jobs))
+	}
+	if s.jobs[0].every != 15*time.Minute || len(s.jobs[0].rules) != 1 || s.jobs[1].every != time.Hour || len(s.jobs[1].rules) != 2 {
+		t.Fatalf("rules should be grouped by schedule: %+v %+v", s.jobs[0].status, s.jobs[1].status)
+	}
+}
+
+func TestSignalSchedulerSkipsOverlappingRun(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	oldSymbols := symbols
+	t.Cleanup(func() { symbols = oldSymbols })
+	symbols = []string{"TESTUSDT"}
+
+	// 占用唯一的数据库连接，让第一次检查停在查询K线上
+	conn, err := sqlDB.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := &signalJob{every: time.Hour, rules: []SignalRule{{Name: "slow", Interval: "15m", Limit: 10}}}
+	s := &SignalScheduler{db: db, jobs: []*signalJob{job}}
+	done := make(chan struct{})
+	go func() {
+		s.run(job)
+		close(done)
+	}()
+	for !job.running.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	s.run(job)
+	if status := s.Status()[0]; !status.Running || status.Skipped != 1 || status.Runs != 0 {
+		t.Fatalf("second run should be skipped while the first is running: %+v", status)
+	}
+
+	conn.Close()
+	<-done
+	if status := s.Status()[0]; status.Running || status.Skipped != 1 || status.Runs != 1 || len(status.Results) != 1 {
+		t.Fatalf("first run should complete: %+v", status)
+	}
+	s.run(job)
+	if status := s.Status()[0]; status.Skipped != 1 || status.Runs != 2 {
+		t.Fatalf("run after completion should not be skipped: %+v", status)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+)
+
+// ================= 选币器 =================
+
+// screenerParallel 同时求值的代币数量
+const screenerParallel = 8
+
+// screenerCache 选币器的K线和指标序列缓存，与全局缓存分开以免占用持久化存储
+var screenerCache = NewMemoryCache(20000)
+
+// float64sCodec 以定长二进制编码浮点序列，可以保存 NaN
+type float64sCodec struct{}
+
+func (float64sCodec) Encode(v []float64) ([]byte, error) {
+	b := make([]byte, 8*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint64(b[8*i:], math.Float64bits(f))
+	}
+	return b, nil
+}
+
+func (float64sCodec) Decode(b []byte) ([]float64, error) {
+	if len(b)%8 != 0 {
+		return nil, errors.New("invalid float64 series")
+	}
+	v := make([]float64, len(b)/8)
+	for i := range v {
+		v[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[8*i:]))
+	}
+	return v, nil
+}
+
+var (
+	screenerKlineCache  = NewTypedCache[[]Kline](screenerCache, JSONCodec[[]Kline]{})
+	screenerSeriesCache = NewTypedCache[[]float64](screenerCache, float64sCodec{})
+)
+
+// screenerKlines 返回按时间升序的已收盘K线，查询结果按代币数据版本缓存
+func screenerKlines(db *gorm.DB, symbol, interval string, limit int) []Kline {
+	key := fmt.Sprintf("klines:%s:%s:%d:%d", symbol, interval, limit, dataVersion(symbol))
+	klines, ok, _ := screenerKlineCache.Get(key)
+	if !ok {
+		klines = getAggKline(db, symbol, interval, limit)
+		screenerKlineCache.Set(key, klines, 10*time.Minute)
+	}
+	closed := make([]Kline, 0, len(klines))
+	for i := len(klines) - 1; i >= 0; i-- {
+		if klines[i].IsClosed {
+			closed = append(closed, klines[i])
+		}
+	}
+	return closed
+}
+
+// seriesCacheKey 指标序列只取决于参与计算的K线，以最后一根K线的开盘时间和数量区分，
+// rs/beta/corr 还取决于对比代币的K线，加上对比代币的数据版本
+func seriesCacheKey(symbol string, f *ruleFrame, key string) string {
+	last := f.klines[len(f.klines)-1].OpenTime
+	if _, benchmark, ok := strings.Cut(key, "@"); ok {
+		key = fmt.Sprintf("%s:%d", key, dataVersion(benchmark))
+	}
+	return fmt.Sprintf("series:%s:%s:%d:%d:%s", symbol, f.interval, len(f.klines), last, key)
+}
+
+// screenerQuery 一次选币请求
+type screenerQuery struct {
+	Interval string     // 基准周期，在其最后一根已收盘K线上求值
+	Filter   *Condition // 为 nil 时返回所有代币
+	Columns  []Operand  // 返回的列
+	Bars     int        // 每个周期加载的K线数量
+	Sort     int        // 排序列下标，-1 按代币名称
+	Desc     bool
+	Limit    int
+}
+
+// screenerRow 一个代币的结果，Values 与列对应，无法计算时为 null
+type screenerRow struct {
+	Symbol string     `json:"symbol"`
+	Time   int64      `json:"time"` // 求值K线的开盘时间
+	Close  float64    `json:"close"`
+	Values jsonSeries `json:"values"`
+}
+
+// screenSymbol 在代币最后一根已收盘K线上求值，不满足过滤条件或没有数据时返回 false
+func screenSymbol(db *gorm.DB, symbol string, q screenerQuery) (screenerRow, bool) {
+	load := func(sym, interval string, limit int) []Kline {
+		if sym == "" {
+			sym = symbol
+		} else if !isTrackedSymbol(sym) {
+			return nil
+		}
+		return screenerKlines(db, sym, interval, limit)
+	}
+	klines := load("", q.Interval, q.Bars)
+	if len(klines) == 0 {
+		return screenerRow{}, false
+	}
+	f := newRuleFrame(q.Interval, klines, q.Bars, load)
+
+	// 预先加载引用的周期，并从缓存填充已计算过的序列
+	operands := slices.Clone(q.Columns)
+	if q.Filter != nil {
+		operands = append(operands, q.Filter.operands()...)
+	}
+	frames := []*ruleFrame{f}
+	for _, o := range operands {
+		if o.Interval != "" && o.Interval != q.Interval {
+			if af := f.frameFor(o.Interval); !slices.Contains(frames, af.frame) {
+				frames = append(frames, af.frame)
+			}
+		}
+	}
+	cached := map[string]bool{}
+	for _, frame := range frames {
+		if len(frame.klines) == 0 {
+			continue
+		}
+		for _, o := range operands {
+			if o.Value != nil || (o.Interval != "" && o.Interval != frame.interval) || (o.Interval == "" && frame != f) {
+				continue
+			}
+			key := seriesCacheKey(symbol, frame, o.key())
+			if s, ok, _ := screenerSeriesCache.Get(key); ok && len(s) == len(frame.klines) {
+				frame.series[o.key()] = s
+				cached[key] = true
+			}
+		}
+	}
+	defer func() {
+		for _, frame := range frames {
+			if len(frame.klines) == 0 {
+				continue
+			}
+			ttl := time.Duration(intervalMillis(frame.interval)) * time.Millisecond
+			for k, s := range frame.series {
+				if key := seriesCacheKey(symbol, frame, k); !cached[key] {
+					screenerSeriesCache.Set(key, s, ttl)
+				}
+			}
+		}
+	}()
+
+	last := len(klines) - 1
+	if q.Filter != nil && !q.Filter.eval(f, last) {
+		return screenerRow{}, false
+	}
+	row := screenerRow{Symbol: symbol, Time: klines[last].OpenTime, Close: klines[last].Close, Values: make(jsonSeries, len(q.Columns))}
+	for i, o := range q.Columns {
+		row.Values[i] = f.value(o, last)
+	}
+	return row, true
+}
+
+// runScreener 并发求值所有监控的代币，返回满足条件的代币数量和排序截取后的结果
+func runScreener(db *gorm.DB, symbols []string, q screenerQuery) (int, []screenerRow) {
+	var mu sync.Mutex
+	rows := []screenerRow{}
+	var g errgroup.Group
+	g.SetLimit(screenerParallel)
+	for _, symbol := range symbols {
+		g.Go(func() error {
+			if row, ok := screenSymbol(db, symbol, q); ok {
+				mu.Lock()
+				rows = append(rows, row)
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	sort.Slice(rows, func(i, j int) bool {
+		if q.Sort < 0 {
+			if q.Desc {
+				return rows[i].Symbol > rows[j].Symbol
+			}
+			return rows[i].Symbol < rows[j].Symbol
+		}
+		a, b := rows[i].Values[q.Sort], rows[j].Values[q.Sort]
+		// 无法计算的值总是排在最后
+		if math.IsNaN(a) || math.IsNaN(b) {
+			return !math.IsNaN(a) && math.IsNaN(b)
+		}
+		if q.Desc {
+			return a > b
+		}
+		return a < b
+	})
+	matched := len(rows)
+	if q.Limit > 0 && len(rows) > q.Limit {
+		rows = rows[:q.Limit]
+	}
+	return matched, rows
+}
+
+// splitTopLevel 按不在括号内的逗号拆分，用于 columns 参数
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, s[start:])
+	return slices.DeleteFunc(parts, func(p string) bool { return strings.TrimSpace(p) == "" })
+}
+
+// parseScreenerQuery 解析选币请求，返回请求和列名
+func parseScreenerQuery(r *http.Request) (screenerQuery, []string, error) {
+	v := r.URL.Query()
+	q := screenerQuery{Interval: "15m", Bars: 300, Sort: -1, Desc: true, Limit: 100}
+	if s := v.Get("interval"); s != "" {
+		q.Interval = s
+	}
+	if intervalMillis(q.Interval) == 0 {
+		return q, nil, fmt.Errorf("unsupported interval %s", q.Interval)
+	}
+	var err error
+	if s := v.Get("bars"); s != "" {
+		if q.Bars, err = strconv.Atoi(s); err != nil || q.Bars <= 0 || q.Bars > 1000 {
+			return q, nil, errors.New("bars must be between 1 and 1000")
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 0 {
+			return q, nil, errors.New("limit must not be negative")
+		}
+	}
+	switch v.Get("order") {
+	case "", "desc":
+	case "asc":
+		q.Desc = false
+	default:
+		return q, nil, errors.New("order must be asc or desc")
+	}
+
+	var names []string
+	addColumn := func(o Operand) int {
+		name := formatOperand(o)
+		if i := slices.Index(names, name); i >= 0 {
+			return i
+		}
+		names = append(names, name)
+		q.Columns = append(q.Columns, o)
+		return len(names) - 1
+	}
+	for _, raw := range splitTopLevel(v.Get("columns")) {
+		o, err := parseOperandExpr(raw)
+		if err != nil {
+			return q, nil, fmt.Errorf("column %q: %w", strings.TrimSpace(raw), err)
+		}
+		if o.Value == nil {
+			addColumn(o)
+		}
+	}
+	if s := strings.TrimSpace(v.Get("filter")); s != "" {
+		c, err := parseCondition(s)
+		if err != nil {
+			return q, nil, fmt.Errorf("filter: %w", err)
+		}
+		q.Filter = &c
+		// 过滤条件中的取值也作为列返回
+		for _, o := range c.operands() {
+			if o.Value == nil {
+				addColumn(o)
+			}
+		}
+	}
+	if s := v.Get("sort"); s != "" && s != "symbol" {
+		o, err := parseOperandExpr(s)
+		if err != nil || o.Value != nil {
+			return q, nil, fmt.Errorf("invalid sort %q", s)
+		}
+		q.Sort = addColumn(o)
+	} else {
+		// 按代币名称排序时默认升序
+		q.Desc = v.Get("order") == "desc"
+	}
+	return q, names, nil
+}
+
+// screenerResponse /screener 的响应
+type screenerResponse struct {
+	Interval string        `json:"interval"`
+	Filter   string        `json:"filter"`
+	Columns  []string      `json:"columns"`
+	Scanned  int           `json:"scanned"` // 参与求值的代币数量
+	Matched  int           `json:"matched"` // 满足条件的代币数量，limit 截取前
+	Rows     []screenerRow `json:"rows"`
+}
+
+// handleScreener 对所有监控的代币求值过滤表达式，返回满足条件的代币及各列取值
+// 例如 /screener?filter=rsi(14)@4h<30 and close>ema(200)@1d and volume24h>10M&sort=volume24h
+func handleScreener(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowCORS(w, r) {
+			return
+		}
+		q, names, err := parseScreenerQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		symbols := trackedSymbols()
+		matched, rows := runScreener(db, symbols, q)
+		if names == nil {
+			names = []string{}
+		}
+		writeJSON(w, r, screenerResponse{
+			Interval: q.Interval,
+			Filter:   r.URL.Query().Get("filter"),
+			Columns:  names,
+			Scanned:  len(symbols),
+			Matched:  matched,
+			Rows:     rows,
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestParseCondition(t *testing.T) {
+	c, err := parseCondition("not close > 1 or RSI < 30 and volume24h >= 10M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Any) != 2 || c.Any[0].Not == nil || len(c.Any[1].All) != 2 {
+		t.Fatalf("unexpected precedence: %+v", c)
+	}
+	cmp := c.Any[1].All[1].Compare
+	if formatOperand(cmp.Left) != "qvol(96)@15m" || *cmp.Right.Value != 1e7 || cmp.Op != ">=" {
+		t.Fatalf("unexpected alias compare: %+v", cmp)
+	}
+	if got := formatOperand(c.Any[1].All[0].Compare.Left); got != "rsi(14)" {
+		t.Fatalf("default params should be filled in, got %s", got)
+	}
+
+	c, err = parseCondition("(price above ema(200)@1d || macd.hist[1] < -0.5) && macd crosses below macd.signal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.All) != 2 || len(c.All[0].Any) != 2 || c.All[1].Cross == nil || c.All[1].Cross.Direction != "down" {
+		t.Fatalf("unexpected tree: %+v", c)
+	}
+	if left := c.All[0].Any[1].Compare.Left; formatOperand(left) != "macd(12,26,9).hist[1]" || *c.All[0].Any[1].Compare.Right.Value != -0.5 {
+		t.Fatalf("unexpected offset operand: %+v", left)
+	}
+
+	for _, bad := range []string{"", "close >", "rsi(14 < 30", "foo > 1", "rsi@7m < 30", "close > 1 and", "macd.nope > 0", "close crosses 1", "close > 1 )"} {
+		if _, err := parseCondition(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestScreener(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内存数据库的每个连接是独立的库，并发求值时共用一个连接
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	oldSymbols := symbols
+	t.Cleanup(func() { symbols = oldSymbols })
+	symbols = []string{"AUSDT", "BUSDT", "EMPTYUSDT"}
+	screenerCache = NewMemoryCache(20000)
+	screenerKlineCache = NewTypedCache[[]Kline](screenerCache, JSONCodec[[]Kline]{})
+	screenerSeriesCache = NewTypedCache[[]float64](screenerCache, float64sCodec{})
+
+	end := time.Now().Truncate(15 * time.Minute).UnixMilli()
+	for i, symbol := range symbols {
+		if err := ensureKlineTable(db, symbol); err != nil {
+			t.Fatal(err)
+		}
+		if symbol == "EMPTYUSDT" {
+			continue
+		}
+		klines := fixtureKlines(400, int64(i+1))
+		shift := end - klines[len(klines)-1].OpenTime
+		for j := range klines {
+			klines[j].Symbol = symbol
+			klines[j].OpenTime += shift
+			klines[j].CloseTime += shift
+			klines[j].IsClosed = j < len(klines)-1 // 当前周期未收盘
+			if i == 1 {
+				klines[j].Open, klines[j].High, klines[j].Low, klines[j].Close = klines[j].Open/2, klines[j].High/2, klines[j].Low/2, klines[j].Close/2
+			}
+		}
+		db.Table(Kline{Symbol: symbol}.TableName()).CreateInBatches(klines, 100)
+	}
+
+	handler := handleScreener(db)
+	get := func(params url.Values) (*httptest.ResponseRecorder, screenerResponse) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/screener?"+params.Encode(), nil))
+		var resp screenerResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	rec, resp := get(url.Values{"filter": {"close > 0 and rsi@1h >= 0"}, "columns": {"macd(12,26,9).hist,rsi(14)"}, "sort": {"close"}, "order": {"asc"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+	}
+	want := []string{"macd(12,26,9).hist", "rsi(14)", "close", "rsi(14)@1h"}
+	if len(resp.Columns) != len(want) || resp.Scanned != 3 || resp.Matched != 2 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	for i, name := range want {
+		if resp.Columns[i] != name {
+			t.Fatalf("columns = %v, want %v", resp.Columns, want)
+		}
+	}
+	if resp.Rows[0].Symbol != "BUSDT" || resp.Rows[0].Close >= resp.Rows[1].Close {
+		t.Fatalf("rows should be sorted by close ascending: %+v", resp.Rows)
+	}
+
+	// 列的取值与直接计算指标一致，只使用已收盘的K线
+	closed := getAggKlineAsc(db, "AUSDT", "15m", 300, true)
+	values, _, _ := computeIndicator("rsi", closed, nil)
+	row := resp.Rows[1]
+	if row.Time != closed[len(closed)-1].OpenTime || math.Abs(row.Values[1]-values["rsi"][len(closed)-1]) > 1e-9 {
+		t.Fatalf("rsi mismatch: %+v vs %v", row, values["rsi"][len(closed)-1])
+	}
+
+	// 第二次请求从缓存读取序列，结果相同
+	key := seriesCacheKey("AUSDT", newRuleFrame("15m", closed, 300, nil), Operand{Indicator: "rsi", Params: []float64{14}}.key())
+	if _, ok, _ := screenerSeriesCache.Get(key); !ok {
+		t.Fatal("indicator series should be cached per bar")
+	}
+	rec2, _ := get(url.Values{"filter": {"close > 0 and rsi@1h >= 0"}, "columns": {"macd(12,26,9).hist,rsi(14)"}, "sort": {"close"}, "order": {"asc"}})
+	if rec2.Body.String() != rec.Body.String() {
+		t.Fatal("cached evaluation should give the same result")
+	}
+
+	// 对比代币的数据更新后 rs/beta/corr 的缓存失效，其他指标不受影响
+	frame := newRuleFrame("15m", closed, 300, nil)
+	pair := Operand{Indicator: "rs", Benchmark: "BUSDT"}.key()
+	pairKey := seriesCacheKey("AUSDT", frame, pair)
+	bumpDataVersion("BUSDT")
+	if seriesCacheKey("AUSDT", frame, pair) == pairKey {
+		t.Fatal("pair indicator key should change with the benchmark data")
+	}
+	if seriesCacheKey("AUSDT", frame, Operand{Indicator: "rsi", Params: []float64{14}}.key()) != key {
+		t.Fatal("single symbol indicator key should not depend on other symbols")
+	}
+
+	if _, resp := get(url.Values{"filter": {"close > 1000000"}}); resp.Matched != 0 || len(resp.Rows) != 0 {
+		t.Fatalf("no symbol should match: %+v", resp)
+	}
+	// 没有过滤条件时列出所有有数据的代币，数据不足的列为 null
+	if rec, resp := get(url.Values{"columns": {"ema(1000)@1d"}, "limit": {"1"}}); resp.Matched != 2 || len(resp.Rows) != 1 || resp.Rows[0].Symbol != "AUSDT" || !strings.Contains(rec.Body.String(), `"values":[null]`) {
+		t.Fatalf("unexpected response: %s", rec.Body)
+	}
+	for _, bad := range []url.Values{{"filter": {"rsi <"}}, {"interval": {"5m"}}, {"sort": {"nope"}}, {"columns": {"rsi(0)"}}, {"bars": {"0"}}} {
+		if rec, _ := get(bad); rec.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected 400, got %d", bad, rec.Code)
+		}
+	}
+}
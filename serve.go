@@ -7,8 +7,10 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gorm.io/gorm"
@@ -43,29 +45,84 @@ func (w *gzipResponseWriter) WriteHeader(statusCode int) {
 	w.ResponseWriter.WriteHeader(statusCode)
 }
 
-func handleSymbols() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// 允许跨域
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// allowCORS 写入跨域响应头，预检请求返回 true 表示已处理完毕
+func allowCORS(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	return r.Method == http.MethodOptions
+}
 
-		if r.Method == http.MethodOptions {
-			return // 处理预检请求
-		}
+// writeJSON 以 JSON 返回数据，客户端支持时使用 gzip 压缩
+func writeJSON(w http.ResponseWriter, r *http.Request, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		json.NewEncoder(gz).Encode(data)
+		return
+	}
+	json.NewEncoder(w).Encode(data)
+}
 
-		// 不支持 gzip，直接返回
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(symbols)
+// symbolsFile 监控代币列表文件
+var symbolsFile = "symbols.json"
+
+// symbolsMu 保护 symbols，启动后只能通过下面的函数读写
+var symbolsMu sync.RWMutex
+
+// trackedSymbols 返回当前监控代币列表的副本
+func trackedSymbols() []string {
+	symbolsMu.RLock()
+	defer symbolsMu.RUnlock()
+	return slices.Clone(symbols)
+}
+
+// isTrackedSymbol 判断是否为 symbols.json 中监控的代币，拼接表名前用于校验
+func isTrackedSymbol(symbol string) bool {
+	symbolsMu.RLock()
+	defer symbolsMu.RUnlock()
+	return slices.Contains(symbols, symbol)
+}
+
+// watchSymbol 把代币加入监控列表并写回 symbols.json，已存在时返回 false
+func watchSymbol(db *gorm.DB, symbol string) (bool, error) {
+	symbolsMu.Lock()
+	defer symbolsMu.Unlock()
+	if slices.Contains(symbols, symbol) {
+		return false, nil
+	}
+	if err := ensureKlineTable(db, symbol); err != nil {
+		return false, err
+	}
+	list := append(slices.Clone(symbols), symbol)
+	if err := saveSymbolsToFile(symbolsFile, list); err != nil {
+		return false, err
+	}
+	symbols = list
+	return true, nil
+}
+
+// unwatchSymbol 把代币移出监控列表并写回 symbols.json，K线表由定时清理任务删除
+func unwatchSymbol(symbol string) (bool, error) {
+	symbolsMu.Lock()
+	defer symbolsMu.Unlock()
+	i := slices.Index(symbols, symbol)
+	if i < 0 {
+		return false, nil
 	}
+	list := slices.Delete(slices.Clone(symbols), i, i+1)
+	if err := saveSymbolsToFile(symbolsFile, list); err != nil {
+		return false, err
+	}
+	symbols = list
+	return true, nil
 }
-func handleHotSymbols() http.HandlerFunc {
+
+func handleSymbols() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		js, err := json.Marshal(HotList())
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		// 允许跨域
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
@@ -73,8 +130,10 @@ func handleHotSymbols() http.HandlerFunc {
 		if r.Method == http.MethodOptions {
 			return // 处理预检请求
 		}
+
+		// 不支持 gzip，直接返回
 		w.Header().Set("Content-Type", "application/json")
-		w.Write(js)
+		json.NewEncoder(w).Encode(trackedSymbols())
 	}
 }
 
@@ -82,11 +141,7 @@ func handleHotSymbols() http.HandlerFunc {
 func handleKlineQuery(db *gorm.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// 允许跨域
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == http.MethodOptions {
+		if allowCORS(w, r) {
 			return // 处理预检请求
 		}
 		symbol := r.URL.Query().Get("symbol")
@@ -100,31 +155,39 @@ func handleKlineQuery(db *gorm.DB) http.HandlerFunc {
 		if err != nil {
 			limitCount = 100
 		}
-		time1 := time.Now()
+		chart, err := chartTypeFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		closedOnly := r.URL.Query().Get("closed") == "1" || r.URL.Query().Get("closed") == "true"
 
-		data, err := queryAggregatedKlines(db, symbol, interval, limitCount)
+		// 缓存键带有代币的数据版本，updateKlines 写入新K线后旧的响应自然失效
+		key := fmt.Sprintf("klines:%s:%s:%d:%s:%t:%d", symbol, interval, limitCount, chart.key(), closedOnly, dataVersion(symbol))
+		if resp, ok, _ := responseCache.Get(key); ok {
+			writeCached(w, r, resp, true)
+			return
+		}
+		time1 := time.Now()
+		data, err := queryAggregatedKlines(db, symbol, interval, limitCount, chart, closedOnly)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("query error: %v", err), http.StatusInternalServerError)
 			return
 		}
 		fmt.Println("统计", time.Since(time1).Milliseconds())
-
-		// 判断是否支持 gzip
-		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			w.Header().Set("Content-Encoding", "gzip")
-			gz := gzip.NewWriter(w)
-			defer gz.Close()
-
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(gz).Encode(data)
+		resp, err := newCachedResponse(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		// 不支持 gzip，直接返回
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(data)
+		responseCache.Set(key, resp, klineCacheTTL)
+		writeCached(w, r, resp, false)
 	}
 }
+
+// klineCacheTTL /klines 响应的最长缓存时间，正常情况下由数据版本失效
+const klineCacheTTL = time.Hour
+
 func loadSymbolsFromFile(filename string) ([]string, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
@@ -137,3 +200,16 @@ func loadSymbolsFromFile(filename string) ([]string, error) {
 	}
 	return symbols, nil
 }
+
+// saveSymbolsToFile 先写临时文件再重命名，避免写到一半时文件损坏
+func saveSymbolsToFile(filename string, list []string) error {
+	data, err := json.MarshalIndent(list, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
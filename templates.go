@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ================= 提醒消息模板 =================
+
+// 消息正文的格式，决定模板中 esc/url 的转义方式和 Telegram 的 parse_mode
+const (
+	formatPlain      = ""
+	formatHTML       = "html"
+	formatMarkdownV2 = "markdownv2"
+)
+
+// AlertMessage 提醒消息模板的数据
+type AlertMessage struct {
+	Rule      string        `json:"rule"`
+	Title     string        `json:"title"`
+	Interval  string        `json:"interval"`
+	Time      time.Time     `json:"time"`
+	Escalated bool          `json:"escalated,omitempty"` // 代币反复触发，升级提醒
+	Symbols   []AlertSymbol `json:"symbols"`
+}
+
+// AlertSymbol 提醒中单个代币的行情和指标
+type AlertSymbol struct {
+	Symbol     string             `json:"symbol"`
+	BarTime    int64              `json:"bar_time"`   // 触发K线的开盘时间
+	Price      float64            `json:"price"`      // 触发K线的收盘价
+	Change24h  float64            `json:"change_24h"` // 24h 涨跌幅（百分比），数据不足时为 0
+	Volume24h  float64            `json:"volume_24h"` // 24h 成交额（USDT），由15m K线估算
+	Indicators map[string]float64 `json:"indicators"` // 规则条件中的取值，键与提醒记录相同
+	ChartURL   string             `json:"chart_url,omitempty"`
+	Triggers   int                `json:"triggers"` // 升级观察窗口内的触发次数
+}
+
+// newAlertSymbol 根据规则周期的已收盘K线和本地15m数据生成模板数据
+func newAlertSymbol(db *gorm.DB, rule SignalRule, symbol string, klines []Kline, load klineLoader) AlertSymbol {
+	s := AlertSymbol{Symbol: symbol, ChartURL: chartURL(symbol, rule.Interval)}
+	if len(klines) == 0 {
+		return s
+	}
+	last := klines[len(klines)-1]
+	s.BarTime, s.Price = last.OpenTime, last.Close
+	s.Indicators = rule.snapshot(klines, load)
+
+	// 15m K线倒序，第 96 根为 24h 前
+	day := getAggKline(db, symbol, "15m", 97)
+	if len(day) > 96 && day[96].Close > 0 {
+		s.Change24h = (day[0].Close/day[96].Close - 1) * 100
+	}
+	for i := 0; i < len(day) && i < 96; i++ {
+		s.Volume24h += day[i].Volume * day[i].Close
+	}
+	// 派生K线的收盘价不是成交价，使用最新15m收盘价
+	if rule.Chart != nil && len(day) > 0 {
+		s.Price = day[0].Close
+	}
+	return s
+}
+
+// chartURL 返回代币K线图的地址，未设置 CHART_BASE_URL 时为空
+func chartURL(symbol, interval string) string {
+	base := strings.TrimRight(os.Getenv("CHART_BASE_URL"), "/")
+	if base == "" {
+		return ""
+	}
+	q := url.Values{"symbol": {symbol}, "interval": {interval}}
+	return base + "/chart.png?" + q.Encode()
+}
+
+// alertLabels 内置模板使用的文字，键为语言
+var alertLabels = map[string]map[string]string{
+	"zh": {
+		"change":    "24h涨跌",
+		"volume":    "成交额",
+		"chart":     "K线图",
+		"escalated": "【反复触发】",
+		"triggers":  "次",
+	},
+	"en": {
+		"change": "24h",
+		"volume": "vol",
+		"chart":  "chart",
+		// 内置规则的标题，自定义标题原样使用
+		"title:以下代币出现MACD水上金叉：": "MACD bullish cross above zero:",
+	},
+}
+
+// 内置模板，按格式区分，文字通过 t 函数按语言取得
+var builtinTemplates = map[string]string{
+	formatPlain: `{{if .Escalated}}{{t "escalated"}} {{end}}{{.Title}}
+{{range .Symbols}}- {{.Symbol}} {{price .Price}} {{t "change"}} {{pct .Change24h}} {{t "volume"}} {{vol .Volume24h}}{{if gt .Triggers 1}} {{.Triggers}}{{t "triggers"}}{{end}}{{with .ChartURL}} {{.}}{{end}}
+{{end}}`,
+	formatHTML: `<b>{{if .Escalated}}{{esc (t "escalated")}} {{end}}{{esc .Title}}</b>
+{{range .Symbols}}• {{if .ChartURL}}<a href="{{url .ChartURL}}">{{esc .Symbol}}</a>{{else}}<b>{{esc .Symbol}}</b>{{end}} {{esc (price .Price)}} {{esc (t "change")}} {{esc (pct .Change24h)}} {{esc (t "volume")}} {{esc (vol .Volume24h)}}{{if gt .Triggers 1}} {{.Triggers}}{{esc (t "triggers")}}{{end}}
+{{end}}`,
+	formatMarkdownV2: `*{{if .Escalated}}{{esc (t "escalated")}} {{end}}{{esc .Title}}*
+{{range .Symbols}}• {{if .ChartURL}}[{{esc .Symbol}}]({{url .ChartURL}}){{else}}*{{esc .Symbol}}*{{end}} {{esc (price .Price)}} {{esc (t "change")}} {{esc (pct .Change24h)}} {{esc (t "volume")}} {{esc (vol .Volume24h)}}{{if gt .Triggers 1}} {{.Triggers}}{{esc (t "triggers")}}{{end}}
+{{end}}`,
+}
+
+var parsedBuiltinTemplates = func() map[string]*template.Template {
+	parsed := map[string]*template.Template{}
+	for format, text := range builtinTemplates {
+		parsed[format] = template.Must(parseMessageTemplate(format, text))
+	}
+	return parsed
+}()
+
+// markdownV2Escaper 转义 Telegram MarkdownV2 的全部保留字符
+var markdownV2Escaper = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`, "~", `\~`, "`", "\\`",
+	">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`, "|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+// escapeText 按格式转义普通文字
+func escapeText(format, s string) string {
+	switch format {
+	case formatHTML:
+		return html.EscapeString(s)
+	case formatMarkdownV2:
+		return markdownV2Escaper.Replace(s)
+	}
+	return s
+}
+
+// escapeURL 按格式转义链接地址，MarkdownV2 的链接内只需转义 ) 和 \
+func escapeURL(format, s string) string {
+	switch format {
+	case formatHTML:
+		return html.EscapeString(s)
+	case formatMarkdownV2:
+		return strings.NewReplacer(`\`, `\\`, ")", `\)`).Replace(s)
+	}
+	return s
+}
+
+// formatVolume 以 K/M/B 缩写金额
+func formatVolume(v float64) string {
+	switch a := math.Abs(v); {
+	case a >= 1e9:
+		return fmt.Sprintf("%.2fB", v/1e9)
+	case a >= 1e6:
+		return fmt.Sprintf("%.2fM", v/1e6)
+	case a >= 1e3:
+		return fmt.Sprintf("%.2fK", v/1e3)
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+// templateFuncs 模板可用的函数，esc/url/t 依赖渠道格式和语言
+func templateFuncs(format, locale string) template.FuncMap {
+	return template.FuncMap{
+		"esc": func(s string) string { return escapeText(format, s) },
+		"url": func(s string) string { return escapeURL(format, s) },
+		"t": func(key string) string {
+			if v, ok := alertLabels[locale][key]; ok {
+				return v
+			}
+			return alertLabels["zh"][key]
+		},
+		"price": func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) },
+		"pct":   func(v float64) string { return fmt.Sprintf("%+.2f%%", v) },
+		"num":   func(v float64) string { return strconv.FormatFloat(v, 'g', 6, 64) },
+		"vol":   formatVolume,
+		"time":  func(t time.Time) string { return t.Format(time.DateTime) },
+	}
+}
+
+func parseMessageTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs(formatPlain, "zh")).Option("missingkey=zero").Parse(text)
+}
+
+// messageTemplates 自定义模板，键为规则名称和渠道名称，"*" 匹配任意规则或渠道
+type messageTemplates struct {
+	locale string
+	custom map[string]map[string]*template.Template
+}
+
+// alertLocale 返回 ALERT_LOCALE 指定的语言，默认中文
+func alertLocale() string {
+	if os.Getenv("ALERT_LOCALE") == "en" {
+		return "en"
+	}
+	return "zh"
+}
+
+// newMessageTemplates 解析 notify.json 中的模板，locale 为空时使用 ALERT_LOCALE
+func newMessageTemplates(locale string, texts map[string]map[string]string) (*messageTemplates, error) {
+	if locale == "" {
+		locale = alertLocale()
+	}
+	if _, ok := alertLabels[locale]; !ok {
+		return nil, fmt.Errorf("unknown locale %q", locale)
+	}
+	m := &messageTemplates{locale: locale, custom: map[string]map[string]*template.Template{}}
+	for rule, channels := range texts {
+		m.custom[rule] = map[string]*template.Template{}
+		for channel, text := range channels {
+			t, err := parseMessageTemplate(rule+"/"+channel, text)
+			if err != nil {
+				return nil, fmt.Errorf("template %s/%s: %w", rule, channel, err)
+			}
+			m.custom[rule][channel] = t
+		}
+	}
+	return m, nil
+}
+
+// lookup 依次查找 规则/渠道、规则/*、*/渠道、*/*，都没有时使用该格式的内置模板
+func (m *messageTemplates) lookup(rule, channel, format string) *template.Template {
+	for _, r := range []string{rule, "*"} {
+		for _, c := range []string{channel, "*"} {
+			if t, ok := m.custom[r][c]; ok {
+				return t
+			}
+		}
+	}
+	return parsedBuiltinTemplates[format]
+}
+
+// title 返回内置规则标题的译文
+func (m *messageTemplates) title(title string) string {
+	locale := alertLocale()
+	if m != nil {
+		locale = m.locale
+	}
+	if v, ok := alertLabels[locale]["title:"+title]; ok {
+		return v
+	}
+	return title
+}
+
+// render 以渠道的格式和配置的语言渲染提醒消息，m 为 nil 时只使用内置模板
+func (m *messageTemplates) render(channel, format string, msg AlertMessage) (string, error) {
+	if m == nil {
+		m = &messageTemplates{locale: alertLocale()}
+	}
+	msg.Title = m.title(msg.Title)
+	t, err := m.lookup(msg.Rule, channel, format).Clone()
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := t.Funcs(templateFuncs(format, m.locale)).Execute(&sb, msg); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
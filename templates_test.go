@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAlertMessage() AlertMessage {
+	return AlertMessage{
+		Rule:     "bullish_cross",
+		Title:    "以下代币出现MACD水上金叉：",
+		Interval: "15m",
+		Symbols: []AlertSymbol{{
+			Symbol:     "1000PEPE_USDT",
+			Price:      0.012345,
+			Change24h:  -3.456,
+			Volume24h:  12_345_678,
+			Indicators: map[string]float64{"ema(144).ema": 0.0118},
+			ChartURL:   "https://k.example.com/chart.png?interval=15m&symbol=1000PEPE_USDT",
+		}},
+	}
+}
+
+func TestRenderBuiltinTemplates(t *testing.T) {
+	zh, err := newMessageTemplates("zh", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := testAlertMessage()
+
+	text, err := zh.render("slack", formatPlain, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "以下代币出现MACD水上金叉：\n- 1000PEPE_USDT 0.012345 24h涨跌 -3.46% 成交额 12.35M " + msg.Symbols[0].ChartURL + "\n"
+	if text != want {
+		t.Fatalf("plain = %q, want %q", text, want)
+	}
+
+	text, _ = zh.render("tg", formatHTML, msg)
+	if !strings.Contains(text, `<a href="https://k.example.com/chart.png?interval=15m&amp;symbol=1000PEPE_USDT">1000PEPE_USDT</a>`) {
+		t.Fatalf("unexpected html: %q", text)
+	}
+
+	en, _ := newMessageTemplates("en", nil)
+	text, _ = en.render("tg", formatMarkdownV2, msg)
+	want = "*MACD bullish cross above zero:*\n• [1000PEPE\\_USDT](" + msg.Symbols[0].ChartURL + ") 0\\.012345 24h \\-3\\.46% vol 12\\.35M\n"
+	if text != want {
+		t.Fatalf("markdown = %q, want %q", text, want)
+	}
+
+	if _, err := newMessageTemplates("fr", nil); err == nil {
+		t.Fatal("expected unknown locale error")
+	}
+}
+
+func TestCustomTemplates(t *testing.T) {
+	m, err := newMessageTemplates("en", map[string]map[string]string{
+		"bullish_cross": {"tg": `<b>{{esc .Title}}</b>{{range .Symbols}} {{esc .Symbol}} ema={{num (index .Indicators "ema(144).ema")}}{{end}}`},
+		"*":             {"*": `{{.Rule}}: {{len .Symbols}}`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := testAlertMessage()
+	msg.Title = "a < b"
+	if text, _ := m.render("tg", formatHTML, msg); text != "<b>a &lt; b</b> 1000PEPE_USDT ema=0.0118" {
+		t.Fatalf("rule template = %q", text)
+	}
+	if text, _ := m.render("slack", formatPlain, msg); text != "bullish_cross: 1" {
+		t.Fatalf("fallback template = %q", text)
+	}
+
+	if _, err := newMessageTemplates("", map[string]map[string]string{"*": {"*": "{{.Nope"}}); err == nil {
+		t.Fatal("expected parse error")
+	}
+}
+
+func TestRouterRendersPerChannel(t *testing.T) {
+	tg, tgBodies, _ := recordingServer(t, 0, 0)
+	slack, slackBodies, _ := recordingServer(t, 0, 0)
+	router, err := newNotifyRouter(notifyConfig{
+		Channels: map[string]channelConfig{
+			"tg":    {Type: "telegram", TelegramNotifier: TelegramNotifier{APIBase: tg.URL, Token: "t", ChatID: "1", ParseMode: "MarkdownV2"}},
+			"slack": {Type: "slack", URL: slack.URL},
+		},
+		Locale: "en",
+		Retry:  &retryPolicy{Attempts: 1, Backoff: Duration(time.Millisecond)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := testAlertMessage()
+	if err := router.Send(Notification{Rule: msg.Rule, Text: "fallback", Message: &msg}); err != nil {
+		t.Fatal(err)
+	}
+
+	var tgPayload, slackPayload map[string]any
+	json.Unmarshal((*tgBodies)[0], &tgPayload)
+	json.Unmarshal((*slackBodies)[0], &slackPayload)
+	if tgPayload["parse_mode"] != "MarkdownV2" || !strings.Contains(tgPayload["text"].(string), `1000PEPE\_USDT`) {
+		t.Fatalf("unexpected telegram payload: %v", tgPayload)
+	}
+	if text := slackPayload["text"].(string); !strings.HasPrefix(text, "MACD bullish cross above zero:\n- 1000PEPE_USDT") {
+		t.Fatalf("unexpected slack text: %q", text)
+	}
+
+	// 没有模板数据的系统消息不设置 parse_mode
+	if err := router.Send(Notification{Text: "日报 1.5%"}); err != nil {
+		t.Fatal(err)
+	}
+	tgPayload = nil
+	json.Unmarshal((*tgBodies)[1], &tgPayload)
+	if _, ok := tgPayload["parse_mode"]; ok || tgPayload["text"] != "日报 1.5%" {
+		t.Fatalf("system message should be plain: %v", tgPayload)
+	}
+}
@@ -2,39 +2,139 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"mime/multipart"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
-func TelegramSendMessage(message string) error {
-	if botToken == "" || chatID == "" {
-		return nil
-	}
+// telegramAPIBase Telegram Bot API 的默认地址
+const telegramAPIBase = "https://api.telegram.org"
 
-	url := "https://api.telegram.org/bot" + botToken + "/sendMessage"
+// telegramCall 调用 Bot API 方法并把 result 解析到 out，out 可为 nil
+func telegramCall(ctx context.Context, client *http.Client, base, token, method string, payload, out any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramURL(base, token, method), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return telegramDo(client, req, method, out)
+}
 
-	data := map[string]string{
-		"chat_id": chatID,
-		"text":    message,
+// telegramSendPhoto 以 multipart 上传 PNG 图片，replyTo 为 0 时不引用消息
+func telegramSendPhoto(ctx context.Context, client *http.Client, base, token, chatID string, img NotificationImage, replyTo int64) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("chat_id", chatID)
+	if img.Caption != "" {
+		mw.WriteField("caption", img.Caption)
+	}
+	if replyTo != 0 {
+		mw.WriteField("reply_to_message_id", strconv.FormatInt(replyTo, 10))
+	}
+	part, err := mw.CreateFormFile("photo", img.Name)
+	if err != nil {
+		return err
+	}
+	part.Write(img.Data)
+	if err := mw.Close(); err != nil {
+		return err
 	}
 
-	jsonData, err := json.Marshal(data)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramURL(base, token, "sendPhoto"), &body)
 	if err != nil {
 		return err
 	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return telegramDo(client, req, "sendPhoto", nil)
+}
+
+func telegramURL(base, token, method string) string {
+	if base == "" {
+		base = telegramAPIBase
+	}
+	return strings.TrimRight(base, "/") + "/bot" + token + "/" + method
+}
 
-	// 发送POST请求
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
+// telegramDo 发送请求并解析 Bot API 的响应，失败时返回带状态码的错误以便判断是否重试
+func telegramDo(client *http.Client, req *http.Request, method string, out any) error {
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	// 检查响应状态
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("发送Telegram消息失败，状态码: %d", resp.StatusCode)
+	var result struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
 	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		if resp.StatusCode >= 300 {
+			return &httpStatusError{Status: resp.StatusCode, Body: method}
+		}
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if !result.OK {
+		return &httpStatusError{Status: resp.StatusCode, Body: method + ": " + result.Description}
+	}
+	if out != nil {
+		return json.Unmarshal(result.Result, out)
+	}
+	return nil
+}
 
+// TelegramNotifier 通过 Bot API 向频道或用户发送消息，通知带有图片时回复该消息逐张发送
+type TelegramNotifier struct {
+	APIBase string `json:"api_base,omitempty"` // 默认 https://api.telegram.org
+	Token   string `json:"token"`
+	ChatID  string `json:"chat_id"`
+	// 提醒消息的格式 HTML/MarkdownV2/plain，默认 HTML；系统消息始终为纯文本
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+func (t *TelegramNotifier) Name() string { return "telegram" }
+
+func (t *TelegramNotifier) Format() string {
+	switch strings.ToLower(t.ParseMode) {
+	case "", formatHTML:
+		return formatHTML
+	case formatMarkdownV2:
+		return formatMarkdownV2
+	}
+	return formatPlain
+}
+
+// telegramParseModes 正文格式对应的 parse_mode
+var telegramParseModes = map[string]string{formatHTML: "HTML", formatMarkdownV2: "MarkdownV2"}
+
+func (t *TelegramNotifier) Notify(ctx context.Context, n Notification) error {
+	if t.Token == "" || t.ChatID == "" {
+		return nil
+	}
+	var sent struct {
+		MessageID int64 `json:"message_id"`
+	}
+	payload := map[string]string{"chat_id": t.ChatID, "text": n.Text}
+	if mode, ok := telegramParseModes[n.Format]; ok {
+		payload["parse_mode"] = mode
+	}
+	if err := telegramCall(ctx, notifyHTTPClient, t.APIBase, t.Token, "sendMessage", payload, &sent); err != nil {
+		return err
+	}
+	// 文字已经送达，图片失败只记录日志，避免重试时重复发送文字
+	for _, img := range n.Images {
+		if err := telegramSendPhoto(ctx, notifyHTTPClient, t.APIBase, t.Token, t.ChatID, img, sent.MessageID); err != nil {
+			log.Printf("发送图片 %s 失败: %v", img.Name, err)
+		}
+	}
 	return nil
 }
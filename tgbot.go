@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ================= Telegram 机器人 =================
+
+type telegramMessage struct {
+	MessageID int64 `json:"message_id"`
+	From      *struct {
+		ID int64 `json:"id"`
+	} `json:"from,omitempty"`
+	Chat struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Text string `json:"text"`
+}
+
+type telegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *telegramMessage `json:"message,omitempty"`
+}
+
+// botCommand 命令处理函数，Handler 返回回复的文本，Photo 返回图片和说明，二者设置其一
+type botCommand struct {
+	Usage   string
+	Help    string
+	Handler func(ctx context.Context, args []string) (string, error)
+	Photo   func(ctx context.Context, args []string) ([]byte, string, error)
+}
+
+// TelegramBot 通过 getUpdates 长轮询接收命令，只响应白名单中的聊天或用户
+type TelegramBot struct {
+	APIBase string
+	Token   string
+	Allowed map[int64]bool // 允许的 chat id 或 user id
+
+	db     *gorm.DB
+	client *http.Client
+	offset int64
+
+	// 以下依赖可在测试中替换
+	hotList        func() HotPairList
+	validateSymbol func(symbol string) error
+
+	commands map[string]botCommand
+}
+
+// pollTimeout getUpdates 长轮询的等待秒数
+const pollTimeout = 30
+
+// NewTelegramBot 创建机器人并注册命令
+func NewTelegramBot(db *gorm.DB, apiBase, token string, allowed map[int64]bool) *TelegramBot {
+	if apiBase == "" {
+		apiBase = telegramAPIBase
+	}
+	b := &TelegramBot{
+		APIBase: apiBase,
+		Token:   token,
+		Allowed: allowed,
+		db:      db,
+		client:  &http.Client{Timeout: (pollTimeout + 15) * time.Second},
+		hotList: hotPairs.list,
+		validateSymbol: func(symbol string) error {
+			klines, err := fetchBinanceKlines(symbol, "15m", 0, 0, 1)
+			if err != nil || len(klines) == 0 {
+				return fmt.Errorf("币安没有合约 %s", symbol)
+			}
+			return nil
+		},
+	}
+	b.commands = map[string]botCommand{
+		"price":   {Usage: "/price BTCUSDT", Help: "最新价格和涨跌幅", Handler: b.cmdPrice},
+		"hot":     {Usage: "/hot [数量]", Help: "24h 涨幅榜", Handler: b.cmdHot},
+		"chart":   {Usage: "/chart ETHUSDT [4h]", Help: "K线图", Photo: b.cmdChart},
+		"signals": {Usage: "/signals", Help: "规则列表和最近 24h 的提醒", Handler: b.cmdSignals},
+		"watch":   {Usage: "/watch [list|add|remove] SYMBOL", Help: "管理监控列表", Handler: b.cmdWatch},
+		"mute":    {Usage: "/mute 2h|off", Help: "暂停规则提醒", Handler: b.cmdMute},
+	}
+	return b
+}
+
+// NewTelegramBotFromEnv 从环境变量创建机器人，白名单为 TELEGRAM_ALLOWED_IDS 加上 TELEGRAM_CHAT_ID
+// 没有 token 或白名单为空时返回 nil
+func NewTelegramBotFromEnv(db *gorm.DB) *TelegramBot {
+	if botToken == "" || os.Getenv("TELEGRAM_BOT") == "off" {
+		return nil
+	}
+	allowed := map[int64]bool{}
+	for _, v := range strings.Split(os.Getenv("TELEGRAM_ALLOWED_IDS")+","+chatID, ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			allowed[id] = true
+		}
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+	return NewTelegramBot(db, os.Getenv("TELEGRAM_API_BASE"), botToken, allowed)
+}
+
+// Run 循环拉取更新直到 ctx 结束，网络错误时等待后重试
+func (b *TelegramBot) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		var updates []telegramUpdate
+		payload := map[string]any{"offset": b.offset, "timeout": pollTimeout, "allowed_updates": []string{"message"}}
+		if err := telegramCall(ctx, b.client, b.APIBase, b.Token, "getUpdates", payload, &updates); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Telegram getUpdates 失败: %v", err)
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		for _, u := range updates {
+			b.offset = u.UpdateID + 1
+			if u.Message != nil {
+				b.handle(ctx, *u.Message)
+			}
+		}
+	}
+}
+
+// authorized 消息所在聊天或发送者在白名单中
+func (b *TelegramBot) authorized(msg telegramMessage) bool {
+	if b.Allowed[msg.Chat.ID] {
+		return true
+	}
+	return msg.From != nil && b.Allowed[msg.From.ID]
+}
+
+// handle 解析命令并回复，非命令消息和未授权的消息直接忽略
+func (b *TelegramBot) handle(ctx context.Context, msg telegramMessage) {
+	fields := strings.Fields(msg.Text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return
+	}
+	if !b.authorized(msg) {
+		log.Printf("忽略未授权的 Telegram 消息，chat %d", msg.Chat.ID)
+		return
+	}
+	// 群组中的命令可能带有 @机器人名称
+	name, _, _ := strings.Cut(strings.TrimPrefix(fields[0], "/"), "@")
+	name = strings.ToLower(name)
+
+	cmd, ok := b.commands[name]
+	if !ok {
+		b.reply(ctx, msg, b.help())
+		return
+	}
+	if cmd.Photo != nil {
+		photo, caption, err := cmd.Photo(ctx, fields[1:])
+		if err == nil {
+			img := NotificationImage{Name: name + ".png", Caption: caption, Data: photo}
+			chat := strconv.FormatInt(msg.Chat.ID, 10)
+			if err := telegramSendPhoto(ctx, b.client, b.APIBase, b.Token, chat, img, msg.MessageID); err != nil {
+				log.Printf("Telegram 发送图片失败: %v", err)
+			}
+			return
+		}
+		b.reply(ctx, msg, "错误: "+err.Error()+"\n用法: "+cmd.Usage)
+		return
+	}
+	text, err := cmd.Handler(ctx, fields[1:])
+	if err != nil {
+		text = "错误: " + err.Error() + "\n用法: " + cmd.Usage
+	}
+	b.reply(ctx, msg, text)
+}
+
+func (b *TelegramBot) reply(ctx context.Context, msg telegramMessage, text string) {
+	payload := map[string]any{"chat_id": msg.Chat.ID, "text": text, "reply_to_message_id": msg.MessageID}
+	if err := telegramCall(ctx, b.client, b.APIBase, b.Token, "sendMessage", payload, nil); err != nil {
+		log.Printf("Telegram 回复失败: %v", err)
+	}
+}
+
+func (b *TelegramBot) help() string {
+	names := make([]string, 0, len(b.commands))
+	for name := range b.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	sb.WriteString("可用命令：\n")
+	for _, name := range names {
+		fmt.Fprintf(&sb, "%s - %s\n", b.commands[name].Usage, b.commands[name].Help)
+	}
+	return sb.String()
+}
+
+// normalizeSymbol 统一为大写并补全 USDT 后缀，例如 btc -> BTCUSDT
+func normalizeSymbol(s string) string {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if !strings.HasSuffix(s, "USDT") {
+		s += "USDT"
+	}
+	return s
+}
+
+// trackedArg 解析第一个参数为监控中的代币
+func trackedArg(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("缺少代币")
+	}
+	symbol := normalizeSymbol(args[0])
+	if !isTrackedSymbol(symbol) {
+		return "", fmt.Errorf("%s 不在监控列表中", symbol)
+	}
+	return symbol, nil
+}
+
+// percentChange 返回 (to/from - 1) 的百分比文本
+func percentChange(from, to float64) string {
+	if from <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.2f%%", (to/from-1)*100)
+}
+
+func (b *TelegramBot) cmdPrice(ctx context.Context, args []string) (string, error) {
+	symbol, err := trackedArg(args)
+	if err != nil {
+		return "", err
+	}
+	// 15m K线倒序，第 4 根为 1h 前，第 96 根为 24h 前
+	klines := getAggKline(b.db, symbol, "15m", 97)
+	if len(klines) == 0 {
+		return "", fmt.Errorf("%s 暂无数据", symbol)
+	}
+	last := klines[0]
+	text := fmt.Sprintf("%s %s\n", symbol, strconv.FormatFloat(last.Close, 'f', -1, 64))
+	if len(klines) > 4 {
+		text += "1h: " + percentChange(klines[4].Close, last.Close) + "\n"
+	}
+	if len(klines) > 96 {
+		text += "24h: " + percentChange(klines[96].Close, last.Close) + "\n"
+	}
+	text += "更新于 " + time.UnixMilli(last.CloseTime).Format(time.DateTime)
+	return text, nil
+}
+
+func (b *TelegramBot) cmdHot(ctx context.Context, args []string) (string, error) {
+	n := 10
+	if len(args) > 0 {
+		if v, err := strconv.Atoi(args[0]); err == nil && v > 0 {
+			n = v
+		}
+	}
+	list := b.hotList()
+	if len(list) < n {
+		n = len(list)
+	}
+	var sb strings.Builder
+	sb.WriteString("24h 涨幅榜：\n")
+	for i, p := range list[:n] {
+		fmt.Fprintf(&sb, "%d. %s %+.2f%% 成交额 %.1fM\n", i+1, p.Symbol, p.Percent, p.QuoteVolume/1e6)
+	}
+	return sb.String(), nil
+}
+
+// cmdChart 返回K线图，说明中附带最新K线、近 48 根的高低点、RSI 和 MACD 柱
+func (b *TelegramBot) cmdChart(ctx context.Context, args []string) ([]byte, string, error) {
+	symbol, err := trackedArg(args)
+	if err != nil {
+		return nil, "", err
+	}
+	interval := "1h"
+	if len(args) > 1 {
+		interval = args[1]
+	}
+	if intervalMillis(interval) == 0 {
+		return nil, "", fmt.Errorf("不支持的周期 %s", interval)
+	}
+	photo, err := renderSymbolChart(b.db, symbol, interval, defaultChartOptions)
+	if err != nil {
+		return nil, "", err
+	}
+
+	klines := getAggKlineAsc(b.db, symbol, interval, 200, false)
+	last := klines[len(klines)-1]
+	window := klines[max(0, len(klines)-48):]
+	high, low := math.Inf(-1), math.Inf(1)
+	for _, k := range window {
+		high = math.Max(high, k.High)
+		low = math.Min(low, k.Low)
+	}
+	text := fmt.Sprintf("%s %s\n开 %g 高 %g 低 %g 收 %g\n", symbol, interval, last.Open, last.High, last.Low, last.Close)
+	text += fmt.Sprintf("近 %d 根: 高 %g 低 %g 涨跌 %s\n", len(window), high, low, percentChange(window[0].Open, last.Close))
+	if rsi, _, err := computeIndicator("rsi", klines, nil); err == nil {
+		if v := rsi["rsi"][len(klines)-1]; !math.IsNaN(v) {
+			text += fmt.Sprintf("RSI14: %.1f\n", v)
+		}
+	}
+	if macd, _, err := computeIndicator("macd", klines, nil); err == nil {
+		if v := macd["hist"][len(klines)-1]; !math.IsNaN(v) {
+			text += fmt.Sprintf("MACD 柱: %.6g\n", v)
+		}
+	}
+	return photo, text, nil
+}
+
+func (b *TelegramBot) cmdSignals(ctx context.Context, args []string) (string, error) {
+	rules := signalRules
+	if len(rules) == 0 {
+		rules = defaultSignalRules()
+	}
+	var sb strings.Builder
+	sb.WriteString("规则：\n")
+	for _, r := range rules {
+		fmt.Fprintf(&sb, "- %s (%s) %s\n", r.Name, r.Interval, r.Title)
+	}
+	if until, muted := mutedUntil(time.Now()); muted {
+		fmt.Fprintf(&sb, "提醒已静音至 %s\n", until.Format(time.DateTime))
+	}
+
+	var alerts []AlertRecord
+	since := time.Now().Add(-24 * time.Hour).UnixMilli()
+	if err := b.db.Where("bar_time >= ?", since).Order("bar_time DESC").Limit(20).Find(&alerts).Error; err != nil {
+		return "", err
+	}
+	if len(alerts) == 0 {
+		sb.WriteString("最近 24h 没有提醒")
+		return sb.String(), nil
+	}
+	sb.WriteString("最近 24h 的提醒：\n")
+	for _, a := range alerts {
+		fmt.Fprintf(&sb, "%s %s %s @ %g\n", time.UnixMilli(a.BarTime).Format("01-02 15:04"), a.Rule, a.Symbol, a.Price)
+	}
+	return sb.String(), nil
+}
+
+func (b *TelegramBot) cmdWatch(ctx context.Context, args []string) (string, error) {
+	if len(args) == 0 || args[0] == "list" {
+		list := trackedSymbols()
+		return fmt.Sprintf("监控中 %d 个代币：\n%s", len(list), strings.Join(list, " ")), nil
+	}
+	if len(args) < 2 {
+		return "", errors.New("缺少代币")
+	}
+	symbol := normalizeSymbol(args[1])
+	switch args[0] {
+	case "add":
+		if err := b.validateSymbol(symbol); err != nil {
+			return "", err
+		}
+		added, err := watchSymbol(b.db, symbol)
+		if err != nil {
+			return "", err
+		}
+		if !added {
+			return symbol + " 已在监控列表中", nil
+		}
+		return "已添加 " + symbol + "，下一轮更新开始拉取K线", nil
+	case "remove":
+		removed, err := unwatchSymbol(symbol)
+		if err != nil {
+			return "", err
+		}
+		if !removed {
+			return symbol + " 不在监控列表中", nil
+		}
+		return "已移除 " + symbol, nil
+	}
+	return "", fmt.Errorf("未知操作 %s", args[0])
+}
+
+func (b *TelegramBot) cmdMute(ctx context.Context, args []string) (string, error) {
+	if len(args) == 0 {
+		if until, muted := mutedUntil(time.Now()); muted {
+			return "提醒已静音至 " + until.Format(time.DateTime), nil
+		}
+		return "提醒未静音", nil
+	}
+	if args[0] == "off" {
+		muteNotifications(time.Now())
+		return "已取消静音", nil
+	}
+	d, err := time.ParseDuration(args[0])
+	if err != nil || d <= 0 {
+		return "", fmt.Errorf("无效的时长 %s", args[0])
+	}
+	until := time.Now().Add(d)
+	muteNotifications(until)
+	return "规则提醒已静音至 " + until.Format(time.DateTime), nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeTelegram 第一次 getUpdates 返回 updates，之后返回空列表，sendMessage 的内容写入 replies
+type fakeTelegram struct {
+	mu      sync.Mutex
+	updates []telegramUpdate
+	replies chan map[string]any
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var payload map[string]any
+	if strings.HasSuffix(r.URL.Path, "/sendPhoto") {
+		// 图片以 multipart 上传，记录表单字段和图片大小
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		payload = map[string]any{"method": "sendPhoto"}
+		for k, v := range r.MultipartForm.Value {
+			payload[k] = v[0]
+		}
+		if files := r.MultipartForm.File["photo"]; len(files) == 1 {
+			payload["photo_size"] = files[0].Size
+		}
+		f.replies <- payload
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": map[string]any{}})
+		return
+	}
+	json.NewDecoder(r.Body).Decode(&payload)
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/getUpdates"):
+		f.mu.Lock()
+		updates := f.updates
+		f.updates = nil
+		f.mu.Unlock()
+		if updates == nil {
+			time.Sleep(10 * time.Millisecond)
+			updates = []telegramUpdate{}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": updates})
+	case strings.HasSuffix(r.URL.Path, "/sendMessage"):
+		f.replies <- payload
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": map[string]any{"message_id": 100}})
+	default:
+		json.NewEncoder(w).Encode(map[string]any{"ok": false, "description": "unknown method"})
+	}
+}
+
+func botMessage(id, chat int64, text string) telegramUpdate {
+	msg := &telegramMessage{MessageID: id, Text: text}
+	msg.Chat.ID = chat
+	return telegramUpdate{UpdateID: id, Message: msg}
+}
+
+func TestTelegramBotCommands(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	klines := fixtureKlines(120, 3)
+	for i := range klines {
+		klines[i].Symbol = "BTCUSDT"
+	}
+	if err := ensureKlineTable(db, "BTCUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	db.Table(Kline{Symbol: "BTCUSDT"}.TableName()).Create(&klines)
+
+	oldSymbols, oldFile := symbols, symbolsFile
+	t.Cleanup(func() { symbols, symbolsFile = oldSymbols, oldFile })
+	symbols = []string{"BTCUSDT"}
+	symbolsFile = filepath.Join(t.TempDir(), "symbols.json")
+
+	fake := &fakeTelegram{replies: make(chan map[string]any, 10)}
+	fake.updates = []telegramUpdate{
+		botMessage(1, 999, "/price BTCUSDT"), // 不在白名单
+		botMessage(2, 42, "hello"),           // 不是命令
+		botMessage(3, 42, "/price btc"),
+		botMessage(4, 42, "/watch@autokline_bot add sol"),
+		botMessage(5, 42, "/hot 1"),
+		botMessage(6, 42, "/mute 2h"),
+		botMessage(7, 42, "/nope"),
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	bot := NewTelegramBot(db, srv.URL, "token", map[int64]bool{42: true})
+	bot.hotList = func() HotPairList {
+		return HotPairList{{Symbol: "PEPE", Percent: 12.5, QuoteVolume: 3e7}, {Symbol: "DOGE", Percent: 3}}
+	}
+	bot.validateSymbol = func(string) error { return nil }
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go bot.Run(ctx)
+
+	want := []struct {
+		id       float64
+		contains string
+	}{
+		{3, "BTCUSDT " + strconv.FormatFloat(klines[len(klines)-1].Close, 'f', -1, 64)},
+		{4, "已添加 SOLUSDT"},
+		{5, "1. PEPE +12.50%"},
+		{6, "已静音至"},
+		{7, "可用命令"},
+	}
+	for _, w := range want {
+		select {
+		case reply := <-fake.replies:
+			if reply["chat_id"] != float64(42) || reply["reply_to_message_id"] != w.id {
+				t.Fatalf("unexpected reply target: %v", reply)
+			}
+			if text, _ := reply["text"].(string); !strings.Contains(text, w.contains) {
+				t.Fatalf("reply to %v = %q, want %q", w.id, text, w.contains)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no reply for message %v", w.id)
+		}
+	}
+	select {
+	case reply := <-fake.replies:
+		t.Fatalf("unexpected extra reply: %v", reply)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	data, err := os.ReadFile(symbolsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []string
+	json.Unmarshal(data, &saved)
+	if !slices.Equal(saved, []string{"BTCUSDT", "SOLUSDT"}) || !isTrackedSymbol("SOLUSDT") {
+		t.Fatalf("watch list not persisted: %s", data)
+	}
+	if _, muted := mutedUntil(time.Now()); !muted {
+		t.Fatal("expected notifications to be muted")
+	}
+	muteNotifications(time.Now())
+	if _, muted := mutedUntil(time.Now()); muted {
+		t.Fatal("expected mute to be cleared")
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ================= 提醒策略 =================
+
+// notifyPolicy notify.json 中的提醒策略，零值时只按规则的 cooldown 去重、立即发送
+type notifyPolicy struct {
+	SymbolCooldown Duration    `json:"symbol_cooldown,omitempty"` // 同一代币在任意规则下的最短提醒间隔
+	Digest         Duration    `json:"digest,omitempty"`          // 摘要窗口，窗口内的提醒合并后发送
+	MaxPerHour     int         `json:"max_per_hour,omitempty"`    // 每小时最多发送的提醒消息数，超出的暂存
+	QuietHours     *quietHours `json:"quiet_hours,omitempty"`     // 静默时段内的提醒暂存到结束后发送
+	EscalateAfter  int         `json:"escalate_after,omitempty"`  // 观察窗口内同一代币触发达到该次数时升级
+	EscalateWindow Duration    `json:"escalate_window,omitempty"` // 升级的观察窗口，默认 24h
+	EscalateTo     []string    `json:"escalate_to,omitempty"`     // 升级提醒额外发送的渠道
+}
+
+// quietHours 每天的静默时段，End 早于 Start 时跨越午夜
+type quietHours struct {
+	Start    string `json:"start"`              // 如 "23:00"
+	End      string `json:"end"`                // 如 "07:00"
+	Timezone string `json:"timezone,omitempty"` // 默认 Asia/Shanghai
+
+	loc        *time.Location
+	start, end int // 当天的分钟数
+}
+
+// parseClock 解析 HH:MM 为当天的分钟数
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (q *quietHours) normalize() error {
+	var err error
+	if q.start, err = parseClock(q.Start); err != nil {
+		return err
+	}
+	if q.end, err = parseClock(q.End); err != nil {
+		return err
+	}
+	if q.Timezone == "" {
+		q.Timezone = "Asia/Shanghai"
+	}
+	q.loc, err = time.LoadLocation(q.Timezone)
+	return err
+}
+
+// contains 判断 t 是否在静默时段内
+func (q *quietHours) contains(t time.Time) bool {
+	if q == nil || q.loc == nil || q.start == q.end {
+		return false
+	}
+	t = t.In(q.loc)
+	m := t.Hour()*60 + t.Minute()
+	if q.start < q.end {
+		return m >= q.start && m < q.end
+	}
+	return m >= q.start || m < q.end
+}
+
+func (p *notifyPolicy) normalize() error {
+	if p.EscalateWindow <= 0 {
+		p.EscalateWindow = Duration(24 * time.Hour)
+	}
+	if p.QuietHours != nil {
+		if err := p.QuietHours.normalize(); err != nil {
+			return fmt.Errorf("quiet_hours: %w", err)
+		}
+	}
+	return nil
+}
+
+// ThrottleState 每个 规则|代币 的提醒状态，规则为 * 时记录代币在任意规则下的最后提醒时间
+type ThrottleState struct {
+	Key         string  `gorm:"primaryKey"`
+	LastBar     int64   // 最后一次触发的K线开盘时间，同一根K线只计一次
+	LastNotify  int64   // 最后一次提醒的时间（毫秒）
+	EscalatedAt int64   // 最后一次升级的时间（毫秒）
+	Triggers    []int64 `gorm:"serializer:json"` // 观察窗口内的触发时间（毫秒）
+}
+
+// PendingAlert 因摘要、静默时段或频率上限暂存的提醒
+type PendingAlert struct {
+	ID       uint   `gorm:"primaryKey"`
+	Rule     string `gorm:"index"`
+	Title    string
+	Interval string
+	Symbol   AlertSymbol `gorm:"serializer:json"`
+	HeldAt   int64       // 暂存时间（毫秒）
+}
+
+// NotifyLog 已发送的提醒消息，用于频率上限
+type NotifyLog struct {
+	ID     uint `gorm:"primaryKey"`
+	Rule   string
+	SentAt int64 `gorm:"index"`
+}
+
+// migrateThrottleTables 创建提醒策略的状态表
+func migrateThrottleTables(db *gorm.DB) error {
+	return db.AutoMigrate(&ThrottleState{}, &PendingAlert{}, &NotifyLog{})
+}
+
+// throttleMu 串行化状态表的读写，不同规则的检查可能并发执行
+var throttleMu sync.Mutex
+
+// alertDecision 一次触发的处理结果
+type alertDecision struct {
+	Notify    bool // 需要提醒
+	Escalated bool // 反复触发，升级提醒
+	Triggers  int  // 观察窗口内的触发次数，包括本次
+}
+
+func loadThrottleState(db *gorm.DB, key string) (ThrottleState, error) {
+	st := ThrottleState{Key: key}
+	err := db.Where("key = ?", key).Limit(1).Find(&st).Error
+	return st, err
+}
+
+// admit 记录规则在代币上的一次触发，并按冷却时间和升级条件判断是否提醒
+func (p notifyPolicy) admit(db *gorm.DB, rule SignalRule, symbol string, barTime int64, now time.Time) (alertDecision, error) {
+	throttleMu.Lock()
+	defer throttleMu.Unlock()
+
+	st, err := loadThrottleState(db, rule.Name+"|"+symbol)
+	if err != nil {
+		return alertDecision{}, err
+	}
+	if barTime <= st.LastBar {
+		return alertDecision{}, nil
+	}
+	sym, err := loadThrottleState(db, "*|"+symbol)
+	if err != nil {
+		return alertDecision{}, err
+	}
+
+	ms := now.UnixMilli()
+	window := time.Duration(p.EscalateWindow).Milliseconds()
+	st.LastBar = barTime
+	st.Triggers = append(slices.DeleteFunc(st.Triggers, func(t int64) bool { return t <= ms-window }), ms)
+
+	d := alertDecision{Triggers: len(st.Triggers)}
+	if p.EscalateAfter > 0 && d.Triggers >= p.EscalateAfter && ms-st.EscalatedAt >= window {
+		d.Notify, d.Escalated = true, true
+		st.EscalatedAt = ms
+	} else {
+		d.Notify = ms-st.LastNotify >= time.Duration(rule.Cooldown).Milliseconds() &&
+			ms-sym.LastNotify >= time.Duration(p.SymbolCooldown).Milliseconds()
+	}
+	if d.Notify {
+		st.LastNotify, sym.LastNotify = ms, ms
+	}
+	return d, db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&st).Error; err != nil {
+			return err
+		}
+		return tx.Save(&sym).Error
+	})
+}
+
+// holdReason 返回提醒需要暂存的原因，可以立即发送时为空
+func (p notifyPolicy) holdReason(db *gorm.DB, now time.Time) string {
+	switch {
+	case p.QuietHours.contains(now):
+		return "静默时段"
+	case p.Digest > 0:
+		return "摘要"
+	case p.rateLimited(db, now):
+		return "频率上限"
+	}
+	return ""
+}
+
+// rateLimited 判断最近一小时发送的消息是否已达上限
+func (p notifyPolicy) rateLimited(db *gorm.DB, now time.Time) bool {
+	if p.MaxPerHour <= 0 {
+		return false
+	}
+	var sent int64
+	db.Model(&NotifyLog{}).Where("sent_at > ?", now.Add(-time.Hour).UnixMilli()).Count(&sent)
+	return sent >= int64(p.MaxPerHour)
+}
+
+// deliverAlert 发送或暂存一条规则的提醒，升级提醒不受静默时段、摘要和频率上限限制
+func (r *NotifyRouter) deliverAlert(db *gorm.DB, msg AlertMessage, now time.Time) error {
+	if len(msg.Symbols) == 0 {
+		return nil
+	}
+	if !msg.Escalated {
+		if held, err := r.holdAlert(db, msg, now); held || err != nil {
+			return err
+		}
+	}
+	return r.sendAlert(db, msg, now)
+}
+
+// holdAlert 需要暂存时把提醒写入暂存表，返回是否已暂存
+func (r *NotifyRouter) holdAlert(db *gorm.DB, msg AlertMessage, now time.Time) (bool, error) {
+	throttleMu.Lock()
+	defer throttleMu.Unlock()
+	reason := r.policy.holdReason(db, now)
+	if reason == "" {
+		return false, nil
+	}
+	pending := make([]PendingAlert, len(msg.Symbols))
+	for i, s := range msg.Symbols {
+		pending[i] = PendingAlert{Rule: msg.Rule, Title: msg.Title, Interval: msg.Interval, Symbol: s, HeldAt: now.UnixMilli()}
+	}
+	log.Printf("%s 的 %d 个提醒因%s暂存", msg.Rule, len(pending), reason)
+	return true, db.Create(&pending).Error
+}
+
+// sendAlert 渲染并立即发送提醒，附带K线图并记录发送时间。
+// 任一渠道发送成功即视为已发送，其余渠道的失败只记录日志，全部失败时返回错误
+func (r *NotifyRouter) sendAlert(db *gorm.DB, msg AlertMessage, now time.Time) error {
+	symbols := make([]string, len(msg.Symbols))
+	for i, s := range msg.Symbols {
+		symbols[i] = s.Symbol
+	}
+	// 纯文本正文用于日志和没有按渠道渲染的情况，各渠道按自己的格式重新渲染
+	text, err := r.templates.render("", formatPlain, msg)
+	if err != nil {
+		log.Printf("渲染 %s 的消息模板失败: %v", msg.Rule, err)
+		text = msg.Title + "\n" + strings.Join(symbols, "\n")
+	}
+	n := Notification{Rule: msg.Rule, Title: r.templates.title(msg.Title), Text: text, Symbols: symbols, Message: &msg}
+	n.Images = alertCharts(db, msg.Interval, symbols)
+	delivered, err := r.deliver(n)
+	if delivered == 0 {
+		return err
+	}
+	if err != nil {
+		log.Printf("%s 的部分渠道发送失败: %v", msg.Rule, err)
+	}
+	// 只记录发送成功的消息，发送失败不占用频率上限
+	if err := db.Create(&NotifyLog{Rule: msg.Rule, SentAt: now.UnixMilli()}).Error; err != nil {
+		log.Printf("记录提醒发送失败: %v", err)
+	}
+	log.Printf("已发送通知，内容: %s", text)
+	return nil
+}
+
+// pendingBatch 同一规则合并后待发送的暂存提醒
+type pendingBatch struct {
+	msg AlertMessage
+	ids []uint
+}
+
+// pendingBatches 静默时段结束、摘要窗口到期时按规则合并暂存的提醒
+func (r *NotifyRouter) pendingBatches(db *gorm.DB, now time.Time) ([]pendingBatch, error) {
+	throttleMu.Lock()
+	defer throttleMu.Unlock()
+	if r.policy.QuietHours.contains(now) {
+		return nil, nil
+	}
+	// 静音期间保留暂存的提醒，解除静音后再发送
+	if _, muted := mutedUntil(now); muted {
+		return nil, nil
+	}
+	var pending []PendingAlert
+	if err := db.Order("id ASC").Find(&pending).Error; err != nil || len(pending) == 0 {
+		return nil, err
+	}
+	if now.UnixMilli()-pending[0].HeldAt < time.Duration(r.policy.Digest).Milliseconds() {
+		return nil, nil
+	}
+
+	var batches []pendingBatch
+	byRule := map[string]int{}
+	index := map[string]map[string]int{}
+	for _, p := range pending {
+		i, ok := byRule[p.Rule]
+		if !ok {
+			i = len(batches)
+			byRule[p.Rule] = i
+			index[p.Rule] = map[string]int{}
+			batches = append(batches, pendingBatch{msg: AlertMessage{Rule: p.Rule, Time: now}})
+		}
+		b := &batches[i]
+		b.msg.Title, b.msg.Interval = p.Title, p.Interval
+		b.ids = append(b.ids, p.ID)
+		// 同一代币多次暂存时只保留最新的一条，Triggers 取最大值
+		if j, ok := index[p.Rule][p.Symbol.Symbol]; ok {
+			p.Symbol.Triggers = max(p.Symbol.Triggers, b.msg.Symbols[j].Triggers)
+			b.msg.Symbols[j] = p.Symbol
+			continue
+		}
+		index[p.Rule][p.Symbol.Symbol] = len(b.msg.Symbols)
+		b.msg.Symbols = append(b.msg.Symbols, p.Symbol)
+	}
+	return batches, nil
+}
+
+// flushPending 按规则合并发送暂存的提醒，未达频率上限时发送，发送成功后才删除暂存记录
+// 发送在 throttleMu 之外进行，通知渠道较慢时不阻塞信号检查
+func (r *NotifyRouter) flushPending(db *gorm.DB, now time.Time) error {
+	batches, err := r.pendingBatches(db, now)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, b := range batches {
+		if r.policy.rateLimited(db, now) {
+			break
+		}
+		if err := r.sendAlert(db, b.msg, now); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.msg.Rule, err))
+			continue
+		}
+		if err := db.Delete(&PendingAlert{}, b.ids).Error; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// startNotifyPolicy 每分钟检查一次暂存的提醒
+func startNotifyPolicy(db *gorm.DB) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := notifier.flushPending(db, time.Now()); err != nil {
+				log.Printf("发送暂存提醒失败: %v", err)
+			}
+		}
+	}()
+}
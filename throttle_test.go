@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func throttleTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateThrottleTables(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestPolicyAdmit(t *testing.T) {
+	db := throttleTestDB(t)
+	p := notifyPolicy{SymbolCooldown: Duration(time.Hour), EscalateAfter: 3}
+	if err := p.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	cross := SignalRule{Name: "cross", Cooldown: Duration(4 * time.Hour)}
+	above := SignalRule{Name: "above", Cooldown: Duration(time.Minute)}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	bar := func(i int) int64 { return start.Add(time.Duration(i) * 15 * time.Minute).UnixMilli() }
+	at := func(i int) time.Time { return start.Add(time.Duration(i)*15*time.Minute + 30*time.Second) }
+
+	steps := []struct {
+		rule   SignalRule
+		bar    int
+		notify bool
+		esc    bool
+	}{
+		{cross, 0, true, false},
+		{cross, 0, false, false}, // 同一根K线重复检查
+		{above, 1, false, false}, // 代币冷却期内
+		{cross, 2, false, false}, // 规则冷却期内
+		{cross, 3, true, true},   // 第 3 次触发升级
+		{cross, 4, false, false}, // 观察窗口内只升级一次
+		{above, 8, true, false},  // 代币冷却期已过
+	}
+	for i, s := range steps {
+		d, err := p.admit(db, s.rule, "BTCUSDT", bar(s.bar), at(s.bar))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Notify != s.notify || d.Escalated != s.esc {
+			t.Fatalf("step %d: got %+v", i, d)
+		}
+	}
+
+	// 状态保存在数据库中，重启后冷却仍然有效
+	d, _ := p.admit(db, cross, "BTCUSDT", bar(9), at(9))
+	if d.Notify || d.Triggers != 5 {
+		t.Fatalf("state not persisted: %+v", d)
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	q := &quietHours{Start: "23:00", End: "07:00"}
+	if err := q.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+	for hour, want := range map[int]bool{22: false, 23: true, 2: true, 6: true, 7: false, 12: false} {
+		if got := q.contains(time.Date(2026, 1, 1, hour, 30, 0, 0, shanghai)); got != want {
+			t.Fatalf("%02d:30 quiet = %v, want %v", hour, got, want)
+		}
+	}
+	if err := (&quietHours{Start: "25:00", End: "07:00"}).normalize(); err == nil {
+		t.Fatal("expected invalid time error")
+	}
+}
+
+func TestDigestAndRateCap(t *testing.T) {
+	t.Setenv("ALERT_CHARTS", "off")
+	db := throttleTestDB(t)
+	slack, bodies, _ := recordingServer(t, 0, 0)
+	router, err := newNotifyRouter(notifyConfig{
+		Channels: map[string]channelConfig{"slack": {Type: "slack", URL: slack.URL}},
+		Policy:   notifyPolicy{Digest: Duration(10 * time.Minute), MaxPerHour: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	alert := func(rule string, symbols ...string) AlertMessage {
+		msg := AlertMessage{Rule: rule, Title: rule, Interval: "15m"}
+		for _, s := range symbols {
+			msg.Symbols = append(msg.Symbols, AlertSymbol{Symbol: s})
+		}
+		return msg
+	}
+	for _, msg := range []AlertMessage{alert("a", "BTCUSDT"), alert("a", "ETHUSDT", "BTCUSDT"), alert("b", "SOLUSDT"), alert("c", "XRPUSDT")} {
+		if err := router.deliverAlert(db, msg, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(*bodies) != 0 {
+		t.Fatal("alerts should be held for the digest window")
+	}
+
+	router.flushPending(db, now.Add(5*time.Minute))
+	if len(*bodies) != 0 {
+		t.Fatal("digest window has not elapsed")
+	}
+
+	// 每条规则合并为一条消息，第三条规则超过频率上限继续暂存
+	router.flushPending(db, now.Add(10*time.Minute))
+	if len(*bodies) != 2 {
+		t.Fatalf("expected 2 digest messages, got %d", len(*bodies))
+	}
+	var payload map[string]string
+	json.Unmarshal((*bodies)[0], &payload)
+	if text := payload["text"]; strings.Count(text, "BTCUSDT") != 1 || !strings.Contains(text, "ETHUSDT") {
+		t.Fatalf("unexpected digest: %q", text)
+	}
+	var held int64
+	db.Model(&PendingAlert{}).Count(&held)
+	if held != 1 {
+		t.Fatalf("expected 1 held alert, got %d", held)
+	}
+
+	// 升级提醒不受摘要和频率上限限制
+	escalated := alert("a", "DOGEUSDT")
+	escalated.Escalated = true
+	router.deliverAlert(db, escalated, now.Add(11*time.Minute))
+	if len(*bodies) != 3 {
+		t.Fatal("escalated alert should be sent immediately")
+	}
+
+	router.flushPending(db, now.Add(80*time.Minute))
+	db.Model(&PendingAlert{}).Count(&held)
+	if len(*bodies) != 4 || held != 0 {
+		t.Fatalf("held alert should be sent after the rate window, sent=%d held=%d", len(*bodies), held)
+	}
+}
+
+func TestFlushPendingKeepsFailedAlerts(t *testing.T) {
+	t.Setenv("ALERT_CHARTS", "off")
+	db := throttleTestDB(t)
+	slack, bodies, _ := recordingServer(t, 1, 400)
+	router, err := newNotifyRouter(notifyConfig{
+		Channels: map[string]channelConfig{"slack": {Type: "slack", URL: slack.URL}},
+		Policy:   notifyPolicy{Digest: Duration(10 * time.Minute), MaxPerHour: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	msg := AlertMessage{Rule: "a", Title: "a", Interval: "15m", Symbols: []AlertSymbol{{Symbol: "BTCUSDT"}}}
+	if err := router.deliverAlert(db, msg, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// 发送失败时保留暂存记录，也不计入频率上限
+	if err := router.flushPending(db, now.Add(10*time.Minute)); err == nil {
+		t.Fatal("expected send error")
+	}
+	var held, logged int64
+	db.Model(&PendingAlert{}).Count(&held)
+	db.Model(&NotifyLog{}).Count(&logged)
+	if held != 1 || logged != 0 {
+		t.Fatalf("failed send should keep the alert: held=%d logged=%d", held, logged)
+	}
+
+	if err := router.flushPending(db, now.Add(11*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&PendingAlert{}).Count(&held)
+	db.Model(&NotifyLog{}).Count(&logged)
+	if len(*bodies) != 1 || held != 0 || logged != 1 {
+		t.Fatalf("retry should send the alert: sent=%d held=%d logged=%d", len(*bodies), held, logged)
+	}
+}
+
+func TestFlushPendingPartialFailureAndMute(t *testing.T) {
+	t.Setenv("ALERT_CHARTS", "off")
+	db := throttleTestDB(t)
+	slack, slackBodies, _ := recordingServer(t, 0, 0)
+	discord, _, discordCalls := recordingServer(t, 1<<30, 400)
+	router, err := newNotifyRouter(notifyConfig{
+		Channels: map[string]channelConfig{"slack": {Type: "slack", URL: slack.URL}, "discord": {Type: "discord", URL: discord.URL}},
+		Policy:   notifyPolicy{Digest: Duration(10 * time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { muteNotifications(time.Now()) })
+	now := time.Now()
+	msg := AlertMessage{Rule: "a", Title: "a", Interval: "15m", Symbols: []AlertSymbol{{Symbol: "BTCUSDT"}}}
+	if err := router.deliverAlert(db, msg, now); err != nil {
+		t.Fatal(err)
+	}
+	count := func() (held, logged int64) {
+		db.Model(&PendingAlert{}).Count(&held)
+		db.Model(&NotifyLog{}).Count(&logged)
+		return
+	}
+
+	// 静音期间不发送也不删除暂存的提醒
+	muteNotifications(time.Now().Add(time.Hour))
+	if err := router.flushPending(db, now.Add(10*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if held, logged := count(); held != 1 || logged != 0 || len(*slackBodies) != 0 {
+		t.Fatalf("muted flush should keep the alert: held=%d logged=%d sent=%d", held, logged, len(*slackBodies))
+	}
+
+	// 解除静音后发送，一个渠道持续失败时其他渠道收到后即删除，不再重复发送
+	muteNotifications(time.Now())
+	for i := range 2 {
+		if err := router.flushPending(db, now.Add(time.Duration(11+i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if held, logged := count(); held != 0 || logged != 1 || len(*slackBodies) != 1 || discordCalls.Load() != 1 {
+		t.Fatalf("digest should be sent once: held=%d logged=%d slack=%d discord=%d", held, logged, len(*slackBodies), discordCalls.Load())
+	}
+}